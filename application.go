@@ -2,6 +2,7 @@ package mpesa
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,13 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/breaker"
+	"github.com/mobilemoney/mpesa/pubkey"
+	"github.com/mobilemoney/mpesa/secret"
 )
 
 // APIEnviroment ...
@@ -31,6 +39,10 @@ const (
 
 	// VodacomTanzania country code TZN and currency code TZS
 	VodacomTanzania Market = "vodacomTZN"
+
+	// OpGetSession names the session key generation operation in metrics,
+	// traces and limits.
+	OpGetSession = "getSession"
 )
 
 // Application ...
@@ -47,23 +59,101 @@ type Application struct {
 	// Session Key acts as an access token that authorises the rest of your REST API calls to the system
 	SessionKey string
 
+	// Organisation shortcode receiving or sending funds on behalf of the application.
+	ServiceProviderCode string
+
+	// mu guards Key, SessionKey, keyFingerprint and sessionAt
+	mu sync.RWMutex
+
 	market Market
+
+	metrics Metrics
+
+	tracer Tracer
+
+	limiters map[string]*limiter
+
+	breakers map[string]*breaker.Breaker
+
+	secrets secret.Provider
+
+	// rotation serialises the sessions generated for a rotated key
+	rotation sync.Mutex
+
+	// publicKey overrides the process wide public keys when set
+	publicKey *pubkey.Set
+
+	// keyFingerprint identifies the public key of the current session
+	keyFingerprint string
+
+	// sessionAt is when the current session key was generated
+	sessionAt time.Time
+
+	journal Journal
+
+	events *EventBus
+
+	// validate requests before sending them
+	validate bool
+
+	// metaBodyLimit caps the raw response bodies kept in Meta
+	metaBodyLimit int
+
+	// sessionRetry resends requests rejected with 401 with a new session key
+	sessionRetry bool
 }
 
-// NewApplication creates and returns new mpesa application
-// you can pass an empty applicationKey as long as MPESA_APLICATION_KEY env has been set in your enviroment.
-func NewApplication(applicationKey string, applicationMarket Market, apiType APIEnviroment) (*Application, error) {
-	if apiType == "" || applicationMarket == "" {
-		return nil, errors.New("Failed to create new application")
+// ResponseError is returned when the API answers with a non 2xx status.
+type ResponseError struct {
+	StatusCode int
+
+	// Code and Description are the output_ResponseCode and
+	// output_ResponseDesc of the response, when it carried them.
+	Code        string `json:"output_ResponseCode"`
+	Description string `json:"output_ResponseDesc"`
+
+	// Meta is the HTTP exchange the error was decoded from.
+	Meta *Meta `json:"-"`
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("mpesa: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
 	}
+	return fmt.Sprintf("mpesa: %d %s: %s", e.StatusCode, e.Code, e.Description)
+}
 
-	if applicationKey == "" {
-		var key string
+// Option configures optional behaviour of an Application.
+type Option func(*Application)
 
-		if key = os.Getenv("MPESA_APPLICATION_KEY"); key == "" {
-			return nil, errors.New("failed to create new application, application key is missing")
+// WithHTTPClient sends every request through client, e.g. to share one
+// transport between applications or to set timeouts.
+func WithHTTPClient(client *http.Client) Option {
+	return func(app *Application) {
+		if client != nil {
+			app.client = client
 		}
-		applicationKey = key
+	}
+}
+
+// WithServiceProviderCode sets the organisation shortcode used by the application.
+func WithServiceProviderCode(code string) Option {
+	return func(app *Application) {
+		app.ServiceProviderCode = code
+	}
+}
+
+// responseCoder is implemented by API responses carrying output_ResponseCode.
+type responseCoder interface {
+	responseCode() string
+}
+
+// NewApplication creates and returns new mpesa application
+// you can pass an empty applicationKey as long as MPESA_APLICATION_KEY env has been set in your enviroment,
+// or a secret provider has been configured with WithSecretProvider.
+func NewApplication(applicationKey string, applicationMarket Market, apiType APIEnviroment, opts ...Option) (*Application, error) {
+	if apiType == "" || applicationMarket == "" {
+		return nil, errors.New("Failed to create new application")
 	}
 
 	app := &Application{
@@ -72,6 +162,24 @@ func NewApplication(applicationKey string, applicationMarket Market, apiType API
 		Key:        applicationKey,
 		SessionKey: "",
 		market:     applicationMarket,
+		metrics:    nopMetrics{},
+		tracer:     nopTracer{},
+		events:     NewEventBus(),
+
+		metaBodyLimit: DefaultMetaBodyLimit,
+	}
+
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	if app.Key == "" && app.secrets == nil {
+		var key string
+
+		if key = os.Getenv("MPESA_APPLICATION_KEY"); key == "" {
+			return nil, errors.New("failed to create new application, application key is missing")
+		}
+		app.Key = key
 	}
 
 	if _, err := app.getSessionKey(); err != nil {
@@ -81,8 +189,20 @@ func NewApplication(applicationKey string, applicationMarket Market, apiType API
 	return app, nil
 }
 
+// Market returns the market the application operates in.
+func (app *Application) Market() Market {
+	return app.market
+}
+
+// sessionKey returns the current session key.
+func (app *Application) sessionKey() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.SessionKey
+}
+
 // newRequest create new *http.Request with additional headers parameters required by MPESA API
-func (app *Application) newRequest(method, url string, payload interface{}) (*http.Request, error) {
+func (app *Application) newRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
 
 	var buf io.Reader
 
@@ -95,44 +215,153 @@ func (app *Application) newRequest(method, url string, payload interface{}) (*ht
 		buf = bytes.NewBuffer(b)
 	}
 
-	req, err := http.NewRequest(method, url, buf)
+	req, err := http.NewRequestWithContext(ctx, method, url, buf)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", app.SessionKey))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", app.sessionKey()))
 	req.Header.Set("Origin", "*")
 
 	return req, nil
 }
 
-// send makes a request to the API, the response body will be unmarshaled into v
-func (app *Application) send(req *http.Request, v interface{}) error {
+// send makes a request to the API on behalf of operation op, the response body will be unmarshaled into v.
+func (app *Application) send(op string, req *http.Request, v interface{}) error {
+	if op != OpGetSession {
+		rotated, err := app.checkRotation(req.Context())
+		if err != nil {
+			return err
+		}
+		if rotated {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", app.sessionKey()))
+		}
+	}
 
-	resp, err := app.client.Do(req)
+	// The limiter is waited on first, so that a request timing out in its
+	// queue neither counts as a breaker failure nor holds a half-open probe.
+	release, err := app.acquire(req.Context(), op)
+	if err != nil {
+		return err
+	}
+
+	record, err := app.allow(op)
 	if err != nil {
+		release()
+		app.metrics.ObserveRequest(op, "circuit_open", 0)
 		return err
 	}
+
+	start := time.Now()
+
+	ctx, span := app.tracer.Start(req.Context(), op)
+	req = req.WithContext(ctx)
+	span.SetAttribute(AttrMarket, string(app.market))
+	span.SetAttribute(AttrEnvironment, string(app.Type))
+	if tp := span.TraceParent(); tp != "" {
+		req.Header.Set("traceparent", tp)
+	}
+
+	sessionAge := app.sessionKeyAge()
+	meta, err := app.do(req, v)
+	release()
+
+	retries := 0
+	if app.canRetry(op, req, meta) && app.renewSession(req) == nil {
+		if release, acquireErr := app.acquire(req.Context(), op); acquireErr == nil {
+			app.metrics.IncRetry(op)
+			retries++
+			sessionAge = app.sessionKeyAge()
+			meta, err = app.do(req, v)
+			release()
+		}
+	}
+
+	status := 0
+	if meta != nil {
+		status = meta.StatusCode
+		meta.Latency = time.Since(start)
+		meta.Retries = retries
+		meta.SessionKeyAge = sessionAge
+
+		if m, ok := v.(metaSetter); ok {
+			m.setMeta(meta)
+		}
+		if respErr, ok := err.(*ResponseError); ok {
+			respErr.setMeta(meta)
+		}
+	}
+
+	record(status, err)
+
+	code := outcome(status, v, err)
+	app.metrics.ObserveRequest(op, code, time.Since(start))
+
+	span.SetAttribute(AttrResponseCode, code)
+	if status != 0 {
+		span.SetAttribute(AttrHTTPStatus, strconv.Itoa(status))
+	}
+	if c, ok := v.(conversationIDer); ok && c.conversationID() != "" {
+		span.SetAttribute(AttrConversationID, c.conversationID())
+	}
+	span.End(err)
+
+	return err
+}
+
+// do performs a single round trip and returns the Meta of the response, or
+// nil when no response was received.
+func (app *Application) do(req *http.Request, v interface{}) (*Meta, error) {
+
+	resp, err := app.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
+	data, readErr := ioutil.ReadAll(resp.Body)
+	meta := app.newMeta(resp, data)
+
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		data, err := ioutil.ReadAll(resp.Body)
+		respErr := &ResponseError{StatusCode: resp.StatusCode}
 
-		if err == nil && len(data) > 0 {
+		if readErr == nil && len(data) > 0 {
 			json.Unmarshal(data, &v)
+			json.Unmarshal(data, respErr)
 		}
 
-		return errors.New("Handle this error")
+		return meta, respErr
+	}
+
+	if readErr != nil {
+		return meta, readErr
 	}
 
 	if v != nil {
 
-		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
-			return err
+		if err := json.Unmarshal(data, &v); err != nil {
+			return meta, err
 		}
 
 	}
 
-	return nil
+	return meta, nil
+}
+
+// outcome returns the response code reported to Metrics for a finished request.
+func outcome(status int, v interface{}, err error) string {
+	if rc, ok := v.(responseCoder); ok && rc.responseCode() != "" {
+		return rc.responseCode()
+	}
+
+	if status != 0 && (status < 200 || status > 299) {
+		return fmt.Sprintf("http_%d", status)
+	}
+
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
 }
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mobilemoney/mpesa/pkg/breaker"
+)
+
+// Circuit breaker names, the session endpoint and the transaction endpoints
+// fail independently of each other.
+const (
+	SessionCircuit     = "session"
+	TransactionCircuit = "transaction"
+)
+
+// ErrCircuitOpen is returned, wrapped in a *CircuitOpenError, when a request
+// is rejected without being sent because its circuit breaker is open.
+var ErrCircuitOpen = errors.New("mpesa: circuit breaker is open")
+
+// CircuitOpenError reports which circuit rejected an operation.
+type CircuitOpenError struct {
+	Circuit   string
+	Operation string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %s circuit rejected %s", ErrCircuitOpen, e.Circuit, e.Operation)
+}
+
+// Unwrap returns ErrCircuitOpen so that errors.Is(err, ErrCircuitOpen) holds.
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// WithCircuitBreaker guards the session endpoint with a breaker configured by
+// session and the transaction endpoints with one configured by transactions.
+// Transport errors and 5xx responses count as failures, a caller cancelling
+// its own context does not, nor does a request timing out while waiting for
+// its operation limiter since it is never sent.
+func WithCircuitBreaker(session, transactions breaker.Settings) Option {
+	return func(app *Application) {
+		app.breakers = map[string]*breaker.Breaker{
+			SessionCircuit:     breaker.New(session),
+			TransactionCircuit: breaker.New(transactions),
+		}
+	}
+}
+
+// circuitFor returns the circuit guarding operation.
+func circuitFor(operation string) string {
+	if operation == OpGetSession {
+		return SessionCircuit
+	}
+	return TransactionCircuit
+}
+
+// allow asks the breaker guarding operation whether it may be sent. The
+// returned function records the outcome and must be called exactly once.
+func (app *Application) allow(operation string) (func(status int, err error), error) {
+	b, ok := app.breakers[circuitFor(operation)]
+	if !ok {
+		return func(int, error) {}, nil
+	}
+
+	done, err := b.Allow()
+	if err != nil {
+		return nil, &CircuitOpenError{Circuit: circuitFor(operation), Operation: operation}
+	}
+
+	return func(status int, err error) {
+		switch {
+		case status == 0 && errors.Is(err, context.Canceled):
+			done(breaker.Ignored)
+		case status == 0 || status >= 500:
+			done(breaker.Failure)
+		default:
+			done(breaker.Success)
+		}
+	}, nil
+}
+
+// CircuitStates returns the state of every configured circuit breaker.
+func (app *Application) CircuitStates() map[string]breaker.State {
+	states := make(map[string]breaker.State, len(app.breakers))
+	for name, b := range app.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
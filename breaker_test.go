@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	payment := mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"}
+
+	cases := []struct {
+		desc     string
+		reply    mpesatest.Reply
+		busy     bool
+		err      error
+		state    breaker.State
+		payments int
+	}{
+		{
+			desc:     "accepted",
+			reply:    mpesatest.Reply{Status: http.StatusCreated, Body: accepted},
+			state:    breaker.Closed,
+			payments: 2,
+		},
+		{
+			desc:     "rejected",
+			reply:    mpesatest.Reply{Status: http.StatusConflict, Body: `{"output_ResponseCode":"INS-10"}`},
+			state:    breaker.Closed,
+			payments: 2,
+		},
+		{
+			desc:     "server error",
+			reply:    mpesatest.Reply{Status: http.StatusServiceUnavailable},
+			err:      mpesa.ErrCircuitOpen,
+			state:    breaker.Open,
+			payments: 1,
+		},
+		{
+			desc:     "limiter timeout",
+			reply:    mpesatest.Reply{Status: http.StatusCreated, Body: accepted},
+			busy:     true,
+			err:      context.DeadlineExceeded,
+			state:    breaker.Closed,
+			payments: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		started, proceed := make(chan struct{}, 1), make(chan struct{})
+		close(proceed)
+		if tc.busy {
+			proceed = make(chan struct{})
+		}
+
+		api := mpesatest.NewAPI().Handle(mpesa.OpC2B, func(*http.Request) mpesatest.Reply {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-proceed
+			return tc.reply
+		})
+		app := mpesatest.NewApplication(t, api,
+			mpesa.WithCircuitBreaker(breaker.Settings{}, breaker.Settings{FailureThreshold: 1}),
+			mpesa.WithLimit(mpesa.OpC2B, mpesa.Limit{MaxInFlight: 1}),
+		)
+
+		first := make(chan struct{})
+		go func() {
+			defer close(first)
+			app.C2B(context.Background(), payment)
+		}()
+		<-started
+		if !tc.busy {
+			<-first
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := app.C2B(ctx, payment)
+		cancel()
+
+		if tc.err != nil {
+			assert.True(t, errors.Is(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+		}
+		state := app.CircuitStates()[mpesa.TransactionCircuit]
+		assert.Equal(t, tc.state, state, fmt.Sprintf("%s: expected circuit %s got %s\n", tc.desc, tc.state, state))
+
+		if tc.busy {
+			close(proceed)
+			<-first
+		}
+		assert.Equal(t, tc.payments, api.Requests(mpesa.OpC2B), fmt.Sprintf("%s: expected %d payment requests\n", tc.desc, tc.payments))
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package bulk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/bulk"
+	"github.com/stretchr/testify/assert"
+)
+
+const payouts = `msisdn,amount,reference
+255744000001,1000,R1
+255744000002,2500.50,R2
+255744000003,300,R3
+255744000004,400,R4
+`
+
+type payer struct {
+	mu    sync.Mutex
+	paid  map[string]int
+	fails map[string]error
+}
+
+func (p *payer) B2C(_ context.Context, b mpesa.B2CPayment) (*mpesa.TransactionResp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err, ok := p.fails[b.TransactionReference]; ok {
+		return &mpesa.TransactionResp{}, err
+	}
+
+	p.paid[b.TransactionReference]++
+	return &mpesa.TransactionResp{Code: "INS-0", TransactionID: "TX-" + b.TransactionReference}, nil
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		desc  string
+		csv   string
+		field string
+	}{
+		{desc: "valid rows", csv: payouts},
+		{desc: "invalid msisdn", csv: "07x4,100,R1\n", field: "line 1: msisdn"},
+		{desc: "zero amount", csv: "255744000001,0.00,R1\n", field: "line 1: amount"},
+		{desc: "negative amount", csv: "255744000001,1,R1\n255744000001,-5,R2\n", field: "line 2: amount"},
+		{desc: "missing reference", csv: "255744000001,100,\n", field: "line 1: reference"},
+		{desc: "duplicate reference", csv: "255744000001,100,R1\n255744000002,100,R1\n", field: "line 2: reference: duplicates line 1"},
+		{desc: "header in upper case", csv: "MSISDN, Amount, Reference\n255744000001,100,R1\n"},
+		{desc: "invalid amount on the first line", csv: "255744000001,1O0,R1\n255744000002,100,R2\n", field: "line 1: amount"},
+		{desc: "other header", csv: "phone,amount,ref\n255744000001,100,R1\n", field: "line 1: amount"},
+		{desc: "msisdn with plus sign", csv: "+255744000001,100,R1\n", field: "line 1: msisdn"},
+		{desc: "short msisdn", csv: "0744000001,100,R1\n", field: "line 1: msisdn"},
+		{desc: "long reference", csv: "255744000001,100,R123456789012345678901\n", field: "line 1: reference: is longer than 20 characters"},
+	}
+
+	for _, tc := range cases {
+		rows, err := bulk.ReadCSV(strings.NewReader(tc.csv))
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected read error\n", tc.desc))
+
+		err = bulk.Validate(rows, tc.desc)
+		if tc.field == "" {
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected validation error\n", tc.desc))
+			continue
+		}
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected validation error\n", tc.desc))
+		if err != nil {
+			assert.Contains(t, err.Error(), tc.field, tc.desc)
+		}
+	}
+}
+
+func TestValidateDescription(t *testing.T) {
+	rows, err := bulk.ReadCSV(strings.NewReader(payouts))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bulk.Validate(rows, "")
+	assert.NotNil(t, err, "missing description: expected validation error\n")
+	if err != nil {
+		assert.Contains(t, err.Error(), "line 2: description: is required", "missing description")
+	}
+}
+
+func TestRunResumesWithoutPayingTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpesa-bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.jsonl")
+
+	rows, err := bulk.ReadCSV(strings.NewReader(payouts))
+	assert.Nil(t, err)
+	assert.Len(t, rows, 4, "expected header to be skipped")
+
+	p := &payer{
+		paid: make(map[string]int),
+		fails: map[string]error{
+			"R3": &mpesa.ResponseError{StatusCode: 400, Code: "INS-2051"},
+			"R4": errors.New("connection reset"),
+		},
+	}
+
+	cp, err := bulk.OpenCheckpoint(path)
+	assert.Nil(t, err)
+	sum, err := (&bulk.Runner{Payer: p, Checkpoint: cp, Concurrency: 2, Description: "Payout"}).Run(context.Background(), rows)
+	assert.Nil(t, err)
+	assert.Equal(t, bulk.Summary{Total: 4, Succeeded: 2, Failed: 1, Unknown: 1}, sum)
+	cp.Close()
+
+	// resume: failures are fixed, only the rejected row may be retried
+	p.fails = nil
+	cp, err = bulk.OpenCheckpoint(path)
+	assert.Nil(t, err)
+	defer cp.Close()
+
+	sum, err = (&bulk.Runner{Payer: p, Checkpoint: cp, RetryFailed: true, Description: "Payout"}).Run(context.Background(), rows)
+	assert.Nil(t, err)
+	assert.Equal(t, bulk.Summary{Total: 4, Skipped: 3, Succeeded: 1}, sum)
+
+	assert.Equal(t, map[string]int{"R1": 1, "R2": 1, "R3": 1}, p.paid, "expected no row to be paid twice")
+
+	var out bytes.Buffer
+	assert.Nil(t, bulk.WriteResults(&out, rows, cp.Outcomes()))
+	assert.Contains(t, out.String(), "255744000001,1000,R1,succeeded,TX-R1")
+	assert.Contains(t, out.String(), "255744000004,400,R4,unknown")
+}
+
+func TestRunInvalidRequest(t *testing.T) {
+	cp, err := bulk.OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	rows, err := bulk.ReadCSV(strings.NewReader("255744000001,1000,R1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &payer{
+		paid:  make(map[string]int),
+		fails: map[string]error{"R1": &mpesa.ValidationError{Field: "input_PaymentItemsDesc", Reason: "is required"}},
+	}
+
+	sum, err := (&bulk.Runner{Payer: p, Checkpoint: cp, Description: "Payout"}).Run(context.Background(), rows)
+	assert.Nil(t, err)
+	assert.Equal(t, bulk.Summary{Total: 1, Failed: 1}, sum, "invalid request: expected the row never sent to fail\n")
+}
+
+func TestCheckpointTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+
+	cp, err := bulk.OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Record(bulk.Outcome{Reference: "R1", Status: bulk.Succeeded})
+	cp.Close()
+
+	// a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"reference":"R2","sta`)
+	f.Close()
+
+	cp, err = bulk.OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Record(bulk.Outcome{Reference: "R3", Status: bulk.Submitting})
+	cp.Close()
+
+	cp, err = bulk.OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	for _, ref := range []string{"R1", "R3"} {
+		_, ok := cp.Outcome(ref)
+		assert.True(t, ok, fmt.Sprintf("expected the outcome of %s to be kept\n", ref))
+	}
+}
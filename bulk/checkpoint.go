@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package bulk
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/internal/jsonl"
+)
+
+// Status of a row.
+type Status string
+
+const (
+	// Pending rows have not been submitted.
+	Pending Status = "pending"
+
+	// Submitting rows have been handed to B2C without a recorded outcome.
+	// Their payment may or may not have happened, so they are never
+	// resubmitted automatically; resolve them with a status query using
+	// their third party conversation ID.
+	Submitting Status = "submitting"
+
+	// Succeeded rows have been accepted by the API.
+	Succeeded Status = "succeeded"
+
+	// Failed rows have been rejected by the API and were not paid.
+	Failed Status = "failed"
+
+	// Unknown rows failed without a response from the API, e.g. on a
+	// timeout, and may have been paid. Like Submitting rows they are never
+	// resubmitted automatically.
+	Unknown Status = "unknown"
+)
+
+// Outcome is the recorded state of a row.
+type Outcome struct {
+	Reference                string    `json:"reference"`
+	Status                   Status    `json:"status"`
+	TransactionID            string    `json:"transaction_id,omitempty"`
+	ConversationID           string    `json:"conversation_id,omitempty"`
+	ThirdPartyConversationID string    `json:"third_party_conversation_id,omitempty"`
+	Code                     string    `json:"response_code,omitempty"`
+	Error                    string    `json:"error,omitempty"`
+	Time                     time.Time `json:"time"`
+}
+
+// Checkpoint is an append only JSON lines file of row outcomes. The last
+// line recorded for a reference wins.
+type Checkpoint struct {
+	mu       sync.Mutex
+	f        *jsonl.File
+	outcomes map[string]Outcome
+}
+
+// OpenCheckpoint opens, or creates, the checkpoint file at path and reads
+// the outcomes already recorded in it.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{outcomes: make(map[string]Outcome)}
+
+	f, err := jsonl.Open(path, func(line []byte) {
+		var o Outcome
+		if err := json.Unmarshal(line, &o); err == nil {
+			cp.outcomes[o.Reference] = o
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp.f = f
+
+	return cp, nil
+}
+
+// Record durably appends o to the checkpoint before returning.
+func (cp *Checkpoint) Record(o Outcome) error {
+	if o.Time.IsZero() {
+		o.Time = time.Now().UTC()
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if err := cp.f.Append(o); err != nil {
+		return err
+	}
+
+	cp.outcomes[o.Reference] = o
+	return nil
+}
+
+// Outcome returns the recorded outcome of reference.
+func (cp *Checkpoint) Outcome(reference string) (Outcome, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	o, ok := cp.outcomes[reference]
+	return o, ok
+}
+
+// Outcomes returns a copy of every recorded outcome keyed by reference.
+func (cp *Checkpoint) Outcomes() map[string]Outcome {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	outcomes := make(map[string]Outcome, len(cp.outcomes))
+	for ref, o := range cp.outcomes {
+		outcomes[ref] = o
+	}
+	return outcomes
+}
+
+// Close closes the checkpoint file.
+func (cp *Checkpoint) Close() error {
+	return cp.f.Close()
+}
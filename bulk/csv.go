@@ -0,0 +1,198 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package bulk pays out to many customers through B2C from a CSV file of
+// (MSISDN, amount, reference) rows, recording every outcome to a checkpoint
+// file so that an interrupted run can be resumed without paying anyone twice.
+package bulk
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mobilemoney/mpesa"
+)
+
+// Row is a single payout.
+type Row struct {
+
+	// Line is the 1-based line of the row in the input file.
+	Line int
+
+	MSISDN    string
+	Amount    string
+	Reference string
+}
+
+// RowError reports an invalid row.
+type RowError struct {
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+}
+
+// RowErrors lists every invalid row found by Validate.
+type RowErrors []*RowError
+
+func (e RowErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// header is the optional first line of a payout file.
+var header = []string{"msisdn", "amount", "reference"}
+
+// ReadCSV reads rows of MSISDN, amount and reference columns. A first line
+// naming the columns, in any case, is a header and skipped. Rows are not
+// validated, see Validate.
+func ReadCSV(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+
+	var rows []Row
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && isHeader(rec) {
+			continue
+		}
+
+		rows = append(rows, Row{
+			Line:      line,
+			MSISDN:    strings.TrimSpace(rec[0]),
+			Amount:    strings.TrimSpace(rec[1]),
+			Reference: strings.TrimSpace(rec[2]),
+		})
+	}
+}
+
+func isHeader(rec []string) bool {
+	for i, name := range header {
+		if !strings.EqualFold(strings.TrimSpace(rec[i]), name) {
+			return false
+		}
+	}
+	return true
+}
+
+// rowFields names the B2CPayment fields set from a row and its description.
+var rowFields = map[string]string{
+	"input_CustomerMSISDN":       "msisdn",
+	"input_Amount":               "amount",
+	"input_TransactionReference": "reference",
+	"input_PaymentItemsDesc":     "description",
+}
+
+// Validate checks every row up front, as the B2C payment sent for it with
+// description, and reports all invalid ones. References identify rows in the
+// checkpoint and must therefore be unique.
+func Validate(rows []Row, description string) error {
+	var errs RowErrors
+
+	seen := make(map[string]int, len(rows))
+	for _, row := range rows {
+		if err := payment(row, description).Validate(); err != nil {
+			var verr *mpesa.ValidationError
+			if !errors.As(err, &verr) {
+				return err
+			}
+			errs = append(errs, &RowError{Line: row.Line, Field: rowFields[verr.Field], Msg: verr.Reason})
+			continue
+		}
+
+		if strings.Trim(row.Amount, "0.") == "" {
+			errs = append(errs, &RowError{Line: row.Line, Field: "amount", Msg: fmt.Sprintf("invalid amount %q", row.Amount)})
+		}
+
+		if first, dup := seen[row.Reference]; dup {
+			errs = append(errs, &RowError{Line: row.Line, Field: "reference", Msg: fmt.Sprintf("duplicates line %d", first)})
+		} else {
+			seen[row.Reference] = row.Line
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+var resultHeader = []string{
+	"msisdn", "amount", "reference", "status", "transaction_id", "conversation_id",
+	"third_party_conversation_id", "response_code", "error",
+}
+
+// WriteResults writes one line per row with its outcome, rows without an
+// outcome are reported as pending.
+func WriteResults(w io.Writer, rows []Row, outcomes map[string]Outcome) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(resultHeader); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		o, ok := outcomes[row.Reference]
+		if !ok {
+			o.Status = Pending
+		}
+
+		err := cw.Write([]string{
+			row.MSISDN, row.Amount, row.Reference, string(o.Status), o.TransactionID,
+			o.ConversationID, o.ThirdPartyConversationID, o.Code, o.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// payment returns the B2C payment of row. The fields the application fills
+// in are set to valid placeholders, so that only the row and description are
+// validated.
+func payment(row Row, description string) mpesa.B2CPayment {
+	return mpesa.B2CPayment{
+		Amount:                   row.Amount,
+		Country:                  "TZN",
+		Currency:                 "TZS",
+		CustomerMSISDN:           row.MSISDN,
+		ServiceProviderCode:      "000000",
+		ThirdPartyConversationID: "validation",
+		TransactionReference:     row.Reference,
+		PaymentItemsDesc:         description,
+	}
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/pkg/ratelimit"
+)
+
+const (
+	defConcurrency = 4
+
+	codeSuccess = "INS-0"
+
+	// codeTimeout means the API gave up waiting, the payment may still
+	// have been made
+	codeTimeout = "INS-9"
+)
+
+// Payer submits B2C payments, *mpesa.Application implements it.
+type Payer interface {
+	B2C(ctx context.Context, p mpesa.B2CPayment) (*mpesa.TransactionResp, error)
+}
+
+// Summary counts the outcomes of a run.
+type Summary struct {
+	Total     int
+	Skipped   int
+	Succeeded int
+	Failed    int
+	Unknown   int
+}
+
+// Runner submits rows through a Payer.
+type Runner struct {
+	Payer Payer
+
+	// Checkpoint records every outcome, rows already recorded in it are
+	// not submitted again.
+	Checkpoint *Checkpoint
+
+	// Concurrency is the number of payouts in flight, defaults to 4.
+	Concurrency int
+
+	// Rate limits payouts per second, zero for no limit.
+	Rate float64
+
+	// Description is sent as the payment items description of every row,
+	// it is required.
+	Description string
+
+	// RetryFailed resubmits rows recorded as Failed, which the API
+	// rejected and therefore did not pay.
+	RetryFailed bool
+
+	// OnOutcome, if set, is called with the final outcome of every
+	// submitted row.
+	OnOutcome func(Row, Outcome)
+}
+
+// Run validates every row, then submits the rows without a recorded outcome.
+// It stops submitting when ctx is cancelled and returns once in-flight
+// payouts have been recorded. An error recording an outcome stops the run,
+// since rows could otherwise be paid twice on resume.
+func (r *Runner) Run(ctx context.Context, rows []Row) (Summary, error) {
+	sum := Summary{Total: len(rows)}
+
+	if err := Validate(rows, r.Description); err != nil {
+		return sum, err
+	}
+
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = defConcurrency
+	}
+
+	var bucket *ratelimit.TokenBucket
+	if r.Rate > 0 {
+		bucket = ratelimit.NewTokenBucket(r.Rate, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+		queue    = make(chan Row)
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range queue {
+				o, err := r.pay(ctx, row)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				case o.Status == Succeeded:
+					sum.Succeeded++
+				case o.Status == Failed:
+					sum.Failed++
+				default:
+					sum.Unknown++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, row := range rows {
+		if o, ok := r.Checkpoint.Outcome(row.Reference); ok && !(r.RetryFailed && o.Status == Failed) {
+			mu.Lock()
+			sum.Skipped++
+			mu.Unlock()
+			continue
+		}
+
+		if bucket != nil {
+			if err := bucket.Wait(ctx); err != nil {
+				break feed
+			}
+		}
+
+		select {
+		case queue <- row:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return sum, firstErr
+	}
+
+	return sum, ctx.Err()
+}
+
+// pay records row as submitting, pays it and records the outcome.
+func (r *Runner) pay(ctx context.Context, row Row) (Outcome, error) {
+	o := Outcome{
+		Reference:                row.Reference,
+		Status:                   Submitting,
+		ThirdPartyConversationID: mpesa.NewConversationID(),
+	}
+
+	if err := r.Checkpoint.Record(o); err != nil {
+		return o, err
+	}
+
+	resp, err := r.Payer.B2C(ctx, mpesa.B2CPayment{
+		Amount:                   row.Amount,
+		CustomerMSISDN:           row.MSISDN,
+		ThirdPartyConversationID: o.ThirdPartyConversationID,
+		TransactionReference:     row.Reference,
+		PaymentItemsDesc:         r.Description,
+	})
+
+	if resp != nil {
+		o.TransactionID = resp.TransactionID
+		o.ConversationID = resp.ConversationID
+		o.Code = resp.Code
+	}
+
+	o.Status = classify(resp, err)
+	if err != nil {
+		o.Error = err.Error()
+	}
+
+	if err := r.Checkpoint.Record(o); err != nil {
+		return o, err
+	}
+
+	if r.OnOutcome != nil {
+		r.OnOutcome(row, o)
+	}
+
+	return o, nil
+}
+
+// classify decides whether a payment was made, was not made or may have been made.
+func classify(resp *mpesa.TransactionResp, err error) Status {
+	var respErr *mpesa.ResponseError
+
+	switch {
+	case err == nil && resp != nil && resp.Code == codeSuccess:
+		return Succeeded
+
+	case errors.Is(err, mpesa.ErrCircuitOpen), errors.Is(err, mpesa.ErrInvalidRequest):
+		// never sent
+		return Failed
+
+	case errors.As(err, &respErr):
+		if respErr.StatusCode >= 500 || respErr.Code == codeTimeout {
+			return Unknown
+		}
+		return Failed
+
+	case err == nil:
+		// accepted at the HTTP level with an unexpected response code
+		return Failed
+	}
+
+	return Unknown
+}
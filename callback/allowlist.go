@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package callback
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// Allowlist admits callbacks from trusted source addresses, e.g. the
+// TrustedSources of a session.Application.
+type Allowlist struct {
+	sources []*net.IPNet
+	proxies []*net.IPNet
+}
+
+// NewAllowlist returns an allowlist of sources, each an IP address or a CIDR
+// range. Callbacks relayed by one of proxies, also IP addresses or CIDR
+// ranges, are checked against the client address in their X-Forwarded-For
+// header instead of the proxy's address.
+func NewAllowlist(sources, proxies []string) (*Allowlist, error) {
+	a := &Allowlist{}
+
+	var err error
+	if a.sources, err = parseNets(sources); err != nil {
+		return nil, err
+	}
+
+	if a.proxies, err = parseNets(proxies); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// ParseSource parses an IP address or CIDR range.
+func ParseSource(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSource, errors.New(s))
+		}
+		return n, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Wrap(ErrInvalidSource, errors.New(s))
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func parseNets(sources []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(sources))
+	for _, src := range sources {
+		n, err := ParseSource(src)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the address of the client that sent r. When the peer is
+// a trusted proxy, X-Forwarded-For is walked from the right, skipping
+// trusted proxies, to the first address appended by an untrusted hop, which
+// is the only one that could not have been forged by the client.
+func (a *Allowlist) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(a.proxies, ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// a malformed hop cannot be trusted, nor can anything before it
+			return nil
+		}
+
+		ip = hop
+		if !contains(a.proxies, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Allowed reports whether the client that sent r is a trusted source.
+func (a *Allowlist) Allowed(r *http.Request) bool {
+	ip := a.ClientIP(r)
+	return ip != nil && contains(a.sources, ip)
+}
+
+// Verify implements Verifier.
+func (a *Allowlist) Verify(r *http.Request, _ []byte) error {
+	if a.Allowed(r) {
+		return nil
+	}
+
+	ip := "unknown"
+	if client := a.ClientIP(r); client != nil {
+		ip = client.String()
+	}
+	return errors.Wrap(ErrUntrustedSource, errors.New(ip))
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package callback_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/stretchr/testify/assert"
+)
+
+const body = `{"input_OriginalConversationID":"conv-1","input_ThirdPartyConversationID":"tp-1","input_TransactionID":"tx-1","input_ResultCode":"INS-0","input_ResultDesc":"Request processed successfully"}`
+
+func request(remote, xff, payload string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(payload))
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	return r
+}
+
+func TestAllowlist(t *testing.T) {
+	allow, err := callback.NewAllowlist([]string{"196.11.240.0/24", "41.223.58.10", "2001:db8::/32"}, []string{"10.0.0.0/8"})
+	assert.Nil(t, err, fmt.Sprintf("new allowlist: expected no error got %v\n", err))
+
+	cases := []struct {
+		desc    string
+		remote  string
+		xff     string
+		allowed bool
+	}{
+		{desc: "allow source in CIDR range", remote: "196.11.240.7:4000", allowed: true},
+		{desc: "allow source address", remote: "41.223.58.10:4000", allowed: true},
+		{desc: "allow IPv6 source", remote: "[2001:db8::1]:4000", allowed: true},
+		{desc: "reject other source", remote: "8.8.8.8:4000", allowed: false},
+		{desc: "ignore forwarded for from untrusted peer", remote: "8.8.8.8:4000", xff: "196.11.240.7", allowed: false},
+		{desc: "allow source behind trusted proxy", remote: "10.1.1.1:4000", xff: "196.11.240.7", allowed: true},
+		{desc: "allow source behind chain of trusted proxies", remote: "10.1.1.1:4000", xff: "196.11.240.7, 10.2.2.2", allowed: true},
+		{desc: "reject source forged before untrusted hop", remote: "10.1.1.1:4000", xff: "196.11.240.7, 8.8.8.8", allowed: false},
+		{desc: "reject malformed forwarded for", remote: "10.1.1.1:4000", xff: "junk", allowed: false},
+		{desc: "reject trusted proxy without forwarded for", remote: "10.1.1.1:4000", allowed: false},
+	}
+
+	for _, tc := range cases {
+		allowed := allow.Allowed(request(tc.remote, tc.xff, body))
+		assert.Equal(t, tc.allowed, allowed, fmt.Sprintf("%s: expected allowed %t got %t\n", tc.desc, tc.allowed, allowed))
+	}
+
+	_, err = callback.NewAllowlist([]string{"196.11.240.0/33"}, nil)
+	assert.NotNil(t, err, "new allowlist: expected error for invalid CIDR range\n")
+}
+
+func TestHandler(t *testing.T) {
+	allow, _ := callback.NewAllowlist([]string{"196.11.240.0/24"}, nil)
+
+	secret := []byte("s3cret")
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	cases := []struct {
+		desc      string
+		method    string
+		remote    string
+		payload   string
+		signature string
+		status    int
+		logged    bool
+	}{
+		{desc: "dispatch trusted callback", method: http.MethodPost, remote: "196.11.240.7:4000", payload: body, signature: signature, status: http.StatusOK},
+		{desc: "reject untrusted source", method: http.MethodPost, remote: "8.8.8.8:4000", payload: body, signature: signature, status: http.StatusForbidden, logged: true},
+		{desc: "reject wrong signature", method: http.MethodPost, remote: "196.11.240.7:4000", payload: body, signature: "00", status: http.StatusForbidden, logged: true},
+		{desc: "reject unsigned malformed callback before decoding", method: http.MethodPost, remote: "196.11.240.7:4000", payload: "{", signature: "", status: http.StatusForbidden, logged: true},
+		{desc: "reject other methods", method: http.MethodGet, remote: "196.11.240.7:4000", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range cases {
+		var logs bytes.Buffer
+		var dispatched *callback.Callback
+
+		h := &callback.Handler{
+			Verifiers: []callback.Verifier{allow, callback.HMAC{Header: "X-Signature", Secret: secret}},
+			Dispatch: func(_ context.Context, cb *callback.Callback) error {
+				dispatched = cb
+				return nil
+			},
+			Logger: log.New(&logs, "", 0),
+		}
+
+		r := request(tc.remote, "", tc.payload)
+		r.Method = tc.method
+		r.Header.Set("X-Signature", "sha256="+tc.signature)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.status, w.Code, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, w.Code))
+		assert.Equal(t, tc.logged, logs.Len() > 0, fmt.Sprintf("%s: expected logged %t got %q\n", tc.desc, tc.logged, logs.String()))
+		assert.Equal(t, tc.status == http.StatusOK, dispatched != nil, fmt.Sprintf("%s: expected dispatched %t\n", tc.desc, tc.status == http.StatusOK))
+
+		if dispatched != nil {
+			assert.Equal(t, "tx-1", dispatched.TransactionID, fmt.Sprintf("%s: expected transaction tx-1 got %s\n", tc.desc, dispatched.TransactionID))
+			assert.Equal(t, "196.11.240.7", dispatched.Source, fmt.Sprintf("%s: expected source 196.11.240.7 got %s\n", tc.desc, dispatched.Source))
+
+			var ack callback.Ack
+			b, _ := ioutil.ReadAll(w.Body)
+			json.Unmarshal(b, &ack)
+			assert.Equal(t, "conv-1", ack.OriginalConversationID, fmt.Sprintf("%s: expected ack of conv-1 got %s\n", tc.desc, ack.OriginalConversationID))
+		}
+	}
+}
+
+func TestHandlerWithoutVerifiers(t *testing.T) {
+	cases := []struct {
+		desc       string
+		insecure   bool
+		status     int
+		dispatched bool
+	}{
+		{desc: "reject without verifiers", status: http.StatusForbidden},
+		{desc: "accept when insecure", insecure: true, status: http.StatusOK, dispatched: true},
+	}
+
+	for _, tc := range cases {
+		dispatched := false
+		h := &callback.Handler{
+			Insecure: tc.insecure,
+			Dispatch: func(context.Context, *callback.Callback) error {
+				dispatched = true
+				return nil
+			},
+			Logger: log.New(ioutil.Discard, "", 0),
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("196.11.240.7:4000", "", body))
+
+		assert.Equal(t, tc.status, w.Code, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, w.Code))
+		assert.Equal(t, tc.dispatched, dispatched, fmt.Sprintf("%s: expected dispatched %v got %v\n", tc.desc, tc.dispatched, dispatched))
+	}
+}
+
+func TestHandlerDispatchError(t *testing.T) {
+	h := &callback.Handler{
+		Dispatch: func(context.Context, *callback.Callback) error { return fmt.Errorf("queue unavailable") },
+		Insecure: true,
+		Logger:   log.New(ioutil.Discard, "", 0),
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request("196.11.240.7:4000", "", body))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code, fmt.Sprintf("dispatch error: expected status %d got %d\n", http.StatusInternalServerError, w.Code))
+}
+
+func TestHandlerDedup(t *testing.T) {
+	var (
+		dispatched int
+		fail       bool
+	)
+
+	h := &callback.Handler{
+		Insecure: true,
+		Dedup:    callback.NewMemoryDedup(),
+		Dispatch: func(context.Context, *callback.Callback) error {
+			if fail {
+				return fmt.Errorf("queue unavailable")
+			}
+			dispatched++
+			return nil
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	cases := []struct {
+		desc       string
+		payload    string
+		fail       bool
+		status     int
+		dispatched int
+		duplicates int64
+	}{
+		{desc: "dispatch first callback", payload: body, status: http.StatusOK, dispatched: 1},
+		{desc: "acknowledge retried callback", payload: body, status: http.StatusOK, dispatched: 1, duplicates: 1},
+		{
+			desc:       "acknowledge callback with known conversation ID",
+			payload:    `{"input_OriginalConversationID":"conv-1","input_TransactionID":"tx-2","input_ResultCode":"INS-0"}`,
+			status:     http.StatusOK,
+			dispatched: 1,
+			duplicates: 2,
+		},
+		{
+			desc:       "fail dispatch of new callback",
+			payload:    `{"input_OriginalConversationID":"conv-3","input_TransactionID":"tx-3","input_ResultCode":"INS-0"}`,
+			fail:       true,
+			status:     http.StatusInternalServerError,
+			dispatched: 1,
+			duplicates: 2,
+		},
+		{
+			desc:       "dispatch retry of failed callback",
+			payload:    `{"input_OriginalConversationID":"conv-3","input_TransactionID":"tx-3","input_ResultCode":"INS-0"}`,
+			status:     http.StatusOK,
+			dispatched: 2,
+			duplicates: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		fail = tc.fail
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("196.11.240.7:4000", "", tc.payload))
+
+		assert.Equal(t, tc.status, w.Code, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, w.Code))
+		assert.Equal(t, tc.dispatched, dispatched, fmt.Sprintf("%s: expected %d dispatched got %d\n", tc.desc, tc.dispatched, dispatched))
+		assert.Equal(t, tc.duplicates, h.Duplicates(), fmt.Sprintf("%s: expected %d duplicates got %d\n", tc.desc, tc.duplicates, h.Duplicates()))
+	}
+}
+
+func TestHandlerDedupInFlight(t *testing.T) {
+	var (
+		started = make(chan struct{}, 1)
+		proceed = make(chan error)
+		calls   int32
+	)
+
+	h := &callback.Handler{
+		Insecure: true,
+		Dedup:    callback.NewMemoryDedup(),
+		Dispatch: func(context.Context, *callback.Callback) error {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				return nil
+			}
+			started <- struct{}{}
+			return <-proceed
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(first, request("196.11.240.7:4000", "", body))
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("expected the first callback to be dispatched")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request("196.11.240.7:4000", "", body))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, fmt.Sprintf("retry in flight: expected status %d got %d\n", http.StatusServiceUnavailable, w.Code))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"), "retry in flight: expected a Retry-After header\n")
+	assert.Equal(t, int64(0), h.Duplicates(), "retry in flight: expected no duplicate\n")
+
+	proceed <- fmt.Errorf("queue unavailable")
+	<-done
+	assert.Equal(t, http.StatusInternalServerError, first.Code, fmt.Sprintf("failed dispatch: expected status %d got %d\n", http.StatusInternalServerError, first.Code))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request("196.11.240.7:4000", "", body))
+	assert.Equal(t, http.StatusOK, w.Code, fmt.Sprintf("retry after failure: expected status %d got %d\n", http.StatusOK, w.Code))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "retry after failure: expected the retry to be dispatched\n")
+}
+
+func TestHandlerEventAfterDispatch(t *testing.T) {
+	var (
+		fail       bool
+		dispatched int
+	)
+
+	h := &callback.Handler{
+		Insecure: true,
+		Dispatch: func(context.Context, *callback.Callback) error {
+			if fail {
+				return fmt.Errorf("queue unavailable")
+			}
+			dispatched++
+			return nil
+		},
+		Events: mpesa.NewEventBus(),
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	var seen []int
+	h.Events.Subscribe(mpesa.EventCallbackReceived, func(context.Context, mpesa.Event) {
+		seen = append(seen, dispatched)
+	})
+
+	cases := []struct {
+		desc   string
+		fail   bool
+		events []int
+	}{
+		{desc: "no event for failed dispatch", fail: true},
+		{desc: "event once dispatched", events: []int{1}},
+	}
+
+	for _, tc := range cases {
+		fail = tc.fail
+		h.ServeHTTP(httptest.NewRecorder(), request("196.11.240.7:4000", "", body))
+		assert.Equal(t, tc.events, seen, fmt.Sprintf("%s: expected events after %v dispatches got %v\n", tc.desc, tc.events, seen))
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package callback
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defDedupTTL = 24 * time.Hour
+
+	// inFlightTTL bounds the time a callback whose dispatch never ended,
+	// e.g. in a crash, holds off its retries.
+	inFlightTTL = 5 * time.Minute
+)
+
+// DedupState is the state of a dedup key.
+type DedupState int
+
+const (
+	// Unseen keys are not recorded, or have expired.
+	Unseen DedupState = iota
+
+	// InFlight keys belong to a callback being dispatched.
+	InFlight
+
+	// Dispatched keys belong to a callback dispatched successfully.
+	Dispatched
+)
+
+// DedupStore remembers the keys of callbacks being dispatched and of those
+// dispatched, for a time to live. Implementations shared between several
+// handlers, e.g. backed by Redis, must make Add atomic.
+type DedupStore interface {
+
+	// Add records key as InFlight for ttl, unless it is recorded and has
+	// not expired, and returns the state it had.
+	Add(ctx context.Context, key string, ttl time.Duration) (DedupState, error)
+
+	// Complete records key as Dispatched for ttl.
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+
+	// Remove forgets key.
+	Remove(ctx context.Context, key string) error
+}
+
+var _ DedupStore = (*MemoryDedup)(nil)
+
+// MemoryDedup is a DedupStore for a single process.
+type MemoryDedup struct {
+	mu   sync.Mutex
+	keys map[string]dedupEntry
+	adds int
+}
+
+type dedupEntry struct {
+	state   DedupState
+	expires time.Time
+}
+
+// NewMemoryDedup returns an empty in-memory dedup store.
+func NewMemoryDedup() *MemoryDedup {
+	return &MemoryDedup{keys: make(map[string]dedupEntry)}
+}
+
+// Add implements DedupStore.
+func (m *MemoryDedup) Add(_ context.Context, key string, ttl time.Duration) (DedupState, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// purge expired keys every so often to bound memory
+	if m.adds++; m.adds%1024 == 0 {
+		for k, e := range m.keys {
+			if !now.Before(e.expires) {
+				delete(m.keys, k)
+			}
+		}
+	}
+
+	if e, ok := m.keys[key]; ok && now.Before(e.expires) {
+		return e.state, nil
+	}
+
+	m.keys[key] = dedupEntry{state: InFlight, expires: now.Add(ttl)}
+	return Unseen, nil
+}
+
+// Complete implements DedupStore.
+func (m *MemoryDedup) Complete(_ context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[key] = dedupEntry{state: Dispatched, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Remove implements DedupStore.
+func (m *MemoryDedup) Remove(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, key)
+	return nil
+}
+
+// dedupKeys returns the keys identifying cb, its transaction ID and its
+// conversation ID.
+func dedupKeys(cb *Callback) []string {
+	var keys []string
+	if cb.TransactionID != "" {
+		keys = append(keys, "transaction:"+cb.TransactionID)
+	}
+	if id := cb.conversationID(); id != "" {
+		keys = append(keys, "conversation:"+id)
+	}
+	return keys
+}
+
+// duplicate records the keys of cb as in flight and returns the state of
+// the callback, Dispatched or InFlight if any of its keys is, along with the
+// keys it newly recorded.
+func (h *Handler) duplicate(ctx context.Context, cb *Callback) (DedupState, []string, error) {
+	var (
+		state DedupState
+		added []string
+	)
+
+	for _, key := range dedupKeys(cb) {
+		seen, err := h.Dedup.Add(ctx, key, inFlightTTL)
+		if err != nil {
+			h.forget(ctx, added)
+			return Unseen, nil, err
+		}
+
+		if seen == Unseen {
+			added = append(added, key)
+		} else if seen > state {
+			state = seen
+		}
+	}
+
+	return state, added, nil
+}
+
+// complete records keys as dispatched.
+func (h *Handler) complete(ctx context.Context, keys []string) {
+	ttl := h.DedupTTL
+	if ttl <= 0 {
+		ttl = defDedupTTL
+	}
+
+	for _, key := range keys {
+		if err := h.Dedup.Complete(ctx, key, ttl); err != nil {
+			h.logger().Printf("callback: failed to record %s: %v", key, err)
+		}
+	}
+}
+
+// forget removes keys so that a callback whose dispatch failed is dispatched
+// again when it is retried.
+func (h *Handler) forget(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.Dedup.Remove(ctx, key); err != nil {
+			h.logger().Printf("callback: failed to forget %s: %v", key, err)
+		}
+	}
+}
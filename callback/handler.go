@@ -0,0 +1,277 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package callback receives the results M-Pesa posts to the callback URL of
+// asynchronous transactions. Handler verifies that a callback comes from a
+// trusted sender before it dispatches anything:
+//
+//	allow, err := callback.NewAllowlist(app.TrustedSources, []string{"10.0.0.0/8"})
+//	http.Handle("/mpesa/callback", &callback.Handler{
+//		Verifiers: []callback.Verifier{allow},
+//		Dispatch:  func(ctx context.Context, cb *callback.Callback) error { ... },
+//	})
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+const (
+	maxBodySize = 1 << 20
+
+	codeSuccess = "INS-0"
+)
+
+var (
+	// ErrInvalidSource is returned for a trusted source that is neither an
+	// IP address nor a CIDR range.
+	ErrInvalidSource = errors.New("invalid source address")
+
+	// ErrUntrustedSource rejects callbacks from addresses not in the allowlist.
+	ErrUntrustedSource = errors.New("untrusted callback source")
+
+	// ErrInvalidToken rejects callbacks without the shared secret.
+	ErrInvalidToken = errors.New("invalid callback token")
+
+	// ErrInvalidSignature rejects callbacks with a missing or wrong signature.
+	ErrInvalidSignature = errors.New("invalid callback signature")
+
+	// ErrNoVerifier rejects every callback of a handler without verifiers.
+	ErrNoVerifier = errors.New("no callback verifier configured")
+
+	// ErrInvalidCallback rejects callbacks that cannot be decoded.
+	ErrInvalidCallback = errors.New("invalid callback")
+)
+
+// Callback is the result of an asynchronous transaction.
+type Callback struct {
+	OriginalConversationID   string `json:"input_OriginalConversationID"`
+	ConversationID           string `json:"input_ConversationID,omitempty"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+	TransactionID            string `json:"input_TransactionID"`
+	ResultCode               string `json:"input_ResultCode"`
+	ResultDesc               string `json:"input_ResultDesc"`
+
+	// Body is the callback as received.
+	Body json.RawMessage `json:"-"`
+
+	// Source is the address of the client that sent the callback.
+	Source string `json:"-"`
+
+	ReceivedAt time.Time `json:"-"`
+}
+
+// Succeeded reports whether the transaction succeeded.
+func (cb *Callback) Succeeded() bool {
+	return cb.ResultCode == codeSuccess
+}
+
+// conversationID returns the conversation ID of the transaction, which
+// callbacks carry as the original conversation ID.
+func (cb *Callback) conversationID() string {
+	if cb.OriginalConversationID != "" {
+		return cb.OriginalConversationID
+	}
+	return cb.ConversationID
+}
+
+// Ack is the response M-Pesa expects for a callback.
+type Ack struct {
+	OriginalConversationID   string `json:"output_OriginalConversationID"`
+	ResponseCode             string `json:"output_ResponseCode"`
+	ResponseDesc             string `json:"output_ResponseDesc"`
+	ThirdPartyConversationID string `json:"output_ThirdPartyConversationID"`
+}
+
+// DispatchFunc processes a verified callback. Returning an error fails the
+// request, so that M-Pesa retries the callback.
+type DispatchFunc func(ctx context.Context, cb *Callback) error
+
+// Handler is an http.Handler for callbacks.
+type Handler struct {
+
+	// Verifiers must all accept a callback before it is dispatched, e.g. an
+	// *Allowlist. A handler without verifiers rejects every callback, unless
+	// Insecure is set.
+	Verifiers []Verifier
+
+	// Insecure accepts callbacks from anyone when there are no Verifiers,
+	// e.g. behind a relay that verifies them.
+	Insecure bool
+
+	Dispatch DispatchFunc
+
+	// Dedup, if set, remembers the transaction ID and conversation ID of
+	// every dispatched callback for DedupTTL, 24 hours by default. Retried
+	// callbacks matching either are acknowledged without being dispatched
+	// again. Retries arriving while the first delivery is being dispatched
+	// are answered 503 Service Unavailable, to be retried later. Callbacks
+	// whose dispatch fails are forgotten, so that their retries are
+	// dispatched.
+	Dedup    DedupStore
+	DedupTTL time.Duration
+
+	// Logger logs every rejected callback, the log package's standard
+	// logger when nil.
+	Logger *log.Logger
+
+	// Events, if set, receives an mpesa.EventCallbackReceived event for
+	// every dispatched callback, once Dispatch has returned.
+	Events *mpesa.EventBus
+
+	duplicates int64
+}
+
+// Duplicates returns the number of duplicate callbacks acknowledged without
+// being dispatched.
+func (h *Handler) Duplicates() int64 {
+	return atomic.LoadInt64(&h.duplicates)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		h.reject(w, r, http.StatusBadRequest, errors.Wrap(ErrInvalidCallback, err))
+		return
+	}
+
+	if len(body) > maxBodySize {
+		h.reject(w, r, http.StatusRequestEntityTooLarge, errors.Wrap(ErrInvalidCallback, errors.New("body too large")))
+		return
+	}
+
+	if len(h.Verifiers) == 0 && !h.Insecure {
+		h.reject(w, r, http.StatusForbidden, ErrNoVerifier)
+		return
+	}
+
+	for _, v := range h.Verifiers {
+		if err := v.Verify(r, body); err != nil {
+			h.reject(w, r, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	cb := &Callback{Body: body, Source: r.RemoteAddr, ReceivedAt: time.Now().UTC()}
+	if err := json.Unmarshal(body, cb); err != nil {
+		h.reject(w, r, http.StatusBadRequest, errors.Wrap(ErrInvalidCallback, err))
+		return
+	}
+
+	for _, v := range h.Verifiers {
+		if a, ok := v.(*Allowlist); ok {
+			if ip := a.ClientIP(r); ip != nil {
+				cb.Source = ip.String()
+			}
+			break
+		}
+	}
+
+	var added []string
+	if h.Dedup != nil {
+		var state DedupState
+		if state, added, err = h.duplicate(r.Context(), cb); err != nil {
+			h.logger().Printf("callback: dedup %s from %s failed: %v", cb.conversationID(), cb.Source, err)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		switch state {
+		case Dispatched:
+			h.complete(r.Context(), added)
+			atomic.AddInt64(&h.duplicates, 1)
+			ack(w, cb)
+			return
+
+		case InFlight:
+			// the first delivery may still fail, the sender must retry
+			h.forget(r.Context(), added)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if h.Dispatch != nil {
+		if err := h.Dispatch(r.Context(), cb); err != nil {
+			h.logger().Printf("callback: dispatch %s from %s failed: %v", cb.conversationID(), cb.Source, err)
+			h.forget(r.Context(), added)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.Dedup != nil {
+		h.complete(r.Context(), added)
+	}
+
+	h.Events.Emit(r.Context(), mpesa.Event{
+		Source:  "/mpesa/callback",
+		Type:    mpesa.EventCallbackReceived,
+		Subject: cb.conversationID(),
+		Data: mpesa.CallbackReceived{
+			OriginalConversationID:   cb.OriginalConversationID,
+			ThirdPartyConversationID: cb.ThirdPartyConversationID,
+			TransactionID:            cb.TransactionID,
+			ResultCode:               cb.ResultCode,
+			Source:                   cb.Source,
+		},
+	})
+
+	ack(w, cb)
+}
+
+// ack acknowledges cb.
+func ack(w http.ResponseWriter, cb *Callback) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Ack{
+		OriginalConversationID:   cb.conversationID(),
+		ResponseCode:             "0",
+		ResponseDesc:             "Successfully Accepted Result",
+		ThirdPartyConversationID: cb.ThirdPartyConversationID,
+	})
+}
+
+// reject logs and fails a callback.
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request, status int, err error) {
+	h.logger().Printf("callback: rejected %s %s from %s (X-Forwarded-For %q): %v",
+		r.Method, r.URL.Path, r.RemoteAddr, r.Header.Get("X-Forwarded-For"), err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (h *Handler) logger() *log.Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+	return log.New(log.Writer(), log.Prefix(), log.Flags())
+}
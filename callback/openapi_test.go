@@ -0,0 +1,50 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package callback_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec, err := openapi.Load("../openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc   string
+		v      interface{}
+		schema string
+	}{
+		{desc: "callback", v: callback.Callback{}, schema: "TransactionCallback"},
+		{desc: "ack", v: callback.Ack{}, schema: "CallbackAck"},
+	}
+
+	for _, tc := range cases {
+		errs := spec.Check(tc.schema, tc.v)
+		assert.Empty(t, errs, fmt.Sprintf("%s: expected %T to match %s got %v\n", tc.desc, tc.v, tc.schema, errs))
+	}
+
+	_, _, op := spec.Operation("transactionCallback")
+	assert.NotNil(t, op, "expected the transactionCallback webhook")
+}
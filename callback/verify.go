@@ -0,0 +1,84 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Verifier decides whether a callback comes from a trusted sender. It is
+// given the request and its body, which has already been read.
+type Verifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// VerifierFunc is a Verifier function.
+type VerifierFunc func(r *http.Request, body []byte) error
+
+// Verify implements Verifier.
+func (fn VerifierFunc) Verify(r *http.Request, body []byte) error {
+	return fn(r, body)
+}
+
+// Token verifies a shared secret sent in a header, or in a query parameter
+// of the callback URL when Header is empty.
+type Token struct {
+	Header string
+	Param  string
+	Secret string
+}
+
+// Verify implements Verifier.
+func (t Token) Verify(r *http.Request, _ []byte) error {
+	got := r.Header.Get(t.Header)
+	if t.Header == "" {
+		got = r.URL.Query().Get(t.Param)
+	}
+
+	if t.Secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(t.Secret)) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// HMAC verifies a hex encoded HMAC-SHA256 of the body sent in Header, as
+// signed by a relay in front of the handler. A "sha256=" prefix is accepted.
+type HMAC struct {
+	Header string
+	Secret []byte
+}
+
+// Verify implements Verifier.
+func (h HMAC) Verify(r *http.Request, body []byte) error {
+	got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(h.Header), "sha256="))
+	if err != nil || len(h.Secret) == 0 {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write(body)
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
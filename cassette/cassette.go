@@ -0,0 +1,116 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package cassette records the HTTP interactions of an application with the
+// M-Pesa API to cassette files and replays them, so that tests run once
+// against the sandbox can run offline afterwards.
+//
+//	rec, err := cassette.New("testdata/c2b.json", cassette.ModeAuto)
+//	app, err := mpesa.NewApplication(key, market, mpesa.Sandbox,
+//		mpesa.WithHTTPClient(&http.Client{Transport: rec}))
+//
+// Bearer tokens, session keys and configured secrets are scrubbed from
+// cassettes. Requests are matched on their method, path, query and JSON body,
+// ignoring volatile fields such as third party conversation IDs.
+package cassette
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	// ErrNoCassette is returned when replaying a cassette that does not exist.
+	ErrNoCassette = errors.New("cassette not found")
+
+	// ErrNoInteraction is returned when replaying a request that matches no
+	// recorded interaction.
+	ErrNoInteraction = errors.New("no recorded interaction matches request")
+)
+
+const version = 1
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request    Request   `json:"request"`
+	Response   Response  `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Load reads the cassette at path.
+func Load(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNoCassette, errors.New(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Save writes c to path, creating its directory when needed. The file is
+// replaced atomically.
+func (c *Cassette) Save(path string) error {
+	c.Version = version
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
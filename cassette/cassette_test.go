@@ -0,0 +1,221 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cassette_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/cassette"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// secret is a credential the fake gateway echoes in a response header.
+const secret = "secret-merchant-key"
+
+// newAPI returns a gateway answering C2B payments, echoing the third party
+// conversation ID like M-Pesa does.
+func newAPI(t *testing.T) *mpesatest.API {
+	return mpesatest.NewAPI().
+		On(mpesa.OpGetSession, mpesatest.Reply{Status: http.StatusOK, Body: `{"output_ResponseCode":"INS-0","output_SessionID":"live-session-key"}`}).
+		Handle(mpesa.OpC2B, func(req *http.Request) mpesatest.Reply {
+			var p mpesa.C2BPayment
+			if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+				t.Error(err)
+			}
+
+			body, _ := json.Marshal(mpesa.TransactionResp{
+				Code:                     mpesa.CodeSuccess,
+				TransactionID:            "tx-" + p.TransactionReference,
+				ThirdPartyConversationID: p.ThirdPartyConversationID,
+			})
+			return mpesatest.Reply{Status: http.StatusCreated, Body: string(body), Header: http.Header{"X-Merchant-Key": {secret}}}
+		})
+}
+
+func payment(ref, conversationID string) mpesa.C2BPayment {
+	return mpesa.C2BPayment{
+		Amount:                   "100",
+		Country:                  "TZN",
+		Currency:                 "TZS",
+		CustomerMSISDN:           "255744553111",
+		ServiceProviderCode:      "000000",
+		ThirdPartyConversationID: conversationID,
+		TransactionReference:     ref,
+		PurchasedItemsDesc:       "Test",
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "c2b.json")
+
+	rec, err := cassette.New(path, cassette.ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, rec.Recording(), "expected a missing cassette to be recorded\n")
+	rec.Transport = newAPI(t)
+	rec.Secrets = []string{secret}
+
+	resp, err := mpesatest.NewApplication(t, rec).C2B(context.Background(), payment("ref1", "recorded1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "recorded1", resp.ThirdPartyConversationID, "expected the recorded response to be returned as is\n")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scrubbed := range []string{"live-session-key", "Bearer", secret} {
+		assert.NotContains(t, string(data), scrubbed, "expected the cassette to be scrubbed\n")
+	}
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, c.Interactions, 2, "expected the session and payment to be recorded\n")
+
+	cases := []struct {
+		desc           string
+		payment        mpesa.C2BPayment
+		transactionID  string
+		conversationID string
+		err            error
+	}{
+		{
+			desc:           "same request",
+			payment:        payment("ref1", "recorded1"),
+			transactionID:  "tx-ref1",
+			conversationID: "recorded1",
+		},
+		{
+			desc:           "new conversation id",
+			payment:        payment("ref1", "replayed2"),
+			transactionID:  "tx-ref1",
+			conversationID: "replayed2",
+		},
+		{
+			desc:    "other reference",
+			payment: payment("ref2", "replayed3"),
+			err:     cassette.ErrNoInteraction,
+		},
+	}
+
+	for _, tc := range cases {
+		rec, err := cassette.New(path, cassette.ModeAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.False(t, rec.Recording(), fmt.Sprintf("%s: expected an existing cassette to be replayed\n", tc.desc))
+
+		resp, err := mpesatest.NewApplication(t, rec).C2B(context.Background(), tc.payment)
+		if tc.err != nil {
+			assert.Error(t, err, fmt.Sprintf("%s: expected error %v\n", tc.desc, tc.err))
+			assert.Contains(t, fmt.Sprint(err), tc.err.Error(), fmt.Sprintf("%s: expected error %v\n", tc.desc, tc.err))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, tc.transactionID, resp.TransactionID, fmt.Sprintf("%s: expected transaction id %s got %s\n", tc.desc, tc.transactionID, resp.TransactionID))
+		assert.Equal(t, tc.conversationID, resp.ThirdPartyConversationID, fmt.Sprintf("%s: expected conversation id %s got %s\n", tc.desc, tc.conversationID, resp.ThirdPartyConversationID))
+	}
+}
+
+func TestReplayMatching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+
+	c := &cassette.Cassette{Interactions: []cassette.Interaction{
+		{
+			Request:  cassette.Request{Method: http.MethodGet, URL: "https://api/queryTransactionStatus/?input_QueryReference=tx1&input_ThirdPartyConversationID=a"},
+			Response: cassette.Response{StatusCode: http.StatusOK, Body: `{"output_ResponseTransactionStatus":"Pending"}`},
+		},
+		{
+			Request:  cassette.Request{Method: http.MethodGet, URL: "https://api/queryTransactionStatus/?input_QueryReference=tx1&input_ThirdPartyConversationID=b"},
+			Response: cassette.Response{StatusCode: http.StatusOK, Body: `{"output_ResponseTransactionStatus":"Completed"}`},
+		},
+	}}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := cassette.New(path, cassette.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc string
+		url  string
+		body string
+		err  error
+	}{
+		{
+			desc: "first poll",
+			url:  "https://api/queryTransactionStatus/?input_QueryReference=tx1&input_ThirdPartyConversationID=c",
+			body: `{"output_ResponseTransactionStatus":"Pending"}`,
+		},
+		{
+			desc: "second poll",
+			url:  "https://api/queryTransactionStatus/?input_ThirdPartyConversationID=d&input_QueryReference=tx1",
+			body: `{"output_ResponseTransactionStatus":"Completed"}`,
+		},
+		{
+			desc: "poll after the last interaction",
+			url:  "https://api/queryTransactionStatus/?input_QueryReference=tx1&input_ThirdPartyConversationID=e",
+			body: `{"output_ResponseTransactionStatus":"Completed"}`,
+		},
+		{
+			desc: "other reference",
+			url:  "https://api/queryTransactionStatus/?input_QueryReference=tx2&input_ThirdPartyConversationID=f",
+			err:  cassette.ErrNoInteraction,
+		},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+
+		resp, err := rec.RoundTrip(req)
+		if tc.err != nil {
+			assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, tc.body, string(body), fmt.Sprintf("%s: expected body %s got %s\n", tc.desc, tc.body, body))
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	_, err := cassette.New(filepath.Join(t.TempDir(), "missing.json"), cassette.ModeReplay)
+	assert.True(t, errors.Contains(err, cassette.ErrNoCassette), fmt.Sprintf("expected error %v got %v\n", cassette.ErrNoCassette, err))
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// Mode of a Recorder.
+type Mode int
+
+const (
+	// ModeReplay replays a cassette and fails requests it did not record.
+	ModeReplay Mode = iota
+
+	// ModeRecord sends requests and records them, replacing the cassette.
+	ModeRecord
+
+	// ModeAuto replays the cassette when it exists and records it otherwise.
+	ModeAuto
+)
+
+// Scrubbed replaces scrubbed values in cassettes.
+const Scrubbed = "[scrubbed]"
+
+var (
+	// DefaultIgnore are the fields left out when matching requests.
+	DefaultIgnore = []string{"input_ThirdPartyConversationID"}
+
+	// DefaultScrub are the fields scrubbed from cassettes, session keys
+	// authorise API calls.
+	DefaultScrub = []string{"output_SessionID"}
+
+	// DefaultScrubHeaders are the headers scrubbed from cassettes; the
+	// Authorization header carries the encrypted API key or session key.
+	DefaultScrubHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+)
+
+var _ http.RoundTripper = (*Recorder)(nil)
+
+// Recorder is an http.RoundTripper recording interactions to a cassette file
+// or replaying them from it. Recorded interactions are saved as they happen.
+//
+// Replayed requests are matched to the first unused interaction with the same
+// method, path, query and JSON body, leaving out the Ignore fields. Once every
+// matching interaction has been used the last one is replayed again, e.g. for
+// status polls. The values of the Ignore fields in replayed responses are
+// replaced by those of the live request, so that responses echoing a third
+// party conversation ID echo the live one.
+type Recorder struct {
+	// Transport sends requests when recording, http.DefaultTransport when nil.
+	Transport http.RoundTripper
+
+	// Ignore are the JSON body fields and query parameters left out when
+	// matching requests, DefaultIgnore when nil.
+	Ignore []string
+
+	// Scrub are the JSON body fields and query parameters whose values are
+	// replaced by Scrubbed in the cassette, DefaultScrub when nil.
+	Scrub []string
+
+	// ScrubHeaders are the request and response headers whose values are
+	// replaced by Scrubbed in the cassette, DefaultScrubHeaders when nil.
+	ScrubHeaders []string
+
+	// Secrets, e.g. the API key, are replaced by Scrubbed wherever they
+	// appear in the cassette.
+	Secrets []string
+
+	path      string
+	recording bool
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// New returns a recorder for the cassette at path, which is loaded unless
+// mode records it.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, cassette: &Cassette{}}
+
+	if mode == ModeAuto {
+		mode = ModeReplay
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			mode = ModeRecord
+		}
+	}
+
+	if mode == ModeRecord {
+		r.recording = true
+		return r, nil
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	r.cassette = c
+	r.used = make([]bool, len(c.Interactions))
+	return r, nil
+}
+
+// Recording reports whether the recorder records rather than replays.
+func (r *Recorder) Recording() bool {
+	return r.recording
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := r.scrubRequest(req, body)
+
+	if !r.recording {
+		return r.replay(req, body, recorded)
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     r.scrubHeader(resp.Header),
+			Body:       r.scrubBody(string(respBody)),
+		},
+		RecordedAt: time.Now().UTC(),
+	})
+
+	if err := r.cassette.Save(r.path); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// replay returns the response recorded for req.
+func (r *Recorder) replay(req *http.Request, body []byte, recorded Request) (*http.Response, error) {
+	key := r.key(recorded)
+
+	r.mu.Lock()
+	found := -1
+	for i, in := range r.cassette.Interactions {
+		if r.key(in.Request) != key {
+			continue
+		}
+		found = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if found >= 0 {
+		r.used[found] = true
+	}
+	r.mu.Unlock()
+
+	if found < 0 {
+		return nil, errors.Wrap(ErrNoInteraction, errors.New(req.Method+" "+req.URL.Path))
+	}
+
+	in := r.cassette.Interactions[found]
+	respBody := r.echo(in.Request, req.URL.Query(), body, in.Response.Body)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+		StatusCode:    in.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        in.Response.Header.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// echo replaces the JSON string values of respBody equal to the Ignore
+// fields of the recorded request by those of the live request.
+func (r *Recorder) echo(recorded Request, query url.Values, body []byte, respBody string) string {
+	old := fields(recorded.URL, []byte(recorded.Body))
+	live := fields("?"+query.Encode(), body)
+
+	for _, f := range r.ignore() {
+		if o, l := old[f], live[f]; o != "" && l != "" && o != l {
+			oq, _ := json.Marshal(o)
+			lq, _ := json.Marshal(l)
+			respBody = strings.ReplaceAll(respBody, string(oq), string(lq))
+		}
+	}
+	return respBody
+}
+
+// key returns the matching key of a recorded request.
+func (r *Recorder) key(req Request) string {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return req.Method + " " + req.URL
+	}
+
+	q := u.Query()
+	for _, f := range r.ignore() {
+		q.Del(f)
+	}
+
+	return req.Method + " " + u.Path + "?" + q.Encode() + " " + normalize(req.Body, r.ignore())
+}
+
+// scrubRequest returns the cassette copy of req.
+func (r *Recorder) scrubRequest(req *http.Request, body []byte) Request {
+	u := *req.URL
+	q := u.Query()
+	for _, f := range r.scrub() {
+		if q.Get(f) != "" {
+			q.Set(f, Scrubbed)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return Request{
+		Method: req.Method,
+		URL:    r.scrubSecrets(u.String()),
+		Header: r.scrubHeader(req.Header),
+		Body:   r.scrubBody(string(body)),
+	}
+}
+
+func (r *Recorder) scrubHeader(h http.Header) http.Header {
+	h = h.Clone()
+
+	names := r.ScrubHeaders
+	if names == nil {
+		names = DefaultScrubHeaders
+	}
+	for _, name := range names {
+		if h.Get(name) != "" {
+			h.Set(name, Scrubbed)
+		}
+	}
+
+	for name, values := range h {
+		for i, v := range values {
+			values[i] = r.scrubSecrets(v)
+		}
+		h[name] = values
+	}
+	return h
+}
+
+// scrubBody scrubs the Scrub fields of a JSON object body and the secrets of
+// any body.
+func (r *Recorder) scrubBody(body string) string {
+	var obj map[string]interface{}
+	if json.Unmarshal([]byte(body), &obj) == nil {
+		scrubbed := false
+		for _, f := range r.scrub() {
+			if _, ok := obj[f]; ok {
+				obj[f] = Scrubbed
+				scrubbed = true
+			}
+		}
+		if scrubbed {
+			b, _ := json.Marshal(obj)
+			body = string(b)
+		}
+	}
+	return r.scrubSecrets(body)
+}
+
+func (r *Recorder) scrubSecrets(s string) string {
+	for _, secret := range r.Secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Scrubbed)
+		}
+	}
+	return s
+}
+
+func (r *Recorder) ignore() []string {
+	if r.Ignore == nil {
+		return DefaultIgnore
+	}
+	return r.Ignore
+}
+
+func (r *Recorder) scrub() []string {
+	if r.Scrub == nil {
+		return DefaultScrub
+	}
+	return r.Scrub
+}
+
+// readBody reads the body of req, leaving it readable.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// normalize returns a JSON object body without the ignored fields and with
+// sorted keys, other bodies are returned as is.
+func normalize(body string, ignore []string) string {
+	var obj map[string]interface{}
+	if json.Unmarshal([]byte(body), &obj) != nil {
+		return body
+	}
+
+	for _, f := range ignore {
+		delete(obj, f)
+	}
+
+	b, _ := json.Marshal(obj)
+	return string(b)
+}
+
+// fields returns the string fields of a JSON object body and the query
+// parameters of rawURL.
+func fields(rawURL string, body []byte) map[string]string {
+	f := make(map[string]string)
+
+	if u, err := url.Parse(rawURL); err == nil {
+		for k := range u.Query() {
+			f[k] = u.Query().Get(k)
+		}
+	}
+
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) == nil {
+		for k, v := range obj {
+			if s, ok := v.(string); ok {
+				f[k] = s
+			}
+		}
+	}
+	return f
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Command mpesa-gateway serves the M-Pesa OpenAPI behind a simple internal
+// REST API, for services that cannot use the Go SDK.
+//
+//	mpesa-gateway -config mpesa.yaml -tokens tokens.txt [-listen :8080] [-ledger-dir dir] [-callback-token secret]
+//
+// Every configured application is a tenant. Callers authenticate with an
+// API token sent as "Authorization: Bearer <token>"; the tokens file lists
+// one token per line followed by the tenants it may use, comma separated,
+// or * for every tenant:
+//
+//	# token                       tenants
+//	3f9a0c...                     shop-tz,shop-gh
+//	7be1d2...                     *
+//
+// Routes, where {tenant} names a configured application:
+//
+//	POST /v1/{tenant}/c2b                           collect a customer payment
+//	POST /v1/{tenant}/b2c                           pay a customer
+//	POST /v1/{tenant}/b2b                           pay another business
+//	POST /v1/{tenant}/reversals                     reverse a transaction
+//	GET  /v1/{tenant}/transactions/{ref}            fetch a recorded transaction
+//	GET  /v1/{tenant}/transactions/{ref}/status     query the status of a transaction
+//	POST /callbacks/{tenant}                        M-Pesa callback URL, not authenticated
+//	                                                by API token but by trusted_sources
+//	                                                and -callback-token
+//	GET  /healthz                                   liveness
+//	GET  /readyz                                    readiness
+//
+// Callbacks must carry the -callback-token secret in a token query parameter
+// of the callback URL when it is set, and come from the trusted_sources of
+// their tenant when those are configured. The gateway refuses to start with
+// a tenant whose callbacks would be accepted from anyone, unless run with
+// -insecure-callbacks.
+//
+// Transactions are recorded to a ledger per tenant, kept in memory or in
+// {ledger-dir}/{tenant}.jsonl.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/config"
+	"github.com/mobilemoney/mpesa/ledger"
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", "", "YAML or JSON configuration file, MPESA_* environment variables when empty")
+		envFile    = flag.String("env-file", ".env", "dotenv file loaded when --config is not set")
+		listen     = flag.String("listen", ":8080", "address to listen on")
+		tokensPath = flag.String("tokens", "", "API tokens file")
+		ledgerDir  = flag.String("ledger-dir", "", "directory of the ledger files, in memory when empty")
+		proxies    = flag.String("trusted-proxies", "", "comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For is trusted")
+		dedupTTL   = flag.Duration("callback-dedup-ttl", 24*time.Hour, "how long callbacks are remembered to drop retries")
+		cbToken    = flag.String("callback-token", "", "secret callbacks must carry in the token query parameter of the callback URL")
+		insecure   = flag.Bool("insecure-callbacks", false, "accept callbacks of tenants without trusted_sources when -callback-token is not set")
+	)
+	flag.Parse()
+
+	if *tokensPath == "" {
+		log.Fatal("mpesa-gateway: -tokens is required")
+	}
+
+	cfg, err := loadConfig(*configPath, *envFile)
+	if err != nil {
+		log.Fatalf("mpesa-gateway: %v", err)
+	}
+
+	tokens, err := loadTokens(*tokensPath)
+	if err != nil {
+		log.Fatalf("mpesa-gateway: %v", err)
+	}
+
+	opts := options{
+		ledgerDir:         *ledgerDir,
+		dedupTTL:          *dedupTTL,
+		callbackToken:     *cbToken,
+		insecureCallbacks: *insecure,
+	}
+	if *proxies != "" {
+		opts.proxies = strings.Split(*proxies, ",")
+	}
+
+	gw, err := newGateway(cfg, tokens, opts)
+	if err != nil {
+		log.Fatalf("mpesa-gateway: %v", err)
+	}
+	defer gw.close()
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           gw,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	idle := make(chan struct{})
+	go func() {
+		defer close(idle)
+		<-sig
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdown); err != nil {
+			log.Printf("mpesa-gateway: shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("mpesa-gateway: serving %d tenants on %s", len(cfg.Applications), *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("mpesa-gateway: %v", err)
+	}
+
+	// wait for in-flight requests before closing the ledgers
+	<-idle
+}
+
+func loadConfig(path, envFile string) (*config.Config, error) {
+	if path != "" {
+		return config.Load(path)
+	}
+
+	if _, err := os.Stat(envFile); err == nil {
+		return config.Load(envFile)
+	}
+
+	return config.FromEnv()
+}
+
+// tenant is a configured application with its ledger and callback handler.
+type tenant struct {
+	ledger   *ledger.Ledger
+	file     *ledger.File
+	callback *callback.Handler
+}
+
+// options configure the gateway.
+type options struct {
+	// client calls M-Pesa, one with the configured default timeout when nil
+	client *http.Client
+
+	ledgerDir string
+
+	// proxies whose X-Forwarded-For is trusted
+	proxies []string
+
+	dedupTTL time.Duration
+
+	// callbackToken, when set, is required of every callback
+	callbackToken string
+
+	// insecureCallbacks allows tenants to accept callbacks from anyone
+	insecureCallbacks bool
+}
+
+// newGateway sets up a tenant per configured application. Applications are
+// created, with their session, on first use.
+func newGateway(cfg *config.Config, tokens tokens, opts options) (*gateway, error) {
+	client := opts.client
+	if client == nil {
+		client = &http.Client{Timeout: time.Duration(cfg.Defaults.Timeout)}
+	}
+
+	gw := &gateway{
+		registry: mpesa.NewRegistry(client),
+		tenants:  make(map[string]*tenant),
+		tokens:   tokens,
+	}
+
+	dedup := callback.NewMemoryDedup()
+
+	for _, name := range cfg.Names() {
+		name := name
+		a := cfg.Applications[name]
+		t := &tenant{}
+
+		store := ledger.Store(ledger.NewMemory())
+		if opts.ledgerDir != "" {
+			f, err := ledger.OpenFile(filepath.Join(opts.ledgerDir, name+".jsonl"))
+			if err != nil {
+				gw.close()
+				return nil, err
+			}
+			t.file, store = f, f
+		}
+
+		// registered before the steps below, so that gw.close closes its
+		// ledger file when they fail
+		gw.tenants[name] = t
+
+		t.ledger = ledger.New(store)
+		t.ledger.OnError = func(err error) { log.Printf("mpesa-gateway: %s: ledger: %v", name, err) }
+
+		tc, err := cfg.TenantConfig(name)
+		if err != nil {
+			gw.close()
+			return nil, err
+		}
+		tc.Options = append(tc.Options, mpesa.WithJournal(t.ledger))
+
+		if err := gw.registry.Register(name, tc); err != nil {
+			gw.close()
+			return nil, err
+		}
+
+		var verifiers []callback.Verifier
+		if len(a.TrustedSources) > 0 {
+			allow, err := cfg.Allowlist(name, opts.proxies...)
+			if err != nil {
+				gw.close()
+				return nil, err
+			}
+			verifiers = append(verifiers, allow)
+		}
+		if opts.callbackToken != "" {
+			verifiers = append(verifiers, callback.Token{Param: "token", Secret: opts.callbackToken})
+		}
+
+		if len(verifiers) == 0 {
+			if !opts.insecureCallbacks {
+				gw.close()
+				return nil, fmt.Errorf("%s: callbacks would be accepted from any address, set trusted_sources or -callback-token, or run with -insecure-callbacks", name)
+			}
+			log.Printf("mpesa-gateway: %s: no trusted_sources, callbacks are accepted from any address", name)
+		}
+
+		t.callback = &callback.Handler{
+			Verifiers: verifiers,
+			Insecure:  opts.insecureCallbacks,
+			Dispatch:  t.ledger.Callback,
+			Dedup:     dedup,
+			DedupTTL:  opts.dedupTTL,
+		}
+	}
+
+	return gw, nil
+}
+
+func (gw *gateway) close() {
+	for name, t := range gw.tenants {
+		if t.file != nil {
+			if err := t.file.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "mpesa-gateway: %s: %v\n", name, err)
+			}
+		}
+	}
+}
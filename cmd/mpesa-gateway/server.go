@@ -0,0 +1,357 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/ledger"
+	pkgerrors "github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+const maxBodySize = 1 << 16
+
+type gateway struct {
+	registry *mpesa.Registry
+	tenants  map[string]*tenant
+	tokens   tokens
+}
+
+// paymentRequest is the body of the c2b, b2c and b2b routes. Party is the
+// customer MSISDN, or the receiving shortcode for b2b.
+type paymentRequest struct {
+	Party          string `json:"party"`
+	Amount         string `json:"amount"`
+	Reference      string `json:"reference"`
+	Description    string `json:"description"`
+	ConversationID string `json:"conversation_id"`
+}
+
+type reversalRequest struct {
+	TransactionID  string `json:"transaction_id"`
+	Amount         string `json:"amount"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// result is the response of every M-Pesa operation.
+type result struct {
+	Code                     string `json:"code"`
+	Description              string `json:"description"`
+	TransactionID            string `json:"transaction_id,omitempty"`
+	ConversationID           string `json:"conversation_id,omitempty"`
+	ThirdPartyConversationID string `json:"third_party_conversation_id,omitempty"`
+	TransactionStatus        string `json:"transaction_status,omitempty"`
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ServeHTTP routes requests.
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+	case r.URL.Path == "/readyz":
+		gw.ready(w, r)
+
+	case len(parts) == 2 && parts[0] == "callbacks":
+		t, ok := gw.tenants[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		t.callback.ServeHTTP(w, r)
+
+	case len(parts) >= 3 && parts[0] == "v1":
+		gw.api(w, r, parts[1], parts[2:])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ready reports whether every tenant's ledger can be read.
+func (gw *gateway) ready(w http.ResponseWriter, r *http.Request) {
+	for name, t := range gw.tenants {
+		if _, err := t.ledger.ByStatus(r.Context(), ledger.Initiated); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, errorBody{Error: name + ": " + err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// api authenticates the caller for tenant then serves route.
+func (gw *gateway) api(w http.ResponseWriter, r *http.Request, name string, route []string) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !gw.tokens.known(token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, errorBody{Error: "invalid API token"})
+		return
+	}
+
+	t, ok := gw.tenants[name]
+	if !ok || !gw.tokens.allows(token, name) {
+		// unknown tenants look forbidden, not missing, to callers
+		writeJSON(w, http.StatusForbidden, errorBody{Error: "tenant not allowed for API token"})
+		return
+	}
+
+	switch {
+	case len(route) == 1 && (route[0] == "c2b" || route[0] == "b2c" || route[0] == "b2b"):
+		if !method(w, r, http.MethodPost) {
+			return
+		}
+		gw.payment(w, r, name, route[0])
+
+	case len(route) == 1 && route[0] == "reversals":
+		if !method(w, r, http.MethodPost) {
+			return
+		}
+		gw.reverse(w, r, name)
+
+	case len(route) == 2 && route[0] == "transactions":
+		if !method(w, r, http.MethodGet) {
+			return
+		}
+		tx, err := t.ledger.Lookup(r.Context(), route[1])
+		if pkgerrors.Contains(err, ledger.ErrNotFound) {
+			writeJSON(w, http.StatusNotFound, errorBody{Error: "transaction not found"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorBody{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, tx)
+
+	case len(route) == 3 && route[0] == "transactions" && route[2] == "status":
+		if !method(w, r, http.MethodGet) {
+			return
+		}
+		gw.status(w, r, name, route[1])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (gw *gateway) payment(w http.ResponseWriter, r *http.Request, name, kind string) {
+	var req paymentRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.Party == "" || req.Amount == "" || req.Reference == "" {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "party, amount and reference are required"})
+		return
+	}
+
+	app, ok := gw.application(w, name)
+	if !ok {
+		return
+	}
+
+	var (
+		resp *mpesa.TransactionResp
+		err  error
+	)
+
+	switch kind {
+	case "c2b":
+		resp, err = app.C2B(r.Context(), mpesa.C2BPayment{
+			CustomerMSISDN:           req.Party,
+			Amount:                   req.Amount,
+			TransactionReference:     req.Reference,
+			PurchasedItemsDesc:       req.Description,
+			ThirdPartyConversationID: req.ConversationID,
+		})
+	case "b2c":
+		resp, err = app.B2C(r.Context(), mpesa.B2CPayment{
+			CustomerMSISDN:           req.Party,
+			Amount:                   req.Amount,
+			TransactionReference:     req.Reference,
+			PaymentItemsDesc:         req.Description,
+			ThirdPartyConversationID: req.ConversationID,
+		})
+	case "b2b":
+		resp, err = app.B2B(r.Context(), mpesa.B2BPayment{
+			ReceiverPartyCode:        req.Party,
+			Amount:                   req.Amount,
+			TransactionReference:     req.Reference,
+			PurchasedItemsDesc:       req.Description,
+			ThirdPartyConversationID: req.ConversationID,
+		})
+	}
+
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result{
+		Code:                     resp.Code,
+		Description:              resp.Description,
+		TransactionID:            resp.TransactionID,
+		ConversationID:           resp.ConversationID,
+		ThirdPartyConversationID: resp.ThirdPartyConversationID,
+	})
+}
+
+func (gw *gateway) reverse(w http.ResponseWriter, r *http.Request, name string) {
+	var req reversalRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.TransactionID == "" || req.Amount == "" {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "transaction_id and amount are required"})
+		return
+	}
+
+	app, ok := gw.application(w, name)
+	if !ok {
+		return
+	}
+
+	resp, err := app.Reverse(r.Context(), mpesa.Reversal{
+		TransactionID:            req.TransactionID,
+		ReversalAmount:           req.Amount,
+		ThirdPartyConversationID: req.ConversationID,
+	})
+
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result{
+		Code:                     resp.Code,
+		Description:              resp.Description,
+		TransactionID:            resp.TransactionID,
+		ConversationID:           resp.ConversationID,
+		ThirdPartyConversationID: resp.ThirdPartyConversationID,
+	})
+}
+
+func (gw *gateway) status(w http.ResponseWriter, r *http.Request, name, ref string) {
+	app, ok := gw.application(w, name)
+	if !ok {
+		return
+	}
+
+	resp, err := app.QueryTransactionStatus(r.Context(), mpesa.StatusQuery{QueryReference: ref})
+
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result{
+		Code:                     resp.Code,
+		Description:              resp.Description,
+		ConversationID:           resp.ConversationID,
+		ThirdPartyConversationID: resp.ThirdPartyConversationID,
+		TransactionStatus:        resp.ResponseTransactionStatus,
+	})
+}
+
+// application resolves the application of tenant name, generating its
+// session on first use.
+func (gw *gateway) application(w http.ResponseWriter, name string) (*mpesa.Application, bool) {
+	app, err := gw.registry.Application(name)
+	if err != nil {
+		log.Printf("mpesa-gateway: %s: %v", name, err)
+		writeJSON(w, statusOf(err), errorBody{Error: "failed to open M-Pesa session: " + err.Error(), Code: codeOf(err)})
+		return nil, false
+	}
+	return app, true
+}
+
+// fail writes the error of an operation, whose response must not be used.
+func fail(w http.ResponseWriter, err error) {
+	writeJSON(w, statusOf(err), errorBody{Error: err.Error(), Code: codeOf(err)})
+}
+
+// statusOf maps an operation error to the gateway's HTTP status: 400 for
+// requests failing validation, 409 for payments reusing the conversation ID
+// of a recorded transaction, 422 for requests rejected by M-Pesa, 502 for
+// M-Pesa failures, 503 while a circuit is open and 504 on timeouts.
+func statusOf(err error) int {
+	var respErr *mpesa.ResponseError
+
+	switch {
+	case errors.Is(err, mpesa.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case pkgerrors.Contains(err, ledger.ErrDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, mpesa.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &respErr) && respErr.StatusCode < 500:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+func codeOf(err error) string {
+	var respErr *mpesa.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.Code
+	}
+	return ""
+}
+
+func method(w http.ResponseWriter, r *http.Request, m string) bool {
+	if r.Method == m {
+		return true
+	}
+	w.Header().Set("Allow", m)
+	writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: "method not allowed"})
+	return false
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/config"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/ledger"
+	pkgerrors "github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const callbackBody = `{"input_OriginalConversationID":"conv-1","input_ThirdPartyConversationID":"tp-1","input_TransactionID":"tx-1","input_ResultCode":"INS-0"}`
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testConfig configures the tz and gh tenants, trusting callbacks from
+// 196.11.240.0/24 unless trusted is false.
+func testConfig(trusted bool) *config.Config {
+	var sources []string
+	if trusted {
+		sources = []string{"196.11.240.0/24"}
+	}
+
+	return &config.Config{
+		Applications: map[string]config.Application{
+			"tz": {Key: "tz-key", Market: "vodacomTZN", Environment: "sandbox", TrustedSources: sources},
+			"gh": {Key: "gh-key", Market: "vodafoneGHA", Environment: "sandbox", TrustedSources: sources},
+		},
+	}
+}
+
+// testGateway returns a gateway sending its M-Pesa requests to api, where
+// shop-token may use tz only.
+func testGateway(t *testing.T, api *mpesatest.API) *gateway {
+	tokens, err := loadTokens(writeTokens(t, "shop-token tz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw, err := newGateway(testConfig(true), tokens, options{client: &http.Client{Transport: api}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gw
+}
+
+func TestGateway(t *testing.T) {
+	api := mpesatest.NewAPI().Handle(mpesa.OpC2B, func(req *http.Request) mpesatest.Reply {
+		body, _ := ioutil.ReadAll(req.Body)
+		if strings.Contains(string(body), "R-rejected") {
+			return mpesatest.Reply{Status: http.StatusBadRequest, Body: `{"output_ResponseCode":"INS-13","output_ResponseDesc":"Invalid Shortcode Used"}`}
+		}
+		return mpesatest.Reply{Status: http.StatusCreated, Body: `{"output_ResponseCode":"INS-0","output_ResponseDesc":"Request processed successfully","output_TransactionID":"tx-1"}`}
+	})
+	gw := testGateway(t, api)
+
+	cases := []struct {
+		desc     string
+		method   string
+		path     string
+		token    string
+		remote   string
+		body     string
+		status   int
+		contains string
+	}{
+		{desc: "liveness", method: http.MethodGet, path: "/healthz", status: http.StatusOK},
+		{desc: "readiness", method: http.MethodGet, path: "/readyz", status: http.StatusOK},
+		{desc: "unknown path", method: http.MethodGet, path: "/v2/tz", status: http.StatusNotFound},
+		{desc: "missing token", method: http.MethodPost, path: "/v1/tz/c2b", status: http.StatusUnauthorized},
+		{desc: "unknown token", method: http.MethodPost, path: "/v1/tz/c2b", token: "other-token", status: http.StatusUnauthorized},
+		{desc: "tenant not allowed", method: http.MethodPost, path: "/v1/gh/c2b", token: "shop-token", status: http.StatusForbidden},
+		{desc: "unknown tenant", method: http.MethodPost, path: "/v1/ke/c2b", token: "shop-token", status: http.StatusForbidden},
+		{desc: "unknown route", method: http.MethodPost, path: "/v1/tz/refunds", token: "shop-token", status: http.StatusNotFound},
+		{desc: "payment with GET", method: http.MethodGet, path: "/v1/tz/c2b", token: "shop-token", status: http.StatusMethodNotAllowed},
+		{desc: "lookup with POST", method: http.MethodPost, path: "/v1/tz/transactions/R1", token: "shop-token", status: http.StatusMethodNotAllowed},
+		{desc: "invalid body", method: http.MethodPost, path: "/v1/tz/c2b", token: "shop-token", body: `{"msisdn":"255744553111"}`, status: http.StatusBadRequest},
+		{desc: "missing fields", method: http.MethodPost, path: "/v1/tz/c2b", token: "shop-token", body: `{"party":"255744553111"}`, status: http.StatusBadRequest},
+		{
+			desc:     "payment",
+			method:   http.MethodPost,
+			path:     "/v1/tz/c2b",
+			token:    "shop-token",
+			body:     `{"party":"255744553111","amount":"100","reference":"R1"}`,
+			status:   http.StatusOK,
+			contains: `"transaction_id":"tx-1"`,
+		},
+		{
+			desc:     "payment rejected by M-Pesa",
+			method:   http.MethodPost,
+			path:     "/v1/tz/c2b",
+			token:    "shop-token",
+			body:     `{"party":"255744553111","amount":"100","reference":"R-rejected"}`,
+			status:   http.StatusUnprocessableEntity,
+			contains: `"code":"INS-13"`,
+		},
+		{desc: "recorded transaction", method: http.MethodGet, path: "/v1/tz/transactions/R1", token: "shop-token", status: http.StatusOK, contains: "tx-1"},
+		{desc: "unknown transaction", method: http.MethodGet, path: "/v1/tz/transactions/R9", token: "shop-token", status: http.StatusNotFound},
+		{desc: "callback of unknown tenant", method: http.MethodPost, path: "/callbacks/ke", remote: "196.11.240.7:4000", body: callbackBody, status: http.StatusNotFound},
+		{desc: "callback from untrusted address", method: http.MethodPost, path: "/callbacks/tz", remote: "10.0.0.1:4000", body: callbackBody, status: http.StatusForbidden},
+		{desc: "callback from trusted address", method: http.MethodPost, path: "/callbacks/tz", remote: "196.11.240.7:4000", body: callbackBody, status: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if tc.remote != "" {
+			r.RemoteAddr = tc.remote
+		}
+
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.status, w.Code, fmt.Sprintf("%s: expected status %d got %d: %s\n", tc.desc, tc.status, w.Code, w.Body.String()))
+		if tc.contains != "" {
+			assert.Contains(t, w.Body.String(), tc.contains, tc.desc)
+		}
+		if tc.status == http.StatusUnauthorized {
+			assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"), fmt.Sprintf("%s: expected a bearer challenge\n", tc.desc))
+		}
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	cases := []struct {
+		desc   string
+		err    error
+		status int
+	}{
+		{
+			desc:   "circuit open",
+			err:    &mpesa.CircuitOpenError{Circuit: mpesa.TransactionCircuit, Operation: mpesa.OpC2B},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			desc:   "invalid request",
+			err:    &mpesa.ValidationError{Field: "input_PaymentItemsDesc", Reason: "is required"},
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:   "reused conversation id",
+			err:    pkgerrors.Wrap(ledger.ErrDuplicate, pkgerrors.New("tpc-1")),
+			status: http.StatusConflict,
+		},
+		{
+			desc:   "timeout",
+			err:    fmt.Errorf("post: %w", context.DeadlineExceeded),
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			desc:   "rejected",
+			err:    &mpesa.ResponseError{StatusCode: http.StatusBadRequest, Code: "INS-13"},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			desc:   "server error",
+			err:    &mpesa.ResponseError{StatusCode: http.StatusInternalServerError},
+			status: http.StatusBadGateway,
+		},
+		{
+			desc:   "network",
+			err:    errors.New("connection reset"),
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range cases {
+		status := statusOf(tc.err)
+		assert.Equal(t, tc.status, status, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, status))
+	}
+}
+
+func TestNewGatewayCallbacks(t *testing.T) {
+	tokens, err := loadTokens(writeTokens(t, "shop-token tz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc    string
+		trusted bool
+		opts    options
+		fails   bool
+	}{
+		{desc: "trusted sources", trusted: true},
+		{desc: "callback token", opts: options{callbackToken: "secret"}},
+		{desc: "insecure callbacks", opts: options{insecureCallbacks: true}},
+		{desc: "unverified callbacks", fails: true},
+	}
+
+	for _, tc := range cases {
+		_, err := newGateway(testConfig(tc.trusted), tokens, tc.opts)
+		assert.Equal(t, tc.fails, err != nil, fmt.Sprintf("%s: expected failure %v got %v\n", tc.desc, tc.fails, err))
+	}
+}
+
+func TestCallbackToken(t *testing.T) {
+	tokens, err := loadTokens(writeTokens(t, "shop-token tz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw, err := newGateway(testConfig(false), tokens, options{callbackToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc   string
+		path   string
+		status int
+	}{
+		{desc: "missing token", path: "/callbacks/tz", status: http.StatusForbidden},
+		{desc: "wrong token", path: "/callbacks/tz?token=guess", status: http.StatusForbidden},
+		{desc: "token", path: "/callbacks/tz?token=secret", status: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(callbackBody)))
+		assert.Equal(t, tc.status, w.Code, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, w.Code))
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// allTenants grants a token every tenant.
+const allTenants = "*"
+
+// tokens maps the SHA-256 of API tokens to the tenants they may use. Tokens
+// are looked up by hash so that lookups do not leak the tokens through
+// timing.
+type tokens map[[sha256.Size]byte]map[string]bool
+
+func loadTokens(path string) (tokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := make(tokens)
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a token and its tenants", path, line)
+		}
+
+		tenants := make(map[string]bool)
+		for _, name := range strings.Split(fields[1], ",") {
+			if name != "" {
+				tenants[name] = true
+			}
+		}
+		t[sha256.Sum256([]byte(fields[0]))] = tenants
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(t) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+
+	return t, nil
+}
+
+// allows reports whether token may use tenant.
+func (t tokens) allows(token, tenant string) bool {
+	if token == "" {
+		return false
+	}
+
+	tenants, ok := t[sha256.Sum256([]byte(token))]
+	return ok && (tenants[allTenants] || tenants[tenant])
+}
+
+// known reports whether token is a valid token.
+func (t tokens) known(token string) bool {
+	_, ok := t[sha256.Sum256([]byte(token))]
+	return token != "" && ok
+}
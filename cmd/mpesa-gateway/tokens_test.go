@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTokens writes a tokens file and returns its path.
+func writeTokens(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tokens.txt")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTokens(t *testing.T) {
+	cases := []struct {
+		desc    string
+		content string
+		tokens  int
+		err     string
+	}{
+		{
+			desc:    "tokens with comments and blank lines",
+			content: "# token tenants\n\nshop-token  tz,gh\nadmin-token *\n",
+			tokens:  2,
+		},
+		{
+			desc:    "token without tenants",
+			content: "shop-token\n",
+			err:     ":1: expected a token and its tenants",
+		},
+		{
+			desc:    "no tokens",
+			content: "# nothing yet\n",
+			err:     "no tokens",
+		},
+	}
+
+	for _, tc := range cases {
+		tokens, err := loadTokens(writeTokens(t, tc.content))
+		if tc.err != "" {
+			assert.NotNil(t, err, fmt.Sprintf("%s: expected error %q\n", tc.desc, tc.err))
+			if err != nil {
+				assert.Contains(t, err.Error(), tc.err, tc.desc)
+			}
+			continue
+		}
+
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+		assert.Equal(t, tc.tokens, len(tokens), fmt.Sprintf("%s: expected %d tokens got %d\n", tc.desc, tc.tokens, len(tokens)))
+	}
+}
+
+func TestTokens(t *testing.T) {
+	tokens, err := loadTokens(writeTokens(t, "shop-token tz,gh\nadmin-token *\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc    string
+		token   string
+		tenant  string
+		known   bool
+		allowed bool
+	}{
+		{desc: "listed tenant", token: "shop-token", tenant: "tz", known: true, allowed: true},
+		{desc: "unlisted tenant", token: "shop-token", tenant: "ke", known: true},
+		{desc: "every tenant", token: "admin-token", tenant: "ke", known: true, allowed: true},
+		{desc: "unknown token", token: "other-token", tenant: "tz"},
+		{desc: "empty token", token: "", tenant: "tz"},
+	}
+
+	for _, tc := range cases {
+		known, allowed := tokens.known(tc.token), tokens.allows(tc.token, tc.tenant)
+		assert.Equal(t, tc.known, known, fmt.Sprintf("%s: expected known %v got %v\n", tc.desc, tc.known, known))
+		assert.Equal(t, tc.allowed, allowed, fmt.Sprintf("%s: expected allowed %v got %v\n", tc.desc, tc.allowed, allowed))
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Command mpesa-gen generates the request and response types of the mpesa
+// package from openapi.yaml: their JSON tags, the response code constants
+// and the Validate methods of the requests, see openapi.Spec.Generate.
+//
+//	mpesa-gen [-spec openapi.yaml] [-package mpesa] [-o types_gen.go]
+//
+// It is run by go generate in the repository root. New endpoints and market
+// specific fields are adopted by describing them in openapi.yaml, with the
+// x-go-name of every new schema and property, and regenerating.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mobilemoney/mpesa/pkg/openapi"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "mpesa-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("mpesa-gen", flag.ContinueOnError)
+	specPath := fs.String("spec", "openapi.yaml", "OpenAPI spec to generate from")
+	pkg := fs.String("package", openapi.DefaultPackage, "Go package of the generated types")
+	out := fs.String("o", "", "output file, standard output when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	spec, err := openapi.Load(*specPath)
+	if err != nil {
+		return err
+	}
+
+	src, err := spec.Generate(*pkg, filepath.Base(*specPath))
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(*out, src, 0644)
+}
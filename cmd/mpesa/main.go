@@ -0,0 +1,316 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Command mpesa calls the M-Pesa OpenAPI from the command line.
+//
+//	mpesa [global flags] <command> [flags]
+//
+// Configuration is read from --config (YAML or JSON), or else from the
+// MPESA_* environment variables and the --env-file .env file, exactly as
+// config.Load and config.FromEnv do.
+//
+// Exit codes: 0 success, 1 request rejected by the API, 2 usage error,
+// 3 configuration error, 4 network error, 5 authentication error,
+// 6 invalid request parameters, 7 transaction failed, 8 service unavailable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/config"
+)
+
+type globals struct {
+	configPath string
+	envFile    string
+	app        string
+	output     string
+	dryRun     bool
+	timeout    time.Duration
+}
+
+// action performs a command once its flags have been parsed.
+type action func(ctx context.Context, app *mpesa.Application) (interface{}, error)
+
+type command struct {
+	desc    string
+	prepare func(args []string) (action, error)
+}
+
+var commands = map[string]command{
+	"session":     {"generate a session key", prepareSession},
+	"c2b":         {"collect a customer payment", prepareC2B},
+	"b2c":         {"pay a customer", prepareB2C},
+	"b2b":         {"pay another business", prepareB2B},
+	"reverse":     {"reverse a transaction", prepareReverse},
+	"status":      {"query the status of a transaction", prepareStatus},
+	"beneficiary": {"look up the registered name of a customer", prepareBeneficiary},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var g globals
+
+	fs := flag.NewFlagSet("mpesa", flag.ContinueOnError)
+	fs.StringVar(&g.configPath, "config", "", "YAML or JSON configuration file")
+	fs.StringVar(&g.envFile, "env-file", ".env", "dotenv file loaded when --config is not set")
+	fs.StringVar(&g.app, "app", config.DefaultApplication, "configured application to use")
+	fs.StringVar(&g.output, "output", "table", "output format, table or json")
+	fs.BoolVar(&g.dryRun, "dry-run", false, "print the requests that would be sent instead of sending them")
+	fs.DurationVar(&g.timeout, "timeout", 60*time.Second, "overall command timeout")
+	fs.Usage = func() { usage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		usage(fs)
+		return exitUsage
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "mpesa: unknown command %q\n", fs.Arg(0))
+		usage(fs)
+		return exitUsage
+	}
+
+	if g.output != "table" && g.output != "json" {
+		fmt.Fprintf(os.Stderr, "mpesa: unknown output format %q\n", g.output)
+		return exitUsage
+	}
+
+	act, err := cmd.prepare(fs.Args()[1:])
+	if err != nil {
+		return exitUsage
+	}
+
+	cfg, err := loadConfig(g)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	opts, err := cfg.Options(g.app)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	if g.dryRun {
+		opts = append(opts, mpesa.WithHTTPClient(&http.Client{Transport: &dryRun{out: os.Stdout}}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+
+	a := cfg.Applications[g.app]
+	app, err := mpesa.NewApplication(a.Key, mpesa.Market(a.Market), mpesa.APIEnviroment(a.Environment), opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	result, err := act(ctx, app)
+
+	if !g.dryRun && result != nil {
+		if perr := printResult(os.Stdout, g.output, result); perr != nil {
+			fmt.Fprintln(os.Stderr, perr)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	return exitOK
+}
+
+func loadConfig(g globals) (*config.Config, error) {
+	if g.configPath != "" {
+		return config.Load(g.configPath)
+	}
+
+	if _, err := os.Stat(g.envFile); err == nil {
+		return config.Load(g.envFile)
+	}
+
+	return config.FromEnv()
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: mpesa [global flags] <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].desc)
+	}
+
+	fmt.Fprintln(os.Stderr, "\nglobal flags:")
+	fs.PrintDefaults()
+}
+
+// parse parses the flags of a command, failing when required flags are empty.
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	for _, name := range required {
+		if f := fs.Lookup(name); f == nil || f.Value.String() == "" {
+			fmt.Fprintf(os.Stderr, "mpesa %s: --%s is required\n", fs.Name(), name)
+			fs.Usage()
+			return errUsage
+		}
+	}
+
+	return nil
+}
+
+type sessionResult struct {
+	SessionKey           string `json:"session_key"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+}
+
+func prepareSession(args []string) (action, error) {
+	fs := flag.NewFlagSet("session", flag.ContinueOnError)
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	return func(_ context.Context, app *mpesa.Application) (interface{}, error) {
+		// NewApplication has already generated the session
+		return &sessionResult{SessionKey: app.SessionKey, PublicKeyFingerprint: app.PublicKeyFingerprint()}, nil
+	}, nil
+}
+
+func prepareC2B(args []string) (action, error) {
+	var p mpesa.C2BPayment
+
+	fs := flag.NewFlagSet("c2b", flag.ContinueOnError)
+	fs.StringVar(&p.CustomerMSISDN, "msisdn", "", "customer phone number")
+	fs.StringVar(&p.Amount, "amount", "", "amount to collect")
+	fs.StringVar(&p.TransactionReference, "reference", "", "transaction reference")
+	fs.StringVar(&p.PurchasedItemsDesc, "desc", "", "purchased items description")
+	fs.StringVar(&p.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID, generated when empty")
+	if err := parse(fs, args, "msisdn", "amount", "reference"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.C2B(ctx, p)
+	}, nil
+}
+
+func prepareB2C(args []string) (action, error) {
+	var p mpesa.B2CPayment
+
+	fs := flag.NewFlagSet("b2c", flag.ContinueOnError)
+	fs.StringVar(&p.CustomerMSISDN, "msisdn", "", "customer phone number")
+	fs.StringVar(&p.Amount, "amount", "", "amount to pay")
+	fs.StringVar(&p.TransactionReference, "reference", "", "transaction reference")
+	fs.StringVar(&p.PaymentItemsDesc, "desc", "", "payment items description")
+	fs.StringVar(&p.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID, generated when empty")
+	if err := parse(fs, args, "msisdn", "amount", "reference"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.B2C(ctx, p)
+	}, nil
+}
+
+func prepareB2B(args []string) (action, error) {
+	var p mpesa.B2BPayment
+
+	fs := flag.NewFlagSet("b2b", flag.ContinueOnError)
+	fs.StringVar(&p.ReceiverPartyCode, "receiver", "", "receiving business shortcode")
+	fs.StringVar(&p.Amount, "amount", "", "amount to pay")
+	fs.StringVar(&p.TransactionReference, "reference", "", "transaction reference")
+	fs.StringVar(&p.PurchasedItemsDesc, "desc", "", "purchased items description")
+	fs.StringVar(&p.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID, generated when empty")
+	if err := parse(fs, args, "receiver", "amount", "reference"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.B2B(ctx, p)
+	}, nil
+}
+
+func prepareReverse(args []string) (action, error) {
+	var r mpesa.Reversal
+
+	fs := flag.NewFlagSet("reverse", flag.ContinueOnError)
+	fs.StringVar(&r.TransactionID, "transaction-id", "", "ID of the transaction to reverse")
+	fs.StringVar(&r.ReversalAmount, "amount", "", "amount to reverse")
+	fs.StringVar(&r.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID, generated when empty")
+	if err := parse(fs, args, "transaction-id", "amount"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.Reverse(ctx, r)
+	}, nil
+}
+
+func prepareStatus(args []string) (action, error) {
+	var q mpesa.StatusQuery
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.StringVar(&q.QueryReference, "reference", "", "transaction ID, conversation ID or third party conversation ID")
+	fs.StringVar(&q.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID of the query, generated when empty")
+	if err := parse(fs, args, "reference"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.QueryTransactionStatus(ctx, q)
+	}, nil
+}
+
+func prepareBeneficiary(args []string) (action, error) {
+	var q mpesa.BeneficiaryQuery
+
+	fs := flag.NewFlagSet("beneficiary", flag.ContinueOnError)
+	fs.StringVar(&q.CustomerMSISDN, "msisdn", "", "customer phone number")
+	fs.StringVar(&q.KycQueryType, "kyc-type", "Name", "KYC query type")
+	fs.StringVar(&q.ThirdPartyConversationID, "conversation-id", "", "third party conversation ID, generated when empty")
+	if err := parse(fs, args, "msisdn"); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, app *mpesa.Application) (interface{}, error) {
+		return app.QueryBeneficiaryName(ctx, q)
+	}, nil
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/config"
+)
+
+const (
+	exitOK = iota
+	exitRejected
+	exitUsage
+	exitConfig
+	exitNetwork
+	exitAuth
+	exitInvalid
+	exitFailed
+	exitUnavailable
+)
+
+var errUsage = errors.New("usage")
+
+// responseClass maps M-Pesa response codes to exit codes.
+var responseClass = map[string]int{
+	"INS-2":    exitAuth,
+	"INS-25":   exitAuth,
+	"INS-26":   exitAuth,
+	"INS-2001": exitAuth,
+	"INS-13":   exitInvalid,
+	"INS-14":   exitInvalid,
+	"INS-15":   exitInvalid,
+	"INS-17":   exitInvalid,
+	"INS-18":   exitInvalid,
+	"INS-19":   exitInvalid,
+	"INS-20":   exitInvalid,
+	"INS-21":   exitInvalid,
+	"INS-22":   exitInvalid,
+	"INS-998":  exitInvalid,
+	"INS-2051": exitInvalid,
+	"INS-4":    exitFailed,
+	"INS-5":    exitFailed,
+	"INS-6":    exitFailed,
+	"INS-10":   exitFailed,
+	"INS-995":  exitFailed,
+	"INS-996":  exitFailed,
+	"INS-2006": exitFailed,
+	"INS-9":    exitUnavailable,
+	"INS-16":   exitUnavailable,
+}
+
+// exitCode classifies err into one of the documented exit codes.
+func exitCode(err error) int {
+	var (
+		respErr *mpesa.ResponseError
+		netErr  net.Error
+		cfgErr  *config.ValidationError
+		cfgErrs config.ValidationErrors
+	)
+
+	switch {
+	case err == nil:
+		return exitOK
+
+	case errors.As(err, &cfgErr), errors.As(err, &cfgErrs):
+		return exitConfig
+
+	case errors.Is(err, mpesa.ErrCircuitOpen):
+		return exitUnavailable
+
+	case errors.Is(err, mpesa.ErrInvalidRequest):
+		return exitInvalid
+
+	case errors.As(err, &respErr):
+		if class, ok := responseClass[respErr.Code]; ok {
+			return class
+		}
+		switch {
+		case respErr.StatusCode == http.StatusUnauthorized, respErr.StatusCode == http.StatusForbidden:
+			return exitAuth
+		case respErr.StatusCode >= 500, respErr.StatusCode == http.StatusTooManyRequests:
+			return exitUnavailable
+		}
+		return exitRejected
+
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return exitNetwork
+	}
+
+	return exitRejected
+}
+
+// printResult writes v as indented JSON or as a two column table of its JSON fields.
+func printResult(w io.Writer, format string, v interface{}) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = rt.Field(i).Name
+		}
+		name = strings.TrimPrefix(name, "output_")
+		fmt.Fprintf(tw, "%s\t%v\n", name, rv.Field(i).Interface())
+	}
+
+	return tw.Flush()
+}
+
+// dryRun is an http.RoundTripper printing requests instead of sending them.
+// getSession requests are answered with a placeholder session so that the
+// requests of the command itself can be built.
+type dryRun struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (d *dryRun) RoundTrip(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(d.out, "%s %s\n", req.Method, req.URL)
+	for _, name := range []string{"Content-Type", "Authorization", "Origin"} {
+		if v := req.Header.Get(name); v != "" {
+			if name == "Authorization" {
+				v = mask(v)
+			}
+			fmt.Fprintf(d.out, "%s: %s\n", name, v)
+		}
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "", "  ") == nil {
+			body = pretty.Bytes()
+		}
+		fmt.Fprintf(d.out, "\n%s\n", body)
+	}
+	fmt.Fprintln(d.out)
+
+	body := `{"output_ResponseCode":"INS-0","output_ResponseDesc":"Dry run"}`
+	if strings.HasSuffix(req.URL.Path, "/getSession/") {
+		body = `{"output_ResponseCode":"INS-0","output_ResponseDesc":"Dry run","output_SessionID":"dry-run"}`
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// mask hides all but the last four characters of a bearer token.
+func mask(v string) string {
+	token := strings.TrimPrefix(v, "Bearer ")
+	if len(token) <= 4 {
+		return "Bearer ****"
+	}
+	return "Bearer ****" + token[len(token)-4:]
+}
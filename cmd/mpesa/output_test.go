@@ -0,0 +1,228 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/config"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+// timeout is a network timeout.
+type timeout struct{}
+
+func (timeout) Error() string   { return "i/o timeout" }
+func (timeout) Timeout() bool   { return true }
+func (timeout) Temporary() bool { return true }
+
+var _ net.Error = timeout{}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		desc string
+		err  error
+		code int
+	}{
+		{
+			desc: "success",
+			code: exitOK,
+		},
+		{
+			desc: "configuration",
+			err:  &config.ValidationError{Key: "applications.tz.key", Msg: "required"},
+			code: exitConfig,
+		},
+		{
+			desc: "circuit open",
+			err:  &mpesa.CircuitOpenError{Circuit: mpesa.TransactionCircuit, Operation: mpesa.OpC2B},
+			code: exitUnavailable,
+		},
+		{
+			desc: "invalid request",
+			err:  &mpesa.ValidationError{Field: "input_Amount", Reason: "is required"},
+			code: exitInvalid,
+		},
+		{
+			desc: "invalid api key",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusUnauthorized, Code: "INS-2"},
+			code: exitAuth,
+		},
+		{
+			desc: "invalid shortcode",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusBadRequest, Code: "INS-13"},
+			code: exitInvalid,
+		},
+		{
+			desc: "duplicate transaction",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusConflict, Code: "INS-10"},
+			code: exitFailed,
+		},
+		{
+			desc: "unknown code, forbidden",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusForbidden, Code: "INS-999"},
+			code: exitAuth,
+		},
+		{
+			desc: "unknown code, server error",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusBadGateway},
+			code: exitUnavailable,
+		},
+		{
+			desc: "unknown code, too many requests",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusTooManyRequests},
+			code: exitUnavailable,
+		},
+		{
+			desc: "unknown code, bad request",
+			err:  &mpesa.ResponseError{StatusCode: http.StatusBadRequest, Code: "INS-999"},
+			code: exitRejected,
+		},
+		{
+			desc: "network",
+			err:  fmt.Errorf("post: %w", timeout{}),
+			code: exitNetwork,
+		},
+		{
+			desc: "command timeout",
+			err:  context.DeadlineExceeded,
+			code: exitNetwork,
+		},
+		{
+			desc: "other",
+			err:  errors.New("unexpected"),
+			code: exitRejected,
+		},
+	}
+
+	for _, tc := range cases {
+		code := exitCode(tc.err)
+		assert.Equal(t, tc.code, code, fmt.Sprintf("%s: expected exit code %d got %d\n", tc.desc, tc.code, code))
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	resp := mpesa.TransactionResp{
+		Code:          mpesa.CodeSuccess,
+		TransactionID: "tx-1",
+		Meta:          &mpesa.Meta{StatusCode: http.StatusCreated},
+	}
+
+	cases := []struct {
+		desc     string
+		format   string
+		contains []string
+		excludes []string
+	}{
+		{
+			desc:     "table",
+			format:   "table",
+			contains: []string{"ResponseCode", "INS-0", "TransactionID", "tx-1"},
+			excludes: []string{"Meta", "-  "},
+		},
+		{
+			desc:     "json",
+			format:   "json",
+			contains: []string{`"output_ResponseCode": "INS-0"`, `"output_TransactionID": "tx-1"`},
+			excludes: []string{"Meta", "StatusCode"},
+		},
+	}
+
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		if err := printResult(&buf, tc.format, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range tc.contains {
+			assert.Contains(t, buf.String(), s, fmt.Sprintf("%s: expected %q in\n%s\n", tc.desc, s, buf.String()))
+		}
+		for _, s := range tc.excludes {
+			assert.NotContains(t, buf.String(), s, fmt.Sprintf("%s: expected no %q in\n%s\n", tc.desc, s, buf.String()))
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	app := mpesatest.NewApplication(t, &dryRun{out: &out})
+
+	resp, err := app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Dry run", resp.Description, "expected the placeholder response\n")
+
+	cases := []struct {
+		desc     string
+		contains string
+	}{
+		{
+			desc:     "session request",
+			contains: "GET https://openapi.m-pesa.com/sandbox/ipg/v2/vodacomTZN/getSession/",
+		},
+		{
+			desc:     "payment request",
+			contains: "POST https://openapi.m-pesa.com/sandbox/ipg/v2/vodacomTZN/c2bPayment/singleStage/",
+		},
+		{
+			desc:     "masked session key",
+			contains: "Authorization: Bearer ****-run",
+		},
+		{
+			desc:     "indented body",
+			contains: "\n  \"input_Amount\": \"100\"",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.True(t, strings.Contains(out.String(), tc.contains), fmt.Sprintf("%s: expected %q in\n%s\n", tc.desc, tc.contains, out.String()))
+	}
+}
+
+func TestMask(t *testing.T) {
+	cases := []struct {
+		desc   string
+		header string
+		masked string
+	}{
+		{
+			desc:   "session key",
+			header: "Bearer 0123456789",
+			masked: "Bearer ****6789",
+		},
+		{
+			desc:   "short token",
+			header: "Bearer 1234",
+			masked: "Bearer ****",
+		},
+	}
+
+	for _, tc := range cases {
+		masked := mask(tc.header)
+		assert.Equal(t, tc.masked, masked, fmt.Sprintf("%s: expected %s got %s\n", tc.desc, tc.masked, masked))
+	}
+}
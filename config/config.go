@@ -0,0 +1,513 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package config builds fully configured applications from environment
+// variables, .env files and YAML or JSON files.
+//
+// A YAML file has the following schema, JSON files use the same keys:
+//
+//	defaults:
+//	  environment: sandbox        # sandbox or openapi, default sandbox
+//	  market: vodacomTZN          # vodacomTZN or vodafoneGHA
+//	  timeout: 30s                # HTTP client timeout, default 30s
+//	applications:
+//	  tz-main:                    # application (tenant) name
+//	    key: ${MPESA_TZ_KEY}      # required
+//	    market: vodacomTZN        # defaults.market when empty
+//	    environment: openapi      # defaults.environment when empty
+//	    service_provider_code: "000000"
+//	    public_key: ${MPESA_PUBLIC_KEY:-}
+//	    version: "1.0"
+//	    desc: Tanzania collections
+//	    session_life_time: 3600
+//	    trusted_sources: ["196.11.240.0/24"]
+//
+// String values may reference environment variables as ${VAR}, or as
+// ${VAR:-fallback} to use fallback when VAR is unset or empty. Referencing
+// an unset variable without a fallback is a validation error.
+//
+// Environment variables describe a single application named "default":
+//
+//	MPESA_APPLICATION_KEY, MPESA_MARKET, MPESA_ENVIRONMENT,
+//	MPESA_SERVICE_PROVIDER_CODE, MPESA_PUBLIC_KEY, MPESA_TIMEOUT
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pubkey"
+	"github.com/mobilemoney/mpesa/session"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultApplication names the application described by environment variables.
+const DefaultApplication = "default"
+
+const (
+	envApplicationKey      = "MPESA_APPLICATION_KEY"
+	envMarket              = "MPESA_MARKET"
+	envEnvironment         = "MPESA_ENVIRONMENT"
+	envServiceProviderCode = "MPESA_SERVICE_PROVIDER_CODE"
+	envPublicKey           = "MPESA_PUBLIC_KEY"
+	envTimeout             = "MPESA_TIMEOUT"
+
+	defEnvironment = mpesa.Sandbox
+	defTimeout     = 30 * time.Second
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown configuration file format")
+
+	ErrUnknownApplication = errors.New("unknown application")
+)
+
+var markets = map[mpesa.Market]bool{
+	mpesa.VodacomTanzania: true,
+	mpesa.VodafoneGHANA:   true,
+}
+
+var environments = map[mpesa.APIEnviroment]bool{
+	mpesa.Sandbox:    true,
+	mpesa.Production: true,
+}
+
+// Duration is a time.Duration read from strings such as "30s" or "1m".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	return d.parse(n.Value)
+}
+
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// Defaults apply to every application leaving the matching field empty.
+type Defaults struct {
+	Environment string   `json:"environment" yaml:"environment"`
+	Market      string   `json:"market" yaml:"market"`
+	Timeout     Duration `json:"timeout" yaml:"timeout"`
+}
+
+// Application describes one M-Pesa OpenAPI application.
+type Application struct {
+	Key                 string   `json:"key" yaml:"key"`
+	Market              string   `json:"market" yaml:"market"`
+	Environment         string   `json:"environment" yaml:"environment"`
+	ServiceProviderCode string   `json:"service_provider_code" yaml:"service_provider_code"`
+	PublicKey           string   `json:"public_key" yaml:"public_key"`
+	Version             string   `json:"version" yaml:"version"`
+	Desc                string   `json:"desc" yaml:"desc"`
+	SessionLifeTime     int      `json:"session_life_time" yaml:"session_life_time"`
+	TrustedSources      []string `json:"trusted_sources" yaml:"trusted_sources"`
+	Timeout             Duration `json:"timeout" yaml:"timeout"`
+}
+
+// Config is a set of named applications.
+type Config struct {
+	Defaults     Defaults               `json:"defaults" yaml:"defaults"`
+	Applications map[string]Application `json:"applications" yaml:"applications"`
+}
+
+// ValidationError names the configuration key holding an invalid value.
+type ValidationError struct {
+	Key string
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config: %s: %s", e.Key, e.Msg)
+}
+
+// ValidationErrors lists every invalid key found by Validate.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Load reads a configuration file, its format is chosen by extension:
+// .yaml and .yml for YAML, .json for JSON and .env for dotenv files, which
+// are loaded into the environment and read with FromEnv. The returned
+// configuration has been interpolated, defaulted and validated.
+func Load(path string) (*Config, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	if ext == ".env" || filepath.Base(path) == ".env" {
+		if err := mpesa.LoadEnvFile(path); err != nil {
+			return nil, err
+		}
+		return FromEnv()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		return nil, errors.Wrap(ErrUnknownFormat, errors.New(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// FromEnv builds a configuration holding the DefaultApplication described
+// by the MPESA_* environment variables.
+func FromEnv() (*Config, error) {
+	cfg := Config{
+		Defaults: Defaults{
+			Environment: mpesa.Env(envEnvironment, string(defEnvironment)),
+			Market:      os.Getenv(envMarket),
+		},
+		Applications: map[string]Application{
+			DefaultApplication: {
+				Key:                 os.Getenv(envApplicationKey),
+				ServiceProviderCode: os.Getenv(envServiceProviderCode),
+				PublicKey:           os.Getenv(envPublicKey),
+			},
+		},
+	}
+
+	if err := cfg.Defaults.Timeout.parse(os.Getenv(envTimeout)); err != nil {
+		return nil, &ValidationError{Key: envTimeout, Msg: err.Error()}
+	}
+
+	if err := cfg.prepare(); err != nil {
+		return nil, envKeys(err)
+	}
+
+	return &cfg, nil
+}
+
+// envVariables maps the keys of the configuration built by FromEnv to the
+// environment variables they are read from.
+var envVariables = map[string]string{
+	"defaults.environment":                                          envEnvironment,
+	"defaults.market":                                               envMarket,
+	"applications." + DefaultApplication + ".key":                   envApplicationKey,
+	"applications." + DefaultApplication + ".market":                envMarket,
+	"applications." + DefaultApplication + ".environment":           envEnvironment,
+	"applications." + DefaultApplication + ".service_provider_code": envServiceProviderCode,
+	"applications." + DefaultApplication + ".public_key":            envPublicKey,
+}
+
+// envKeys names the environment variables in the validation errors of err
+// rather than the configuration keys read from them.
+func envKeys(err error) error {
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	for _, e := range errs {
+		if name, ok := envVariables[e.Key]; ok {
+			e.Key = name
+		}
+	}
+	return errs
+}
+
+func (c *Config) prepare() error {
+	var errs ValidationErrors
+
+	c.Defaults.Environment = interpolate("defaults.environment", c.Defaults.Environment, &errs)
+	c.Defaults.Market = interpolate("defaults.market", c.Defaults.Market, &errs)
+
+	if c.Defaults.Environment == "" {
+		c.Defaults.Environment = string(defEnvironment)
+	}
+
+	if c.Defaults.Timeout == 0 {
+		c.Defaults.Timeout = Duration(defTimeout)
+	}
+
+	for name, a := range c.Applications {
+		key := "applications." + name + "."
+
+		a.Key = interpolate(key+"key", a.Key, &errs)
+		a.Market = interpolate(key+"market", a.Market, &errs)
+		a.Environment = interpolate(key+"environment", a.Environment, &errs)
+		a.ServiceProviderCode = interpolate(key+"service_provider_code", a.ServiceProviderCode, &errs)
+		a.PublicKey = interpolate(key+"public_key", a.PublicKey, &errs)
+		a.Version = interpolate(key+"version", a.Version, &errs)
+		a.Desc = interpolate(key+"desc", a.Desc, &errs)
+		for i, src := range a.TrustedSources {
+			a.TrustedSources[i] = interpolate(fmt.Sprintf("%strusted_sources[%d]", key, i), src, &errs)
+		}
+
+		if a.Market == "" {
+			a.Market = c.Defaults.Market
+		}
+
+		if a.Environment == "" {
+			a.Environment = c.Defaults.Environment
+		}
+
+		if a.Timeout == 0 {
+			a.Timeout = c.Defaults.Timeout
+		}
+
+		c.Applications[name] = a
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return c.Validate()
+}
+
+// Validate reports every missing or invalid key.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	if len(c.Applications) == 0 {
+		errs = append(errs, &ValidationError{Key: "applications", Msg: "at least one application is required"})
+	}
+
+	for _, name := range c.Names() {
+		a := c.Applications[name]
+		key := "applications." + name + "."
+
+		if a.Key == "" {
+			errs = append(errs, &ValidationError{Key: key + "key", Msg: "is required"})
+		}
+
+		if !markets[mpesa.Market(a.Market)] {
+			errs = append(errs, &ValidationError{Key: key + "market", Msg: fmt.Sprintf("unknown market %q", a.Market)})
+		}
+
+		if !environments[mpesa.APIEnviroment(a.Environment)] {
+			errs = append(errs, &ValidationError{Key: key + "environment", Msg: fmt.Sprintf("unknown environment %q", a.Environment)})
+		}
+
+		if a.SessionLifeTime < 0 {
+			errs = append(errs, &ValidationError{Key: key + "session_life_time", Msg: "must not be negative"})
+		}
+
+		if a.Timeout < 0 {
+			errs = append(errs, &ValidationError{Key: key + "timeout", Msg: "must not be negative"})
+		}
+
+		for i, src := range a.TrustedSources {
+			if _, err := callback.ParseSource(src); err != nil {
+				errs = append(errs, &ValidationError{Key: fmt.Sprintf("%strusted_sources[%d]", key, i), Msg: fmt.Sprintf("invalid IP address or CIDR range %q", src)})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Names returns the application names in sorted order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Applications))
+	for name := range c.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options returns the mpesa.Application options described by application name.
+func (c *Config) Options(name string) ([]mpesa.Option, error) {
+	opts, err := c.tenantOptions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, mpesa.WithHTTPClient(&http.Client{Timeout: time.Duration(c.Applications[name].Timeout)})), nil
+}
+
+// tenantOptions returns the options of application name but its HTTP client,
+// which is shared by the applications of a registry.
+func (c *Config) tenantOptions(name string) ([]mpesa.Option, error) {
+	a, ok := c.Applications[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownApplication, errors.New(name))
+	}
+
+	opts := []mpesa.Option{
+		mpesa.WithServiceProviderCode(a.ServiceProviderCode),
+	}
+
+	if a.PublicKey != "" {
+		pk, err := pubkey.Parse([]byte(a.PublicKey))
+		if err != nil {
+			return nil, &ValidationError{Key: "applications." + name + ".public_key", Msg: err.Error()}
+		}
+		opts = append(opts, mpesa.WithPublicKey(pk, nil))
+	}
+
+	return opts, nil
+}
+
+// NewApplication creates application name, opts are applied after the
+// configured options.
+func (c *Config) NewApplication(name string, opts ...mpesa.Option) (*mpesa.Application, error) {
+	cfgOpts, err := c.Options(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := c.Applications[name]
+	return mpesa.NewApplication(a.Key, mpesa.Market(a.Market), mpesa.APIEnviroment(a.Environment), append(cfgOpts, opts...)...)
+}
+
+// TenantConfig returns the registry tenant described by application name.
+// Its options are those of Options but the HTTP client, the registry's
+// client being shared by every tenant.
+func (c *Config) TenantConfig(name string) (mpesa.TenantConfig, error) {
+	opts, err := c.tenantOptions(name)
+	if err != nil {
+		return mpesa.TenantConfig{}, err
+	}
+
+	a := c.Applications[name]
+	return mpesa.TenantConfig{
+		Key:                 a.Key,
+		Market:              mpesa.Market(a.Market),
+		Environment:         mpesa.APIEnviroment(a.Environment),
+		ServiceProviderCode: a.ServiceProviderCode,
+		Options:             opts,
+	}, nil
+}
+
+// Registry returns a registry holding every configured application as a
+// tenant named after it, see TenantConfig. opts are applied to every
+// application. A nil client is replaced by one with the default timeout.
+func (c *Config) Registry(client *http.Client, opts ...mpesa.Option) (*mpesa.Registry, error) {
+	if client == nil {
+		client = &http.Client{Timeout: time.Duration(c.Defaults.Timeout)}
+	}
+
+	r := mpesa.NewRegistry(client, opts...)
+	for _, name := range c.Names() {
+		cfg, err := c.TenantConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Register(name, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// SessionConfig returns the session.Config of application name.
+func (c *Config) SessionConfig(name string) (session.Config, error) {
+	a, ok := c.Applications[name]
+	if !ok {
+		return session.Config{}, errors.Wrap(ErrUnknownApplication, errors.New(name))
+	}
+
+	return session.Config{
+		Application: session.Application{
+			Name:            name,
+			Version:         a.Version,
+			Desc:            a.Desc,
+			APIKey:          a.Key,
+			SessionLifeTime: a.SessionLifeTime,
+			TrustedSources:  a.TrustedSources,
+			PublicKey:       a.PublicKey,
+		},
+	}, nil
+}
+
+// Allowlist returns the callback allowlist of the trusted sources of
+// application name, see callback.NewAllowlist for proxies.
+func (c *Config) Allowlist(name string, proxies ...string) (*callback.Allowlist, error) {
+	a, ok := c.Applications[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownApplication, errors.New(name))
+	}
+
+	return callback.NewAllowlist(a.TrustedSources, proxies)
+}
+
+var reference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces ${VAR} and ${VAR:-fallback} references in value,
+// recording unset variables without fallback against key.
+func interpolate(key, value string, errs *ValidationErrors) string {
+	return reference.ReplaceAllStringFunc(value, func(ref string) string {
+		m := reference.FindStringSubmatch(ref)
+		if v := os.Getenv(m[1]); v != "" {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		*errs = append(*errs, &ValidationError{Key: key, Msg: fmt.Sprintf("environment variable %s is not set", m[1])})
+		return ""
+	})
+}
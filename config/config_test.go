@@ -0,0 +1,244 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package config_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/config"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `
+defaults:
+  market: vodacomTZN
+  timeout: 10s
+applications:
+  tz:
+    key: ${TEST_MPESA_TZ_KEY}
+    service_provider_code: "000000"
+    public_key: ${TEST_MPESA_UNSET:-fallback}
+  gh:
+    key: gh-key
+    market: vodafoneGHA
+    environment: openapi
+`
+
+func write(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "mpesa-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	os.Setenv("TEST_MPESA_TZ_KEY", "tz-secret")
+	defer os.Unsetenv("TEST_MPESA_TZ_KEY")
+
+	cfg, err := config.Load(write(t, "mpesa.yaml", yamlConfig))
+	assert.Nil(t, err, "unexpected error loading config")
+
+	assert.Equal(t, []string{"gh", "tz"}, cfg.Names())
+
+	tz := cfg.Applications["tz"]
+	assert.Equal(t, "tz-secret", tz.Key, "expected ${VAR} to be interpolated")
+	assert.Equal(t, "fallback", tz.PublicKey, "expected ${VAR:-fallback} to use fallback")
+	assert.Equal(t, "vodacomTZN", tz.Market, "expected default market")
+	assert.Equal(t, "sandbox", tz.Environment, "expected default environment")
+	assert.Equal(t, config.Duration(10*time.Second), tz.Timeout)
+
+	gh := cfg.Applications["gh"]
+	assert.Equal(t, "vodafoneGHA", gh.Market)
+	assert.Equal(t, "openapi", gh.Environment)
+
+	sc, err := cfg.SessionConfig("tz")
+	assert.Nil(t, err)
+	assert.Equal(t, "tz", sc.Name)
+	assert.Equal(t, "tz-secret", sc.APIKey)
+}
+
+func TestLoadJSON(t *testing.T) {
+	cfg, err := config.Load(write(t, "mpesa.json", `{"applications":{"gh":{"key":"k","market":"vodafoneGHA","timeout":"1m"}}}`))
+	assert.Nil(t, err, "unexpected error loading config")
+	assert.Equal(t, config.Duration(time.Minute), cfg.Applications["gh"].Timeout)
+}
+
+func TestValidationErrorsNameKey(t *testing.T) {
+	cases := []struct {
+		desc    string
+		content string
+		key     string
+	}{
+		{
+			desc:    "missing key",
+			content: "applications:\n  tz:\n    market: vodacomTZN\n",
+			key:     "applications.tz.key",
+		},
+		{
+			desc:    "unknown market",
+			content: "applications:\n  tz:\n    key: k\n    market: atlantis\n",
+			key:     "applications.tz.market",
+		},
+		{
+			desc:    "unknown environment",
+			content: "applications:\n  tz:\n    key: k\n    market: vodacomTZN\n    environment: staging\n",
+			key:     "applications.tz.environment",
+		},
+		{
+			desc:    "unset secret",
+			content: "applications:\n  tz:\n    key: ${TEST_MPESA_UNSET}\n    market: vodacomTZN\n",
+			key:     "applications.tz.key",
+		},
+		{
+			desc:    "invalid trusted source",
+			content: "applications:\n  tz:\n    key: k\n    market: vodacomTZN\n    trusted_sources: [\"196.11.240.0/33\"]\n",
+			key:     "applications.tz.trusted_sources[0]",
+		},
+		{
+			desc:    "no applications",
+			content: "defaults:\n  market: vodacomTZN\n",
+			key:     "applications",
+		},
+	}
+
+	for _, tc := range cases {
+		_, err := config.Load(write(t, "mpesa.yml", tc.content))
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected validation error\n", tc.desc))
+		if err != nil {
+			assert.True(t, strings.Contains(err.Error(), tc.key+":"), fmt.Sprintf("%s: expected %q to name %s\n", tc.desc, err, tc.key))
+		}
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	for _, k := range []string{"MPESA_APPLICATION_KEY", "MPESA_MARKET", "MPESA_ENVIRONMENT"} {
+		defer os.Unsetenv(k)
+	}
+
+	cfg, err := config.Load(write(t, "mpesa.env", "MPESA_APPLICATION_KEY=env-key\nMPESA_MARKET=vodacomTZN\n"))
+	assert.Nil(t, err, "unexpected error loading config")
+
+	app := cfg.Applications[config.DefaultApplication]
+	assert.Equal(t, "env-key", app.Key)
+	assert.Equal(t, "vodacomTZN", app.Market)
+	assert.Equal(t, "sandbox", app.Environment)
+}
+
+func TestFromEnvValidationErrorsNameVariable(t *testing.T) {
+	cases := []struct {
+		desc string
+		env  map[string]string
+		key  string
+	}{
+		{
+			desc: "missing key",
+			env:  map[string]string{"MPESA_MARKET": "vodacomTZN"},
+			key:  "MPESA_APPLICATION_KEY",
+		},
+		{
+			desc: "unknown market",
+			env:  map[string]string{"MPESA_APPLICATION_KEY": "k", "MPESA_MARKET": "atlantis"},
+			key:  "MPESA_MARKET",
+		},
+		{
+			desc: "unknown environment",
+			env:  map[string]string{"MPESA_APPLICATION_KEY": "k", "MPESA_MARKET": "vodacomTZN", "MPESA_ENVIRONMENT": "staging"},
+			key:  "MPESA_ENVIRONMENT",
+		},
+		{
+			desc: "invalid timeout",
+			env:  map[string]string{"MPESA_APPLICATION_KEY": "k", "MPESA_MARKET": "vodacomTZN", "MPESA_TIMEOUT": "soon"},
+			key:  "MPESA_TIMEOUT",
+		},
+	}
+
+	vars := []string{"MPESA_APPLICATION_KEY", "MPESA_MARKET", "MPESA_ENVIRONMENT", "MPESA_TIMEOUT"}
+	for _, k := range vars {
+		defer os.Unsetenv(k)
+	}
+
+	for _, tc := range cases {
+		for _, k := range vars {
+			os.Unsetenv(k)
+		}
+		for k, v := range tc.env {
+			os.Setenv(k, v)
+		}
+
+		_, err := config.FromEnv()
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected validation error\n", tc.desc))
+		if err != nil {
+			assert.True(t, strings.Contains(err.Error(), tc.key+":"), fmt.Sprintf("%s: expected %q to name %s\n", tc.desc, err, tc.key))
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	der, err := x509.MarshalPKIXPublicKey(&mpesatest.Key().PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(write(t, "mpesa.yaml", fmt.Sprintf("applications:\n  tz:\n    key: tz-key\n    market: vodacomTZN\n    public_key: %s\n", base64.StdEncoding.EncodeToString(der))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var key string
+	api := mpesatest.NewAPI().Handle(mpesa.OpGetSession, func(req *http.Request) mpesatest.Reply {
+		encrypted, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, mpesatest.Key(), encrypted)
+		if err != nil {
+			return mpesatest.Reply{Status: http.StatusUnauthorized, Body: `{"output_ResponseCode":"INS-2"}`}
+		}
+		key = string(decrypted)
+		return mpesatest.Reply{Status: http.StatusOK, Body: `{"output_ResponseCode":"INS-0","output_SessionID":"session"}`}
+	})
+
+	r, err := cfg.Registry(&http.Client{Transport: api})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Application("tz")
+	assert.Nil(t, err, "expected the tenant to use the configured public key")
+	assert.Equal(t, "tz-key", key, "expected the key to be encrypted with the configured public key")
+
+	_, err = cfg.TenantConfig("gh")
+	assert.True(t, errors.Contains(err, config.ErrUnknownApplication), fmt.Sprintf("expected error %v got %v\n", config.ErrUnknownApplication, err))
+}
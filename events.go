@@ -0,0 +1,374 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of an event, it is the CloudEvents type.
+//
+// The events completing an operation are emitted once it has been recorded
+// to the journal, and EventCallbackReceived once the callback has been
+// dispatched, so that subscribers find both up to date. A callback whose
+// dispatch fails emits no event.
+type EventType string
+
+const (
+	EventSessionRefreshed  EventType = "com.mpesa.session.refreshed"
+	EventPaymentInitiated  EventType = "com.mpesa.payment.initiated"
+	EventPaymentCompleted  EventType = "com.mpesa.payment.completed"
+	EventPaymentFailed     EventType = "com.mpesa.payment.failed"
+	EventReversalCompleted EventType = "com.mpesa.reversal.completed"
+	EventCallbackReceived  EventType = "com.mpesa.callback.received"
+
+	// AllEvents subscribes to every event type.
+	AllEvents EventType = ""
+)
+
+const defEventBuffer = 256
+
+// SessionRefreshed is the data of EventSessionRefreshed.
+type SessionRefreshed struct {
+	Market               Market        `json:"market"`
+	Environment          APIEnviroment `json:"environment"`
+	PublicKeyFingerprint string        `json:"public_key_fingerprint"`
+}
+
+// Payment is the data of EventPaymentInitiated, EventPaymentCompleted and
+// EventPaymentFailed. A failed payment was not confirmed by the API, Error
+// tells whether it was rejected or no response was received, in which case
+// it may still have been made.
+type Payment struct {
+	Operation                string `json:"operation"`
+	ThirdPartyConversationID string `json:"third_party_conversation_id"`
+	Amount                   string `json:"amount"`
+	Currency                 string `json:"currency"`
+	Reference                string `json:"reference"`
+	TransactionID            string `json:"transaction_id,omitempty"`
+	ConversationID           string `json:"conversation_id,omitempty"`
+	ResponseCode             string `json:"response_code,omitempty"`
+	Error                    string `json:"error,omitempty"`
+}
+
+// ReversalCompleted is the data of EventReversalCompleted.
+type ReversalCompleted struct {
+	TransactionID            string `json:"transaction_id"`
+	Amount                   string `json:"amount"`
+	ThirdPartyConversationID string `json:"third_party_conversation_id"`
+	ConversationID           string `json:"conversation_id"`
+}
+
+// CallbackReceived is the data of EventCallbackReceived.
+type CallbackReceived struct {
+	OriginalConversationID   string `json:"original_conversation_id"`
+	ThirdPartyConversationID string `json:"third_party_conversation_id"`
+	TransactionID            string `json:"transaction_id"`
+	ResultCode               string `json:"result_code"`
+	Source                   string `json:"source"`
+}
+
+// Event is an SDK lifecycle event. It marshals to a CloudEvents 1.0 JSON
+// event, with Data as its JSON data.
+type Event struct {
+	ID      string
+	Source  string
+	Type    EventType
+	Subject string
+	Time    time.Time
+
+	// Data is one of SessionRefreshed, Payment, ReversalCompleted or
+	// CallbackReceived depending on Type.
+	Data interface{}
+}
+
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            EventType       `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e Event) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            e.Time.UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	})
+}
+
+// Publisher bridges events to a message broker.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// EventBus delivers events to subscribers in process. Synchronous
+// subscribers run in the goroutine that emits the event, so they must be
+// fast and must not call back into the application; they may subscribe and
+// unsubscribe. Asynchronous
+// subscribers each run in their own goroutine, in event order, behind a
+// bounded queue; events that find the queue full are dropped and counted.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   map[int]*subscription
+	nextID int
+	closed bool
+
+	dropped int64
+}
+
+type subscription struct {
+	typ   EventType
+	fn    func(ctx context.Context, e Event)
+	queue chan Event
+	done  chan struct{}
+}
+
+// NewEventBus returns an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]*subscription)}
+}
+
+// Subscribe calls fn synchronously with every event of type typ, or every
+// event for AllEvents. It returns a function that cancels the subscription.
+func (b *EventBus) Subscribe(typ EventType, fn func(ctx context.Context, e Event)) (unsubscribe func()) {
+	return b.subscribe(&subscription{typ: typ, fn: fn})
+}
+
+// SubscribeAsync calls fn from a goroutine of its own with every event of
+// type typ, or every event for AllEvents, queueing up to buffer events, 256
+// by default. fn is called with a context that is not cancelled when the
+// emitting call returns. It returns a function that cancels the
+// subscription once the queued events have been delivered.
+func (b *EventBus) SubscribeAsync(typ EventType, buffer int, fn func(ctx context.Context, e Event)) (unsubscribe func()) {
+	if buffer <= 0 {
+		buffer = defEventBuffer
+	}
+
+	s := &subscription{typ: typ, fn: fn, queue: make(chan Event, buffer), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for e := range s.queue {
+			fn(context.Background(), e)
+		}
+	}()
+
+	return b.subscribe(s)
+}
+
+// SubscribePublisher publishes every event to p asynchronously, passing
+// publish errors to onError when set.
+func (b *EventBus) SubscribePublisher(p Publisher, onError func(Event, error)) (unsubscribe func()) {
+	return b.SubscribeAsync(AllEvents, 0, func(ctx context.Context, e Event) {
+		if err := p.Publish(ctx, e); err != nil && onError != nil {
+			onError(e, err)
+		}
+	})
+}
+
+func (b *EventBus) subscribe(s *subscription) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		if s.queue != nil {
+			close(s.queue)
+		}
+		return func() {}
+	}
+
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = s
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			_, ok := b.subs[id]
+			delete(b.subs, id)
+			b.mu.Unlock()
+
+			if ok && s.queue != nil {
+				close(s.queue)
+				<-s.done
+			}
+		})
+	}
+}
+
+// Emit delivers e to its subscribers, filling in its ID and Time when empty.
+func (b *EventBus) Emit(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+
+	if e.ID == "" {
+		e.ID = NewConversationID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	// synchronous subscribers are called without the lock, so that they
+	// may subscribe or unsubscribe
+	var calls []*subscription
+
+	b.mu.RLock()
+	for _, s := range b.subs {
+		if s.typ != AllEvents && s.typ != e.Type {
+			continue
+		}
+
+		if s.queue == nil {
+			calls = append(calls, s)
+			continue
+		}
+
+		select {
+		case s.queue <- e:
+		default:
+			atomic.AddInt64(&b.dropped, 1)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range calls {
+		s.fn(ctx, e)
+	}
+}
+
+// Dropped returns the number of events dropped by asynchronous subscribers
+// whose queue was full.
+func (b *EventBus) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// Close cancels every subscription, waiting for asynchronous subscribers
+// to deliver their queued events. Events emitted after Close are dropped.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = make(map[int]*subscription)
+	b.closed = true
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if s.queue != nil {
+			close(s.queue)
+			<-s.done
+		}
+	}
+}
+
+// WithEventBus emits the application's events to bus, e.g. to share a bus
+// between the applications of a Registry. A nil bus keeps the application's
+// own.
+func WithEventBus(bus *EventBus) Option {
+	return func(app *Application) {
+		if bus != nil {
+			app.events = bus
+		}
+	}
+}
+
+// Events returns the event bus of the application.
+func (app *Application) Events() *EventBus {
+	return app.events
+}
+
+// emit emits an event of typ with data, sourced from the application.
+func (app *Application) emit(ctx context.Context, typ EventType, subject string, data interface{}) {
+	app.events.Emit(ctx, Event{
+		Source:  fmt.Sprintf("/mpesa/%s/%s", app.market, app.Type),
+		Type:    typ,
+		Subject: subject,
+		Data:    data,
+	})
+}
+
+// emitOperation emits the events of operation op with input before it is
+// sent, when v is nil, or after it completed with response v and err.
+func (app *Application) emitOperation(ctx context.Context, op string, input, v interface{}, err error) {
+	var p Payment
+
+	switch in := input.(type) {
+	case C2BPayment:
+		p = Payment{ThirdPartyConversationID: in.ThirdPartyConversationID, Amount: in.Amount, Currency: in.Currency, Reference: in.TransactionReference}
+	case B2CPayment:
+		p = Payment{ThirdPartyConversationID: in.ThirdPartyConversationID, Amount: in.Amount, Currency: in.Currency, Reference: in.TransactionReference}
+	case B2BPayment:
+		p = Payment{ThirdPartyConversationID: in.ThirdPartyConversationID, Amount: in.Amount, Currency: in.Currency, Reference: in.TransactionReference}
+
+	case Reversal:
+		resp, ok := v.(*ReversalResp)
+		if ok && err == nil && resp.Code == CodeSuccess {
+			app.emit(ctx, EventReversalCompleted, in.TransactionID, ReversalCompleted{
+				TransactionID:            in.TransactionID,
+				Amount:                   in.ReversalAmount,
+				ThirdPartyConversationID: in.ThirdPartyConversationID,
+				ConversationID:           resp.ConversationID,
+			})
+		}
+		return
+
+	default:
+		return
+	}
+
+	p.Operation = op
+
+	if v == nil {
+		app.emit(ctx, EventPaymentInitiated, p.ThirdPartyConversationID, p)
+		return
+	}
+
+	typ := EventPaymentFailed
+	if resp, ok := v.(*TransactionResp); ok {
+		p.TransactionID = resp.TransactionID
+		p.ConversationID = resp.ConversationID
+		p.ResponseCode = resp.Code
+		if err == nil && resp.Code == CodeSuccess {
+			typ = EventPaymentCompleted
+		}
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	app.emit(ctx, typ, p.ThirdPartyConversationID, p)
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+type publisher struct {
+	mu     sync.Mutex
+	events []mpesa.Event
+}
+
+func (p *publisher) Publish(_ context.Context, e mpesa.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+// countingJournal counts the recorded entries.
+type countingJournal struct {
+	mu      sync.Mutex
+	entries int
+}
+
+func (j *countingJournal) Record(context.Context, mpesa.JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries++
+}
+
+func (j *countingJournal) recorded() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.entries
+}
+
+func TestEventsAfterJournal(t *testing.T) {
+	j := &countingJournal{}
+	api := mpesatest.NewAPI().On(mpesa.OpC2B, mpesatest.Reply{Status: http.StatusCreated, Body: accepted})
+	app := mpesatest.NewApplication(t, api, mpesa.WithJournal(j))
+
+	journaled := map[mpesa.EventType]int{}
+	app.Events().Subscribe(mpesa.AllEvents, func(_ context.Context, e mpesa.Event) {
+		journaled[e.Type] = j.recorded()
+	})
+
+	_, err := app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"})
+	assert.Nil(t, err, fmt.Sprintf("c2b: expected no error got %v\n", err))
+
+	cases := []struct {
+		desc      string
+		typ       mpesa.EventType
+		journaled int
+	}{
+		{desc: "initiated before the journal", typ: mpesa.EventPaymentInitiated, journaled: 0},
+		{desc: "completed after the journal", typ: mpesa.EventPaymentCompleted, journaled: 1},
+	}
+
+	for _, tc := range cases {
+		got, ok := journaled[tc.typ]
+		assert.True(t, ok, fmt.Sprintf("%s: expected %s to be emitted\n", tc.desc, tc.typ))
+		assert.Equal(t, tc.journaled, got, fmt.Sprintf("%s: expected %d journaled entries got %d\n", tc.desc, tc.journaled, got))
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := mpesa.NewEventBus()
+
+	var syncTypes, asyncTypes []mpesa.EventType
+	unsubscribe := bus.Subscribe(mpesa.EventPaymentCompleted, func(_ context.Context, e mpesa.Event) {
+		syncTypes = append(syncTypes, e.Type)
+	})
+	bus.SubscribeAsync(mpesa.AllEvents, 0, func(_ context.Context, e mpesa.Event) {
+		asyncTypes = append(asyncTypes, e.Type)
+	})
+
+	pub := &publisher{}
+	bus.SubscribePublisher(pub, nil)
+
+	cases := []struct {
+		desc  string
+		event mpesa.Event
+		sync  int
+	}{
+		{desc: "deliver subscribed type", event: mpesa.Event{Type: mpesa.EventPaymentCompleted, Data: mpesa.Payment{}}, sync: 1},
+		{desc: "skip other types", event: mpesa.Event{Type: mpesa.EventSessionRefreshed, Data: mpesa.SessionRefreshed{}}, sync: 1},
+		{desc: "deliver subscribed type again", event: mpesa.Event{Type: mpesa.EventPaymentCompleted, Data: mpesa.Payment{}}, sync: 2},
+	}
+
+	for _, tc := range cases {
+		bus.Emit(context.Background(), tc.event)
+		assert.Equal(t, tc.sync, len(syncTypes), fmt.Sprintf("%s: expected %d synchronous deliveries got %d\n", tc.desc, tc.sync, len(syncTypes)))
+	}
+
+	unsubscribe()
+	bus.Emit(context.Background(), mpesa.Event{Type: mpesa.EventPaymentCompleted})
+	assert.Equal(t, 2, len(syncTypes), fmt.Sprintf("unsubscribe: expected 2 synchronous deliveries got %d\n", len(syncTypes)))
+
+	bus.Close()
+	assert.Equal(t, 4, len(asyncTypes), fmt.Sprintf("close: expected 4 asynchronous deliveries got %d\n", len(asyncTypes)))
+	assert.Equal(t, 4, len(pub.events), fmt.Sprintf("close: expected 4 published events got %d\n", len(pub.events)))
+	assert.Equal(t, int64(0), bus.Dropped(), fmt.Sprintf("close: expected no dropped events got %d\n", bus.Dropped()))
+}
+
+func TestEventBusDropsWhenFull(t *testing.T) {
+	bus := mpesa.NewEventBus()
+
+	block := make(chan struct{})
+	bus.SubscribeAsync(mpesa.AllEvents, 1, func(context.Context, mpesa.Event) { <-block })
+
+	for i := 0; i < 5; i++ {
+		bus.Emit(context.Background(), mpesa.Event{Type: mpesa.EventPaymentInitiated})
+	}
+
+	// the subscriber holds one event and queues another
+	assert.True(t, bus.Dropped() >= 3, fmt.Sprintf("full queue: expected at least 3 dropped events got %d\n", bus.Dropped()))
+	close(block)
+	bus.Close()
+}
+
+func TestEventBusSubscribeFromSubscriber(t *testing.T) {
+	bus := mpesa.NewEventBus()
+
+	var (
+		once  int
+		later int
+	)
+
+	var unsubscribe func()
+	unsubscribe = bus.Subscribe(mpesa.AllEvents, func(context.Context, mpesa.Event) {
+		once++
+		unsubscribe()
+		bus.Subscribe(mpesa.AllEvents, func(context.Context, mpesa.Event) { later++ })
+	})
+
+	done := make(chan struct{})
+	go func() {
+		bus.Emit(context.Background(), mpesa.Event{Type: mpesa.EventPaymentInitiated})
+		bus.Emit(context.Background(), mpesa.Event{Type: mpesa.EventPaymentInitiated})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe from subscriber: expected Emit not to deadlock")
+	}
+
+	assert.Equal(t, 1, once, fmt.Sprintf("subscribe from subscriber: expected 1 delivery to the first subscriber got %d\n", once))
+	assert.Equal(t, 1, later, fmt.Sprintf("subscribe from subscriber: expected 1 delivery to the second subscriber got %d\n", later))
+}
+
+func TestWithEventBus(t *testing.T) {
+	shared := mpesa.NewEventBus()
+
+	cases := []struct {
+		desc   string
+		bus    *mpesa.EventBus
+		shared bool
+	}{
+		{desc: "shared bus", bus: shared, shared: true},
+		{desc: "nil bus", bus: nil},
+	}
+
+	for _, tc := range cases {
+		app := mpesatest.NewApplication(t, mpesatest.NewAPI(), mpesa.WithEventBus(tc.bus))
+
+		assert.NotNil(t, app.Events(), fmt.Sprintf("%s: expected an event bus\n", tc.desc))
+		assert.Equal(t, tc.shared, app.Events() == shared, fmt.Sprintf("%s: expected shared bus %v\n", tc.desc, tc.shared))
+	}
+}
+
+func TestEventCloudEventJSON(t *testing.T) {
+	e := mpesa.Event{
+		ID:      "1",
+		Source:  "/mpesa/vodacomTZN/sandbox",
+		Type:    mpesa.EventReversalCompleted,
+		Subject: "tx-1",
+		Time:    time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+		Data:    mpesa.ReversalCompleted{TransactionID: "tx-1", Amount: "10"},
+	}
+
+	b, err := json.Marshal(e)
+	assert.Nil(t, err, fmt.Sprintf("marshal: expected no error got %v\n", err))
+
+	var got map[string]interface{}
+	json.Unmarshal(b, &got)
+
+	cases := []struct {
+		attr  string
+		value interface{}
+	}{
+		{attr: "specversion", value: "1.0"},
+		{attr: "id", value: "1"},
+		{attr: "source", value: "/mpesa/vodacomTZN/sandbox"},
+		{attr: "type", value: "com.mpesa.reversal.completed"},
+		{attr: "subject", value: "tx-1"},
+		{attr: "time", value: "2020-06-01T12:00:00Z"},
+		{attr: "datacontenttype", value: "application/json"},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.value, got[tc.attr], fmt.Sprintf("attribute %s: expected %v got %v\n", tc.attr, tc.value, got[tc.attr]))
+	}
+
+	data, _ := got["data"].(map[string]interface{})
+	assert.Equal(t, "tx-1", data["transaction_id"], fmt.Sprintf("data: expected transaction_id tx-1 got %v\n", data["transaction_id"]))
+}
@@ -17,14 +17,12 @@
 package main
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/mobilemoney/mpesa"
-	"github.com/mobilemoney/mpesa/session"
+	"github.com/mobilemoney/mpesa/pubkey"
 	"log"
+	"os"
 )
 
 
@@ -36,24 +34,16 @@ func main() {
 	}
 	fmt.Println(mpesa.Version())
 
-	se := `MIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEArv9yxA69XQKBo24BaF/D+fvlqmGdYjqLQ5WtNBb5tquqGvAvG3WMFETVUSow/LizQalxj2ElMVrUmzu5mGGkxK08bWEXF7a1DEvtVJs6nppIlFJc2SnrU14AOrIrB28ogm58JjAl5BOQawOXD5dfSk7MaAA82pVHoIqEu0FxA8BOKU+RGTihRU+ptw1j4bsAJYiPbSX6i71gfPvwHPYamM0bfI4CmlsUUR3KvCG24rB6FNPcRBhM3jDuv8ae2kC33w9hEq8qNB55uw51vK7hyXoAa+U7IqP1y6nBdlN25gkxEA8yrsl1678cspeXr+3ciRyqoRgj9RD/ONbJhhxFvt1cLBh+qwK2eqISfBb06eRnNeC71oBokDm3zyCnkOtMDGl7IvnMfZfEPFCfg5QgJVk1msPpRvQxmEsrX9MQRyFVzgy2CWNIb7c+jPapyrNwoUbANlN8adU1m6yOuoX7F49x+OjiG2se0EJ6nafeKUXw/+hiJZvELUYgzKUtMAZVTNZfT8jjb58j8GVtuS+6TM2AutbejaCV84ZK58E2CRJqhmjQibEUO6KPdD7oTlEkFy52Y1uOOBXgYpqMzufNPmfdqqqSM4dU70PO8ogyKGiLAIxCetMjjm6FCMEA3Kc8K0Ig7/XtFm9By6VxTJK1Mg36TlHaZKP6VzVLXMtesJECAwEAAQ==`
-
-	pubkeybytes, err := base64.StdEncoding.DecodeString(se)
-
-	if err != nil {
-		fmt.Println(err)
+	//MPESA_PUBLIC_KEY_FILE may point to a PEM, DER or base64 key file,
+	//otherwise the published sandbox key is used
+	pk, err := pubkey.ParseBase64(pubkey.Sandbox)
+	if path := os.Getenv("MPESA_PUBLIC_KEY_FILE"); path != "" {
+		pk, err = pubkey.LoadFile(path)
 	}
 
-	pk,err := x509.ParsePKIXPublicKey(pubkeybytes)
-
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal(err)
 	}
 
-//	var pkey *rsa.PublicKey
-	if _, ok := pk.(*rsa.PublicKey); ok{
-		fmt.Println(session.ErrNotRSAPubKey)
-	}
-
-
+	fmt.Println(pubkey.Fingerprint(pk))
 }
@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/joho/godotenv v1.3.0
-	github.com/stretchr/testify v1.6.1 // indirect
+	github.com/stretchr/testify v1.6.1
 	github.com/subosito/gotenv v1.2.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
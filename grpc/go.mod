@@ -0,0 +1,23 @@
+module github.com/mobilemoney/mpesa/grpc
+
+go 1.23
+
+require (
+	github.com/mobilemoney/mpesa v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.6.1
+	google.golang.org/grpc v1.72.0
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/subosito/gotenv v1.2.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250218202821-56aae31c358a // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
+
+replace github.com/mobilemoney/mpesa => ../
@@ -0,0 +1,21 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package mpesapb holds the Go types and gRPC stubs generated from
+// proto/mpesa/v1/mpesa.proto, see the server package for the service.
+package mpesapb
+
+//go:generate protoc -I ../proto --go_out=.. --go_opt=module=github.com/mobilemoney/mpesa/grpc --go-grpc_out=.. --go-grpc_opt=module=github.com/mobilemoney/mpesa/grpc mpesa/v1/mpesa.proto
@@ -0,0 +1,840 @@
+// Copyright 2020 Infolabs Inc & Associates
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: mpesa/v1/mpesa.proto
+
+package mpesapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// TransactionStatus is the lifecycle status of a transaction.
+type TransactionStatus int32
+
+const (
+	TransactionStatus_TRANSACTION_STATUS_UNSPECIFIED TransactionStatus = 0
+	TransactionStatus_TRANSACTION_STATUS_INITIATED   TransactionStatus = 1
+	TransactionStatus_TRANSACTION_STATUS_ACCEPTED    TransactionStatus = 2
+	TransactionStatus_TRANSACTION_STATUS_PENDING     TransactionStatus = 3
+	TransactionStatus_TRANSACTION_STATUS_COMPLETED   TransactionStatus = 4
+	TransactionStatus_TRANSACTION_STATUS_FAILED      TransactionStatus = 5
+	TransactionStatus_TRANSACTION_STATUS_REVERSED    TransactionStatus = 6
+	TransactionStatus_TRANSACTION_STATUS_EXPIRED     TransactionStatus = 7
+)
+
+// Enum value maps for TransactionStatus.
+var (
+	TransactionStatus_name = map[int32]string{
+		0: "TRANSACTION_STATUS_UNSPECIFIED",
+		1: "TRANSACTION_STATUS_INITIATED",
+		2: "TRANSACTION_STATUS_ACCEPTED",
+		3: "TRANSACTION_STATUS_PENDING",
+		4: "TRANSACTION_STATUS_COMPLETED",
+		5: "TRANSACTION_STATUS_FAILED",
+		6: "TRANSACTION_STATUS_REVERSED",
+		7: "TRANSACTION_STATUS_EXPIRED",
+	}
+	TransactionStatus_value = map[string]int32{
+		"TRANSACTION_STATUS_UNSPECIFIED": 0,
+		"TRANSACTION_STATUS_INITIATED":   1,
+		"TRANSACTION_STATUS_ACCEPTED":    2,
+		"TRANSACTION_STATUS_PENDING":     3,
+		"TRANSACTION_STATUS_COMPLETED":   4,
+		"TRANSACTION_STATUS_FAILED":      5,
+		"TRANSACTION_STATUS_REVERSED":    6,
+		"TRANSACTION_STATUS_EXPIRED":     7,
+	}
+)
+
+func (x TransactionStatus) Enum() *TransactionStatus {
+	p := new(TransactionStatus)
+	*p = x
+	return p
+}
+
+func (x TransactionStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TransactionStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_mpesa_v1_mpesa_proto_enumTypes[0].Descriptor()
+}
+
+func (TransactionStatus) Type() protoreflect.EnumType {
+	return &file_mpesa_v1_mpesa_proto_enumTypes[0]
+}
+
+func (x TransactionStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TransactionStatus.Descriptor instead.
+func (TransactionStatus) EnumDescriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{0}
+}
+
+type PaymentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Party is the customer MSISDN, or the receiving shortcode for B2B.
+	Party       string `protobuf:"bytes,1,opt,name=party,proto3" json:"party,omitempty"`
+	Amount      string `protobuf:"bytes,2,opt,name=amount,proto3" json:"amount,omitempty"`
+	Reference   string `protobuf:"bytes,3,opt,name=reference,proto3" json:"reference,omitempty"`
+	Description string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	// ThirdPartyConversationID is generated when empty.
+	ThirdPartyConversationId string `protobuf:"bytes,5,opt,name=third_party_conversation_id,json=thirdPartyConversationId,proto3" json:"third_party_conversation_id,omitempty"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *PaymentRequest) Reset() {
+	*x = PaymentRequest{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaymentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaymentRequest) ProtoMessage() {}
+
+func (x *PaymentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaymentRequest.ProtoReflect.Descriptor instead.
+func (*PaymentRequest) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PaymentRequest) GetParty() string {
+	if x != nil {
+		return x.Party
+	}
+	return ""
+}
+
+func (x *PaymentRequest) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *PaymentRequest) GetReference() string {
+	if x != nil {
+		return x.Reference
+	}
+	return ""
+}
+
+func (x *PaymentRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *PaymentRequest) GetThirdPartyConversationId() string {
+	if x != nil {
+		return x.ThirdPartyConversationId
+	}
+	return ""
+}
+
+type ReversalRequest struct {
+	state                    protoimpl.MessageState `protogen:"open.v1"`
+	TransactionId            string                 `protobuf:"bytes,1,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	Amount                   string                 `protobuf:"bytes,2,opt,name=amount,proto3" json:"amount,omitempty"`
+	ThirdPartyConversationId string                 `protobuf:"bytes,3,opt,name=third_party_conversation_id,json=thirdPartyConversationId,proto3" json:"third_party_conversation_id,omitempty"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *ReversalRequest) Reset() {
+	*x = ReversalRequest{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReversalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReversalRequest) ProtoMessage() {}
+
+func (x *ReversalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReversalRequest.ProtoReflect.Descriptor instead.
+func (*ReversalRequest) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReversalRequest) GetTransactionId() string {
+	if x != nil {
+		return x.TransactionId
+	}
+	return ""
+}
+
+func (x *ReversalRequest) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *ReversalRequest) GetThirdPartyConversationId() string {
+	if x != nil {
+		return x.ThirdPartyConversationId
+	}
+	return ""
+}
+
+type TransactionResult struct {
+	state                    protoimpl.MessageState `protogen:"open.v1"`
+	ResponseCode             string                 `protobuf:"bytes,1,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	ResponseDesc             string                 `protobuf:"bytes,2,opt,name=response_desc,json=responseDesc,proto3" json:"response_desc,omitempty"`
+	TransactionId            string                 `protobuf:"bytes,3,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	ConversationId           string                 `protobuf:"bytes,4,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"`
+	ThirdPartyConversationId string                 `protobuf:"bytes,5,opt,name=third_party_conversation_id,json=thirdPartyConversationId,proto3" json:"third_party_conversation_id,omitempty"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *TransactionResult) Reset() {
+	*x = TransactionResult{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransactionResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransactionResult) ProtoMessage() {}
+
+func (x *TransactionResult) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransactionResult.ProtoReflect.Descriptor instead.
+func (*TransactionResult) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TransactionResult) GetResponseCode() string {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return ""
+}
+
+func (x *TransactionResult) GetResponseDesc() string {
+	if x != nil {
+		return x.ResponseDesc
+	}
+	return ""
+}
+
+func (x *TransactionResult) GetTransactionId() string {
+	if x != nil {
+		return x.TransactionId
+	}
+	return ""
+}
+
+func (x *TransactionResult) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+func (x *TransactionResult) GetThirdPartyConversationId() string {
+	if x != nil {
+		return x.ThirdPartyConversationId
+	}
+	return ""
+}
+
+type StatusRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// QueryReference is a transaction ID, conversation ID or third party
+	// conversation ID.
+	QueryReference string `protobuf:"bytes,1,opt,name=query_reference,json=queryReference,proto3" json:"query_reference,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StatusRequest) GetQueryReference() string {
+	if x != nil {
+		return x.QueryReference
+	}
+	return ""
+}
+
+type StatusResult struct {
+	state                     protoimpl.MessageState `protogen:"open.v1"`
+	ResponseCode              string                 `protobuf:"bytes,1,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	ResponseDesc              string                 `protobuf:"bytes,2,opt,name=response_desc,json=responseDesc,proto3" json:"response_desc,omitempty"`
+	ResponseTransactionStatus string                 `protobuf:"bytes,3,opt,name=response_transaction_status,json=responseTransactionStatus,proto3" json:"response_transaction_status,omitempty"`
+	ConversationId            string                 `protobuf:"bytes,4,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"`
+	ThirdPartyConversationId  string                 `protobuf:"bytes,5,opt,name=third_party_conversation_id,json=thirdPartyConversationId,proto3" json:"third_party_conversation_id,omitempty"`
+	Status                    TransactionStatus      `protobuf:"varint,6,opt,name=status,proto3,enum=mpesa.v1.TransactionStatus" json:"status,omitempty"`
+	unknownFields             protoimpl.UnknownFields
+	sizeCache                 protoimpl.SizeCache
+}
+
+func (x *StatusResult) Reset() {
+	*x = StatusResult{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResult) ProtoMessage() {}
+
+func (x *StatusResult) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResult.ProtoReflect.Descriptor instead.
+func (*StatusResult) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StatusResult) GetResponseCode() string {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return ""
+}
+
+func (x *StatusResult) GetResponseDesc() string {
+	if x != nil {
+		return x.ResponseDesc
+	}
+	return ""
+}
+
+func (x *StatusResult) GetResponseTransactionStatus() string {
+	if x != nil {
+		return x.ResponseTransactionStatus
+	}
+	return ""
+}
+
+func (x *StatusResult) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+func (x *StatusResult) GetThirdPartyConversationId() string {
+	if x != nil {
+		return x.ThirdPartyConversationId
+	}
+	return ""
+}
+
+func (x *StatusResult) GetStatus() TransactionStatus {
+	if x != nil {
+		return x.Status
+	}
+	return TransactionStatus_TRANSACTION_STATUS_UNSPECIFIED
+}
+
+type BeneficiaryRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CustomerMsisdn string                 `protobuf:"bytes,1,opt,name=customer_msisdn,json=customerMsisdn,proto3" json:"customer_msisdn,omitempty"`
+	KycQueryType   string                 `protobuf:"bytes,2,opt,name=kyc_query_type,json=kycQueryType,proto3" json:"kyc_query_type,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *BeneficiaryRequest) Reset() {
+	*x = BeneficiaryRequest{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeneficiaryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeneficiaryRequest) ProtoMessage() {}
+
+func (x *BeneficiaryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeneficiaryRequest.ProtoReflect.Descriptor instead.
+func (*BeneficiaryRequest) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BeneficiaryRequest) GetCustomerMsisdn() string {
+	if x != nil {
+		return x.CustomerMsisdn
+	}
+	return ""
+}
+
+func (x *BeneficiaryRequest) GetKycQueryType() string {
+	if x != nil {
+		return x.KycQueryType
+	}
+	return ""
+}
+
+type BeneficiaryResult struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ResponseCode      string                 `protobuf:"bytes,1,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	ResponseDesc      string                 `protobuf:"bytes,2,opt,name=response_desc,json=responseDesc,proto3" json:"response_desc,omitempty"`
+	CustomerFirstName string                 `protobuf:"bytes,3,opt,name=customer_first_name,json=customerFirstName,proto3" json:"customer_first_name,omitempty"`
+	CustomerLastName  string                 `protobuf:"bytes,4,opt,name=customer_last_name,json=customerLastName,proto3" json:"customer_last_name,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *BeneficiaryResult) Reset() {
+	*x = BeneficiaryResult{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeneficiaryResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeneficiaryResult) ProtoMessage() {}
+
+func (x *BeneficiaryResult) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeneficiaryResult.ProtoReflect.Descriptor instead.
+func (*BeneficiaryResult) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BeneficiaryResult) GetResponseCode() string {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return ""
+}
+
+func (x *BeneficiaryResult) GetResponseDesc() string {
+	if x != nil {
+		return x.ResponseDesc
+	}
+	return ""
+}
+
+func (x *BeneficiaryResult) GetCustomerFirstName() string {
+	if x != nil {
+		return x.CustomerFirstName
+	}
+	return ""
+}
+
+func (x *BeneficiaryResult) GetCustomerLastName() string {
+	if x != nil {
+		return x.CustomerLastName
+	}
+	return ""
+}
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Reference is a transaction ID, conversation ID or third party
+	// conversation ID.
+	Reference     string `protobuf:"bytes,1,opt,name=reference,proto3" json:"reference,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchRequest) GetReference() string {
+	if x != nil {
+		return x.Reference
+	}
+	return ""
+}
+
+type TransactionUpdate struct {
+	state                    protoimpl.MessageState `protogen:"open.v1"`
+	ThirdPartyConversationId string                 `protobuf:"bytes,1,opt,name=third_party_conversation_id,json=thirdPartyConversationId,proto3" json:"third_party_conversation_id,omitempty"`
+	TransactionId            string                 `protobuf:"bytes,2,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	ConversationId           string                 `protobuf:"bytes,3,opt,name=conversation_id,json=conversationId,proto3" json:"conversation_id,omitempty"`
+	Status                   TransactionStatus      `protobuf:"varint,4,opt,name=status,proto3,enum=mpesa.v1.TransactionStatus" json:"status,omitempty"`
+	// ResponseCode is the M-Pesa code of the response, callback or status
+	// query that caused the update.
+	ResponseCode  string                 `protobuf:"bytes,5,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransactionUpdate) Reset() {
+	*x = TransactionUpdate{}
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransactionUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransactionUpdate) ProtoMessage() {}
+
+func (x *TransactionUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_mpesa_v1_mpesa_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransactionUpdate.ProtoReflect.Descriptor instead.
+func (*TransactionUpdate) Descriptor() ([]byte, []int) {
+	return file_mpesa_v1_mpesa_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TransactionUpdate) GetThirdPartyConversationId() string {
+	if x != nil {
+		return x.ThirdPartyConversationId
+	}
+	return ""
+}
+
+func (x *TransactionUpdate) GetTransactionId() string {
+	if x != nil {
+		return x.TransactionId
+	}
+	return ""
+}
+
+func (x *TransactionUpdate) GetConversationId() string {
+	if x != nil {
+		return x.ConversationId
+	}
+	return ""
+}
+
+func (x *TransactionUpdate) GetStatus() TransactionStatus {
+	if x != nil {
+		return x.Status
+	}
+	return TransactionStatus_TRANSACTION_STATUS_UNSPECIFIED
+}
+
+func (x *TransactionUpdate) GetResponseCode() string {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return ""
+}
+
+func (x *TransactionUpdate) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_mpesa_v1_mpesa_proto protoreflect.FileDescriptor
+
+const file_mpesa_v1_mpesa_proto_rawDesc = "" +
+	"\n" +
+	"\x14mpesa/v1/mpesa.proto\x12\bmpesa.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xbd\x01\n" +
+	"\x0ePaymentRequest\x12\x14\n" +
+	"\x05party\x18\x01 \x01(\tR\x05party\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\tR\x06amount\x12\x1c\n" +
+	"\treference\x18\x03 \x01(\tR\treference\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12=\n" +
+	"\x1bthird_party_conversation_id\x18\x05 \x01(\tR\x18thirdPartyConversationId\"\x8f\x01\n" +
+	"\x0fReversalRequest\x12%\n" +
+	"\x0etransaction_id\x18\x01 \x01(\tR\rtransactionId\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\tR\x06amount\x12=\n" +
+	"\x1bthird_party_conversation_id\x18\x03 \x01(\tR\x18thirdPartyConversationId\"\xec\x01\n" +
+	"\x11TransactionResult\x12#\n" +
+	"\rresponse_code\x18\x01 \x01(\tR\fresponseCode\x12#\n" +
+	"\rresponse_desc\x18\x02 \x01(\tR\fresponseDesc\x12%\n" +
+	"\x0etransaction_id\x18\x03 \x01(\tR\rtransactionId\x12'\n" +
+	"\x0fconversation_id\x18\x04 \x01(\tR\x0econversationId\x12=\n" +
+	"\x1bthird_party_conversation_id\x18\x05 \x01(\tR\x18thirdPartyConversationId\"8\n" +
+	"\rStatusRequest\x12'\n" +
+	"\x0fquery_reference\x18\x01 \x01(\tR\x0equeryReference\"\xb5\x02\n" +
+	"\fStatusResult\x12#\n" +
+	"\rresponse_code\x18\x01 \x01(\tR\fresponseCode\x12#\n" +
+	"\rresponse_desc\x18\x02 \x01(\tR\fresponseDesc\x12>\n" +
+	"\x1bresponse_transaction_status\x18\x03 \x01(\tR\x19responseTransactionStatus\x12'\n" +
+	"\x0fconversation_id\x18\x04 \x01(\tR\x0econversationId\x12=\n" +
+	"\x1bthird_party_conversation_id\x18\x05 \x01(\tR\x18thirdPartyConversationId\x123\n" +
+	"\x06status\x18\x06 \x01(\x0e2\x1b.mpesa.v1.TransactionStatusR\x06status\"c\n" +
+	"\x12BeneficiaryRequest\x12'\n" +
+	"\x0fcustomer_msisdn\x18\x01 \x01(\tR\x0ecustomerMsisdn\x12$\n" +
+	"\x0ekyc_query_type\x18\x02 \x01(\tR\fkycQueryType\"\xbb\x01\n" +
+	"\x11BeneficiaryResult\x12#\n" +
+	"\rresponse_code\x18\x01 \x01(\tR\fresponseCode\x12#\n" +
+	"\rresponse_desc\x18\x02 \x01(\tR\fresponseDesc\x12.\n" +
+	"\x13customer_first_name\x18\x03 \x01(\tR\x11customerFirstName\x12,\n" +
+	"\x12customer_last_name\x18\x04 \x01(\tR\x10customerLastName\",\n" +
+	"\fWatchRequest\x12\x1c\n" +
+	"\treference\x18\x01 \x01(\tR\treference\"\xac\x02\n" +
+	"\x11TransactionUpdate\x12=\n" +
+	"\x1bthird_party_conversation_id\x18\x01 \x01(\tR\x18thirdPartyConversationId\x12%\n" +
+	"\x0etransaction_id\x18\x02 \x01(\tR\rtransactionId\x12'\n" +
+	"\x0fconversation_id\x18\x03 \x01(\tR\x0econversationId\x123\n" +
+	"\x06status\x18\x04 \x01(\x0e2\x1b.mpesa.v1.TransactionStatusR\x06status\x12#\n" +
+	"\rresponse_code\x18\x05 \x01(\tR\fresponseCode\x12.\n" +
+	"\x04time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x04time*\x9c\x02\n" +
+	"\x11TransactionStatus\x12\"\n" +
+	"\x1eTRANSACTION_STATUS_UNSPECIFIED\x10\x00\x12 \n" +
+	"\x1cTRANSACTION_STATUS_INITIATED\x10\x01\x12\x1f\n" +
+	"\x1bTRANSACTION_STATUS_ACCEPTED\x10\x02\x12\x1e\n" +
+	"\x1aTRANSACTION_STATUS_PENDING\x10\x03\x12 \n" +
+	"\x1cTRANSACTION_STATUS_COMPLETED\x10\x04\x12\x1d\n" +
+	"\x19TRANSACTION_STATUS_FAILED\x10\x05\x12\x1f\n" +
+	"\x1bTRANSACTION_STATUS_REVERSED\x10\x06\x12\x1e\n" +
+	"\x1aTRANSACTION_STATUS_EXPIRED\x10\a2\xf6\x03\n" +
+	"\x0ePaymentService\x12<\n" +
+	"\x03C2B\x12\x18.mpesa.v1.PaymentRequest\x1a\x1b.mpesa.v1.TransactionResult\x12<\n" +
+	"\x03B2C\x12\x18.mpesa.v1.PaymentRequest\x1a\x1b.mpesa.v1.TransactionResult\x12<\n" +
+	"\x03B2B\x12\x18.mpesa.v1.PaymentRequest\x1a\x1b.mpesa.v1.TransactionResult\x12A\n" +
+	"\aReverse\x12\x19.mpesa.v1.ReversalRequest\x1a\x1b.mpesa.v1.TransactionResult\x12I\n" +
+	"\x16QueryTransactionStatus\x12\x17.mpesa.v1.StatusRequest\x1a\x16.mpesa.v1.StatusResult\x12Q\n" +
+	"\x14QueryBeneficiaryName\x12\x1c.mpesa.v1.BeneficiaryRequest\x1a\x1b.mpesa.v1.BeneficiaryResult\x12I\n" +
+	"\x10WatchTransaction\x12\x16.mpesa.v1.WatchRequest\x1a\x1b.mpesa.v1.TransactionUpdate0\x01B3Z1github.com/mobilemoney/mpesa/grpc/mpesapb;mpesapbb\x06proto3"
+
+var (
+	file_mpesa_v1_mpesa_proto_rawDescOnce sync.Once
+	file_mpesa_v1_mpesa_proto_rawDescData []byte
+)
+
+func file_mpesa_v1_mpesa_proto_rawDescGZIP() []byte {
+	file_mpesa_v1_mpesa_proto_rawDescOnce.Do(func() {
+		file_mpesa_v1_mpesa_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_mpesa_v1_mpesa_proto_rawDesc), len(file_mpesa_v1_mpesa_proto_rawDesc)))
+	})
+	return file_mpesa_v1_mpesa_proto_rawDescData
+}
+
+var file_mpesa_v1_mpesa_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_mpesa_v1_mpesa_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_mpesa_v1_mpesa_proto_goTypes = []any{
+	(TransactionStatus)(0),        // 0: mpesa.v1.TransactionStatus
+	(*PaymentRequest)(nil),        // 1: mpesa.v1.PaymentRequest
+	(*ReversalRequest)(nil),       // 2: mpesa.v1.ReversalRequest
+	(*TransactionResult)(nil),     // 3: mpesa.v1.TransactionResult
+	(*StatusRequest)(nil),         // 4: mpesa.v1.StatusRequest
+	(*StatusResult)(nil),          // 5: mpesa.v1.StatusResult
+	(*BeneficiaryRequest)(nil),    // 6: mpesa.v1.BeneficiaryRequest
+	(*BeneficiaryResult)(nil),     // 7: mpesa.v1.BeneficiaryResult
+	(*WatchRequest)(nil),          // 8: mpesa.v1.WatchRequest
+	(*TransactionUpdate)(nil),     // 9: mpesa.v1.TransactionUpdate
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+}
+var file_mpesa_v1_mpesa_proto_depIdxs = []int32{
+	0,  // 0: mpesa.v1.StatusResult.status:type_name -> mpesa.v1.TransactionStatus
+	0,  // 1: mpesa.v1.TransactionUpdate.status:type_name -> mpesa.v1.TransactionStatus
+	10, // 2: mpesa.v1.TransactionUpdate.time:type_name -> google.protobuf.Timestamp
+	1,  // 3: mpesa.v1.PaymentService.C2B:input_type -> mpesa.v1.PaymentRequest
+	1,  // 4: mpesa.v1.PaymentService.B2C:input_type -> mpesa.v1.PaymentRequest
+	1,  // 5: mpesa.v1.PaymentService.B2B:input_type -> mpesa.v1.PaymentRequest
+	2,  // 6: mpesa.v1.PaymentService.Reverse:input_type -> mpesa.v1.ReversalRequest
+	4,  // 7: mpesa.v1.PaymentService.QueryTransactionStatus:input_type -> mpesa.v1.StatusRequest
+	6,  // 8: mpesa.v1.PaymentService.QueryBeneficiaryName:input_type -> mpesa.v1.BeneficiaryRequest
+	8,  // 9: mpesa.v1.PaymentService.WatchTransaction:input_type -> mpesa.v1.WatchRequest
+	3,  // 10: mpesa.v1.PaymentService.C2B:output_type -> mpesa.v1.TransactionResult
+	3,  // 11: mpesa.v1.PaymentService.B2C:output_type -> mpesa.v1.TransactionResult
+	3,  // 12: mpesa.v1.PaymentService.B2B:output_type -> mpesa.v1.TransactionResult
+	3,  // 13: mpesa.v1.PaymentService.Reverse:output_type -> mpesa.v1.TransactionResult
+	5,  // 14: mpesa.v1.PaymentService.QueryTransactionStatus:output_type -> mpesa.v1.StatusResult
+	7,  // 15: mpesa.v1.PaymentService.QueryBeneficiaryName:output_type -> mpesa.v1.BeneficiaryResult
+	9,  // 16: mpesa.v1.PaymentService.WatchTransaction:output_type -> mpesa.v1.TransactionUpdate
+	10, // [10:17] is the sub-list for method output_type
+	3,  // [3:10] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_mpesa_v1_mpesa_proto_init() }
+func file_mpesa_v1_mpesa_proto_init() {
+	if File_mpesa_v1_mpesa_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mpesa_v1_mpesa_proto_rawDesc), len(file_mpesa_v1_mpesa_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_mpesa_v1_mpesa_proto_goTypes,
+		DependencyIndexes: file_mpesa_v1_mpesa_proto_depIdxs,
+		EnumInfos:         file_mpesa_v1_mpesa_proto_enumTypes,
+		MessageInfos:      file_mpesa_v1_mpesa_proto_msgTypes,
+	}.Build()
+	File_mpesa_v1_mpesa_proto = out.File
+	file_mpesa_v1_mpesa_proto_goTypes = nil
+	file_mpesa_v1_mpesa_proto_depIdxs = nil
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 Infolabs Inc & Associates
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: mpesa/v1/mpesa.proto
+
+package mpesapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PaymentService_C2B_FullMethodName                    = "/mpesa.v1.PaymentService/C2B"
+	PaymentService_B2C_FullMethodName                    = "/mpesa.v1.PaymentService/B2C"
+	PaymentService_B2B_FullMethodName                    = "/mpesa.v1.PaymentService/B2B"
+	PaymentService_Reverse_FullMethodName                = "/mpesa.v1.PaymentService/Reverse"
+	PaymentService_QueryTransactionStatus_FullMethodName = "/mpesa.v1.PaymentService/QueryTransactionStatus"
+	PaymentService_QueryBeneficiaryName_FullMethodName   = "/mpesa.v1.PaymentService/QueryBeneficiaryName"
+	PaymentService_WatchTransaction_FullMethodName       = "/mpesa.v1.PaymentService/WatchTransaction"
+)
+
+// PaymentServiceClient is the client API for PaymentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PaymentService exposes the operations of an M-Pesa application.
+//
+// Operations rejected by M-Pesa fail with INVALID_ARGUMENT for invalid
+// requests, UNAUTHENTICATED for authentication failures, RESOURCE_EXHAUSTED
+// when rate limited and FAILED_PRECONDITION otherwise, with the M-Pesa
+// response code in the message. Payments reusing the conversation ID of a
+// recorded transaction fail with ALREADY_EXISTS. UNAVAILABLE is returned
+// while the circuit of M-Pesa is open and DEADLINE_EXCEEDED on timeouts. Other failures, M-Pesa
+// server errors or no response at all, are UNAVAILABLE for queries but
+// UNKNOWN for payments and reversals, which may have been made and must not
+// be retried blindly.
+type PaymentServiceClient interface {
+	// C2B collects a customer payment.
+	C2B(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error)
+	// B2C pays a customer.
+	B2C(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error)
+	// B2B pays another business.
+	B2B(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error)
+	// Reverse reverses a transaction.
+	Reverse(ctx context.Context, in *ReversalRequest, opts ...grpc.CallOption) (*TransactionResult, error)
+	// QueryTransactionStatus queries the status of a transaction.
+	QueryTransactionStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResult, error)
+	// QueryBeneficiaryName looks up the registered name of a customer.
+	QueryBeneficiaryName(ctx context.Context, in *BeneficiaryRequest, opts ...grpc.CallOption) (*BeneficiaryResult, error)
+	// WatchTransaction streams the status of a transaction, starting with its
+	// current status when known, as responses, callbacks and status queries
+	// update it. The stream ends once the transaction is completed, failed or
+	// reversed.
+	WatchTransaction(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TransactionUpdate], error)
+}
+
+type paymentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPaymentServiceClient(cc grpc.ClientConnInterface) PaymentServiceClient {
+	return &paymentServiceClient{cc}
+}
+
+func (c *paymentServiceClient) C2B(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResult)
+	err := c.cc.Invoke(ctx, PaymentService_C2B_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) B2C(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResult)
+	err := c.cc.Invoke(ctx, PaymentService_B2C_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) B2B(ctx context.Context, in *PaymentRequest, opts ...grpc.CallOption) (*TransactionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResult)
+	err := c.cc.Invoke(ctx, PaymentService_B2B_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) Reverse(ctx context.Context, in *ReversalRequest, opts ...grpc.CallOption) (*TransactionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResult)
+	err := c.cc.Invoke(ctx, PaymentService_Reverse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) QueryTransactionStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusResult)
+	err := c.cc.Invoke(ctx, PaymentService_QueryTransactionStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) QueryBeneficiaryName(ctx context.Context, in *BeneficiaryRequest, opts ...grpc.CallOption) (*BeneficiaryResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BeneficiaryResult)
+	err := c.cc.Invoke(ctx, PaymentService_QueryBeneficiaryName_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentServiceClient) WatchTransaction(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TransactionUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PaymentService_ServiceDesc.Streams[0], PaymentService_WatchTransaction_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, TransactionUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PaymentService_WatchTransactionClient = grpc.ServerStreamingClient[TransactionUpdate]
+
+// PaymentServiceServer is the server API for PaymentService service.
+// All implementations must embed UnimplementedPaymentServiceServer
+// for forward compatibility.
+//
+// PaymentService exposes the operations of an M-Pesa application.
+//
+// Operations rejected by M-Pesa fail with INVALID_ARGUMENT for invalid
+// requests, UNAUTHENTICATED for authentication failures, RESOURCE_EXHAUSTED
+// when rate limited and FAILED_PRECONDITION otherwise, with the M-Pesa
+// response code in the message. Payments reusing the conversation ID of a
+// recorded transaction fail with ALREADY_EXISTS. UNAVAILABLE is returned
+// while the circuit of M-Pesa is open and DEADLINE_EXCEEDED on timeouts. Other failures, M-Pesa
+// server errors or no response at all, are UNAVAILABLE for queries but
+// UNKNOWN for payments and reversals, which may have been made and must not
+// be retried blindly.
+type PaymentServiceServer interface {
+	// C2B collects a customer payment.
+	C2B(context.Context, *PaymentRequest) (*TransactionResult, error)
+	// B2C pays a customer.
+	B2C(context.Context, *PaymentRequest) (*TransactionResult, error)
+	// B2B pays another business.
+	B2B(context.Context, *PaymentRequest) (*TransactionResult, error)
+	// Reverse reverses a transaction.
+	Reverse(context.Context, *ReversalRequest) (*TransactionResult, error)
+	// QueryTransactionStatus queries the status of a transaction.
+	QueryTransactionStatus(context.Context, *StatusRequest) (*StatusResult, error)
+	// QueryBeneficiaryName looks up the registered name of a customer.
+	QueryBeneficiaryName(context.Context, *BeneficiaryRequest) (*BeneficiaryResult, error)
+	// WatchTransaction streams the status of a transaction, starting with its
+	// current status when known, as responses, callbacks and status queries
+	// update it. The stream ends once the transaction is completed, failed or
+	// reversed.
+	WatchTransaction(*WatchRequest, grpc.ServerStreamingServer[TransactionUpdate]) error
+	mustEmbedUnimplementedPaymentServiceServer()
+}
+
+// UnimplementedPaymentServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPaymentServiceServer struct{}
+
+func (UnimplementedPaymentServiceServer) C2B(context.Context, *PaymentRequest) (*TransactionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method C2B not implemented")
+}
+func (UnimplementedPaymentServiceServer) B2C(context.Context, *PaymentRequest) (*TransactionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method B2C not implemented")
+}
+func (UnimplementedPaymentServiceServer) B2B(context.Context, *PaymentRequest) (*TransactionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method B2B not implemented")
+}
+func (UnimplementedPaymentServiceServer) Reverse(context.Context, *ReversalRequest) (*TransactionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reverse not implemented")
+}
+func (UnimplementedPaymentServiceServer) QueryTransactionStatus(context.Context, *StatusRequest) (*StatusResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryTransactionStatus not implemented")
+}
+func (UnimplementedPaymentServiceServer) QueryBeneficiaryName(context.Context, *BeneficiaryRequest) (*BeneficiaryResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryBeneficiaryName not implemented")
+}
+func (UnimplementedPaymentServiceServer) WatchTransaction(*WatchRequest, grpc.ServerStreamingServer[TransactionUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTransaction not implemented")
+}
+func (UnimplementedPaymentServiceServer) mustEmbedUnimplementedPaymentServiceServer() {}
+func (UnimplementedPaymentServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafePaymentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PaymentServiceServer will
+// result in compilation errors.
+type UnsafePaymentServiceServer interface {
+	mustEmbedUnimplementedPaymentServiceServer()
+}
+
+func RegisterPaymentServiceServer(s grpc.ServiceRegistrar, srv PaymentServiceServer) {
+	// If the following call pancis, it indicates UnimplementedPaymentServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PaymentService_ServiceDesc, srv)
+}
+
+func _PaymentService_C2B_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).C2B(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_C2B_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).C2B(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_B2C_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).B2C(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_B2C_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).B2C(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_B2B_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).B2B(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_B2B_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).B2B(ctx, req.(*PaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_Reverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReversalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).Reverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_Reverse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).Reverse(ctx, req.(*ReversalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_QueryTransactionStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).QueryTransactionStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_QueryTransactionStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).QueryTransactionStatus(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_QueryBeneficiaryName_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeneficiaryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).QueryBeneficiaryName(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_QueryBeneficiaryName_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).QueryBeneficiaryName(ctx, req.(*BeneficiaryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PaymentService_WatchTransaction_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PaymentServiceServer).WatchTransaction(m, &grpc.GenericServerStream[WatchRequest, TransactionUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PaymentService_WatchTransactionServer = grpc.ServerStreamingServer[TransactionUpdate]
+
+// PaymentService_ServiceDesc is the grpc.ServiceDesc for PaymentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PaymentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mpesa.v1.PaymentService",
+	HandlerType: (*PaymentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "C2B",
+			Handler:    _PaymentService_C2B_Handler,
+		},
+		{
+			MethodName: "B2C",
+			Handler:    _PaymentService_B2C_Handler,
+		},
+		{
+			MethodName: "B2B",
+			Handler:    _PaymentService_B2B_Handler,
+		},
+		{
+			MethodName: "Reverse",
+			Handler:    _PaymentService_Reverse_Handler,
+		},
+		{
+			MethodName: "QueryTransactionStatus",
+			Handler:    _PaymentService_QueryTransactionStatus_Handler,
+		},
+		{
+			MethodName: "QueryBeneficiaryName",
+			Handler:    _PaymentService_QueryBeneficiaryName_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTransaction",
+			Handler:       _PaymentService_WatchTransaction_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mpesa/v1/mpesa.proto",
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package server implements the mpesa.v1.PaymentService gRPC service on top
+// of an mpesa.Application.
+//
+//	l := ledger.New(ledger.NewMemory())
+//	app, err := mpesa.NewApplication(key, market, env, mpesa.WithJournal(l))
+//	allow, err := callback.NewAllowlist(trustedSources, nil)
+//	http.Handle("/mpesa/callback", &callback.Handler{
+//		Verifiers: []callback.Verifier{allow},
+//		Dispatch:  l.Callback,
+//		Events:    app.Events(),
+//	})
+//
+//	s := grpc.NewServer()
+//	mpesapb.RegisterPaymentServiceServer(s, &server.Server{App: app, Ledger: l})
+//
+// WatchTransaction follows transactions in the ledger, it is woken up by the
+// events of the application's event bus, so callbacks must be handled with
+// the same bus for their updates to be streamed as they arrive.
+//
+// The package belongs to the github.com/mobilemoney/mpesa/grpc module, which
+// requires Go 1.23 and is built and tested apart from the SDK module.
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/grpc/mpesapb"
+	"github.com/mobilemoney/mpesa/ledger"
+	perrors "github.com/mobilemoney/mpesa/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defPollInterval = 5 * time.Second
+
+var _ mpesapb.PaymentServiceServer = (*Server)(nil)
+
+// Server implements mpesapb.PaymentServiceServer.
+type Server struct {
+	mpesapb.UnimplementedPaymentServiceServer
+
+	App *mpesa.Application
+
+	// Ledger, when set, is where WatchTransaction follows transactions. It
+	// must be the journal of App.
+	Ledger *ledger.Ledger
+
+	// PollInterval is how often watched transactions are re-read from the
+	// ledger when no event arrives, to pick up updates that are not
+	// announced by an event, e.g. by the sweep package. 5s by default.
+	PollInterval time.Duration
+}
+
+// C2B implements mpesapb.PaymentServiceServer.
+func (s *Server) C2B(ctx context.Context, in *mpesapb.PaymentRequest) (*mpesapb.TransactionResult, error) {
+	resp, err := s.App.C2B(ctx, mpesa.C2BPayment{
+		Amount:                   in.GetAmount(),
+		CustomerMSISDN:           in.GetParty(),
+		ThirdPartyConversationID: in.GetThirdPartyConversationId(),
+		TransactionReference:     in.GetReference(),
+		PurchasedItemsDesc:       in.GetDescription(),
+	})
+	if err != nil {
+		return nil, statusOf(err, true)
+	}
+	return transactionResult(resp), nil
+}
+
+// B2C implements mpesapb.PaymentServiceServer.
+func (s *Server) B2C(ctx context.Context, in *mpesapb.PaymentRequest) (*mpesapb.TransactionResult, error) {
+	resp, err := s.App.B2C(ctx, mpesa.B2CPayment{
+		Amount:                   in.GetAmount(),
+		CustomerMSISDN:           in.GetParty(),
+		ThirdPartyConversationID: in.GetThirdPartyConversationId(),
+		TransactionReference:     in.GetReference(),
+		PaymentItemsDesc:         in.GetDescription(),
+	})
+	if err != nil {
+		return nil, statusOf(err, true)
+	}
+	return transactionResult(resp), nil
+}
+
+// B2B implements mpesapb.PaymentServiceServer.
+func (s *Server) B2B(ctx context.Context, in *mpesapb.PaymentRequest) (*mpesapb.TransactionResult, error) {
+	resp, err := s.App.B2B(ctx, mpesa.B2BPayment{
+		Amount:                   in.GetAmount(),
+		ReceiverPartyCode:        in.GetParty(),
+		ThirdPartyConversationID: in.GetThirdPartyConversationId(),
+		TransactionReference:     in.GetReference(),
+		PurchasedItemsDesc:       in.GetDescription(),
+	})
+	if err != nil {
+		return nil, statusOf(err, true)
+	}
+	return transactionResult(resp), nil
+}
+
+// Reverse implements mpesapb.PaymentServiceServer.
+func (s *Server) Reverse(ctx context.Context, in *mpesapb.ReversalRequest) (*mpesapb.TransactionResult, error) {
+	resp, err := s.App.Reverse(ctx, mpesa.Reversal{
+		ReversalAmount:           in.GetAmount(),
+		ThirdPartyConversationID: in.GetThirdPartyConversationId(),
+		TransactionID:            in.GetTransactionId(),
+	})
+	if err != nil {
+		return nil, statusOf(err, true)
+	}
+	return &mpesapb.TransactionResult{
+		ResponseCode:             resp.Code,
+		ResponseDesc:             resp.Description,
+		TransactionId:            resp.TransactionID,
+		ConversationId:           resp.ConversationID,
+		ThirdPartyConversationId: resp.ThirdPartyConversationID,
+	}, nil
+}
+
+// QueryTransactionStatus implements mpesapb.PaymentServiceServer.
+func (s *Server) QueryTransactionStatus(ctx context.Context, in *mpesapb.StatusRequest) (*mpesapb.StatusResult, error) {
+	resp, err := s.App.QueryTransactionStatus(ctx, mpesa.StatusQuery{QueryReference: in.GetQueryReference()})
+	if err != nil {
+		return nil, statusOf(err, false)
+	}
+	return &mpesapb.StatusResult{
+		ResponseCode:              resp.Code,
+		ResponseDesc:              resp.Description,
+		ResponseTransactionStatus: resp.ResponseTransactionStatus,
+		ConversationId:            resp.ConversationID,
+		ThirdPartyConversationId:  resp.ThirdPartyConversationID,
+		Status:                    transactionStatus(ledger.StatusFromQuery(resp.ResponseTransactionStatus)),
+	}, nil
+}
+
+// QueryBeneficiaryName implements mpesapb.PaymentServiceServer.
+func (s *Server) QueryBeneficiaryName(ctx context.Context, in *mpesapb.BeneficiaryRequest) (*mpesapb.BeneficiaryResult, error) {
+	resp, err := s.App.QueryBeneficiaryName(ctx, mpesa.BeneficiaryQuery{
+		CustomerMSISDN: in.GetCustomerMsisdn(),
+		KycQueryType:   in.GetKycQueryType(),
+	})
+	if err != nil {
+		return nil, statusOf(err, false)
+	}
+	return &mpesapb.BeneficiaryResult{
+		ResponseCode:      resp.Code,
+		ResponseDesc:      resp.Description,
+		CustomerFirstName: resp.CustomerFirstName,
+		CustomerLastName:  resp.CustomerLastName,
+	}, nil
+}
+
+// WatchTransaction implements mpesapb.PaymentServiceServer. It sends the
+// current status of the transaction and then every accepted update, until
+// the transaction reaches a final status or the client goes away. The
+// transaction does not have to be in the ledger yet, so clients may start
+// watching a third party conversation ID before making the payment.
+func (s *Server) WatchTransaction(in *mpesapb.WatchRequest, stream grpc.ServerStreamingServer[mpesapb.TransactionUpdate]) error {
+	if s.Ledger == nil {
+		return status.Error(codes.FailedPrecondition, "watching transactions requires a ledger")
+	}
+	if in.GetReference() == "" {
+		return status.Error(codes.InvalidArgument, "reference is required")
+	}
+
+	ctx := stream.Context()
+
+	// every event wakes the watcher up, which then reads the ledger; a
+	// pending wake-up covers any number of events
+	wake := make(chan struct{}, 1)
+	unsubscribe := s.App.Events().Subscribe(mpesa.AllEvents, func(ctx context.Context, e mpesa.Event) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = defPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// sent is the number of updates of the transaction already streamed,
+	// -1 until the transaction is found
+	sent := -1
+
+	for {
+		tx, err := s.Ledger.Lookup(ctx, in.GetReference())
+		switch {
+		case err == nil:
+			if sent, err = send(stream, tx, sent); err != nil {
+				return err
+			}
+			if tx.Status.Final() {
+				return nil
+			}
+
+		case !perrors.Contains(err, ledger.ErrNotFound):
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// send streams the updates of tx after the first sent ones, or its current
+// status when it was not sent yet, and returns the new count of sent updates.
+func send(stream grpc.ServerStreamingServer[mpesapb.TransactionUpdate], tx *ledger.Transaction, sent int) (int, error) {
+	if sent < 0 {
+		code := tx.Code
+		if n := len(tx.Updates); n > 0 {
+			code = tx.Updates[n-1].Code
+		}
+		return len(tx.Updates), stream.Send(update(tx, tx.Status, code, tx.UpdatedAt))
+	}
+
+	for ; sent < len(tx.Updates); sent++ {
+		u := tx.Updates[sent]
+		if u.Rejected || u.Status == "" {
+			continue
+		}
+		if err := stream.Send(update(tx, u.Status, u.Code, u.Time)); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func update(tx *ledger.Transaction, st ledger.Status, code string, t time.Time) *mpesapb.TransactionUpdate {
+	return &mpesapb.TransactionUpdate{
+		ThirdPartyConversationId: tx.ID,
+		TransactionId:            tx.TransactionID,
+		ConversationId:           tx.ConversationID,
+		Status:                   transactionStatus(st),
+		ResponseCode:             code,
+		Time:                     timestamppb.New(t),
+	}
+}
+
+func transactionResult(resp *mpesa.TransactionResp) *mpesapb.TransactionResult {
+	return &mpesapb.TransactionResult{
+		ResponseCode:             resp.Code,
+		ResponseDesc:             resp.Description,
+		TransactionId:            resp.TransactionID,
+		ConversationId:           resp.ConversationID,
+		ThirdPartyConversationId: resp.ThirdPartyConversationID,
+	}
+}
+
+var statuses = map[ledger.Status]mpesapb.TransactionStatus{
+	ledger.Initiated: mpesapb.TransactionStatus_TRANSACTION_STATUS_INITIATED,
+	ledger.Accepted:  mpesapb.TransactionStatus_TRANSACTION_STATUS_ACCEPTED,
+	ledger.Pending:   mpesapb.TransactionStatus_TRANSACTION_STATUS_PENDING,
+	ledger.Completed: mpesapb.TransactionStatus_TRANSACTION_STATUS_COMPLETED,
+	ledger.Failed:    mpesapb.TransactionStatus_TRANSACTION_STATUS_FAILED,
+	ledger.Reversed:  mpesapb.TransactionStatus_TRANSACTION_STATUS_REVERSED,
+	ledger.Expired:   mpesapb.TransactionStatus_TRANSACTION_STATUS_EXPIRED,
+}
+
+func transactionStatus(s ledger.Status) mpesapb.TransactionStatus {
+	return statuses[s]
+}
+
+// statusOf maps an operation error to a gRPC status: requests rejected by
+// M-Pesa are INVALID_ARGUMENT, UNAUTHENTICATED, RESOURCE_EXHAUSTED or
+// FAILED_PRECONDITION with the response code in the message, payments
+// reusing the conversation ID of a ledger transaction ALREADY_EXISTS, open
+// circuits UNAVAILABLE and timeouts DEADLINE_EXCEEDED. Other failures, M-Pesa
+// server errors or no response at all, are UNAVAILABLE for queries but
+// UNKNOWN for payments and reversals, which may have been made and must not
+// be retried blindly.
+func statusOf(err error, payment bool) error {
+	var respErr *mpesa.ResponseError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+
+	case errors.Is(err, mpesa.ErrCircuitOpen):
+		return status.Error(codes.Unavailable, err.Error())
+
+	case errors.Is(err, mpesa.ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+
+	case perrors.Contains(err, ledger.ErrDuplicate):
+		return status.Error(codes.AlreadyExists, err.Error())
+
+	case errors.As(err, &respErr):
+		switch {
+		case respErr.StatusCode == http.StatusBadRequest, respErr.StatusCode == http.StatusUnprocessableEntity:
+			return status.Error(codes.InvalidArgument, err.Error())
+		case respErr.StatusCode == http.StatusUnauthorized, respErr.StatusCode == http.StatusForbidden:
+			return status.Error(codes.Unauthenticated, err.Error())
+		case respErr.StatusCode == http.StatusTooManyRequests:
+			return status.Error(codes.ResourceExhausted, err.Error())
+		case respErr.StatusCode < 500:
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+
+	if payment {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/grpc/mpesapb"
+	"github.com/mobilemoney/mpesa/grpc/server"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/ledger"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newClient(t *testing.T, api *mpesatest.API) (mpesapb.PaymentServiceClient, *mpesa.Application, *ledger.Ledger) {
+	l := ledger.New(ledger.NewMemory())
+	l.Async = true
+
+	app := mpesatest.NewApplication(t, api, mpesa.WithJournal(l))
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	mpesapb.RegisterPaymentServiceServer(s, &server.Server{App: app, Ledger: l, PollInterval: time.Minute})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return mpesapb.NewPaymentServiceClient(conn), app, l
+}
+
+func TestPaymentErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		status int
+		body   string
+		code   codes.Code
+	}{
+		{
+			desc:   "accepted",
+			status: http.StatusCreated,
+			body:   `{"output_ResponseCode":"INS-0","output_TransactionID":"tx-1"}`,
+			code:   codes.OK,
+		},
+		{
+			desc:   "invalid request",
+			status: http.StatusBadRequest,
+			body:   `{"output_ResponseCode":"INS-13","output_ResponseDesc":"Invalid Shortcode Used"}`,
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "unauthorized",
+			status: http.StatusUnauthorized,
+			body:   `{"output_ResponseCode":"INS-2","output_ResponseDesc":"Invalid API Key"}`,
+			code:   codes.Unauthenticated,
+		},
+		{
+			desc:   "rejected",
+			status: http.StatusConflict,
+			body:   `{"output_ResponseCode":"INS-10","output_ResponseDesc":"Duplicate Transaction"}`,
+			code:   codes.FailedPrecondition,
+		},
+		{
+			desc:   "rate limited",
+			status: http.StatusTooManyRequests,
+			code:   codes.ResourceExhausted,
+		},
+		{
+			desc:   "server error",
+			status: http.StatusServiceUnavailable,
+			body:   `{"output_ResponseCode":"INS-1","output_ResponseDesc":"Internal Error"}`,
+			code:   codes.Unknown,
+		},
+		{
+			desc: "no response",
+			code: codes.Unknown,
+		},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI()
+		if tc.status != 0 {
+			api.On(mpesa.OpC2B, mpesatest.Reply{Status: tc.status, Body: tc.body})
+		}
+		client, _, _ := newClient(t, api)
+
+		res, err := client.C2B(context.Background(), &mpesapb.PaymentRequest{Party: "255744553111", Amount: "10", Reference: "ref"})
+		assert.Equal(t, tc.code, status.Code(err), fmt.Sprintf("%s: expected code %s got %v\n", tc.desc, tc.code, err))
+		if tc.code == codes.OK {
+			assert.Equal(t, "tx-1", res.GetTransactionId(), fmt.Sprintf("%s: expected transaction id\n", tc.desc))
+		}
+	}
+}
+
+func TestReusedConversationID(t *testing.T) {
+	api := mpesatest.NewAPI().On(mpesa.OpC2B, mpesatest.Reply{Status: http.StatusCreated, Body: `{"output_ResponseCode":"INS-0","output_TransactionID":"tx-1"}`})
+	client, _, _ := newClient(t, api)
+
+	in := &mpesapb.PaymentRequest{Party: "255744553111", Amount: "10", Reference: "ref", ThirdPartyConversationId: "tpc-1"}
+	_, err := client.C2B(context.Background(), in)
+	assert.Nil(t, err, fmt.Sprintf("first payment: expected no error got %v\n", err))
+
+	_, err = client.C2B(context.Background(), in)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), fmt.Sprintf("reused conversation id: expected code %s got %v\n", codes.AlreadyExists, err))
+	assert.Equal(t, 1, api.Requests(mpesa.OpC2B), "reused conversation id: expected the payment not to be sent\n")
+}
+
+func TestQueryErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		status int
+		code   codes.Code
+	}{
+		{desc: "server error", status: http.StatusServiceUnavailable, code: codes.Unavailable},
+		{desc: "no response", code: codes.Unavailable},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI()
+		if tc.status != 0 {
+			api.On(mpesa.OpQueryTransactionStatus, mpesatest.Reply{Status: tc.status, Body: `{"output_ResponseCode":"INS-1"}`})
+		}
+		client, _, _ := newClient(t, api)
+
+		_, err := client.QueryTransactionStatus(context.Background(), &mpesapb.StatusRequest{QueryReference: "ref"})
+		assert.Equal(t, tc.code, status.Code(err), fmt.Sprintf("%s: expected code %s got %v\n", tc.desc, tc.code, err))
+	}
+}
+
+func TestWatchTransaction(t *testing.T) {
+	client, app, l := newClient(t, mpesatest.NewAPI().On(mpesa.OpC2B, mpesatest.Reply{Status: http.StatusCreated, Body: `{"output_ResponseCode":"INS-0","output_ConversationID":"conv-1"}`}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// watched before the payment is made
+	stream, err := client.WatchTransaction(ctx, &mpesapb.WatchRequest{Reference: "tpc-1"})
+	assert.Nil(t, err)
+
+	_, err = client.C2B(ctx, &mpesapb.PaymentRequest{Party: "255744553111", Amount: "10", ThirdPartyConversationId: "tpc-1"})
+	assert.Nil(t, err)
+
+	u, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, mpesapb.TransactionStatus_TRANSACTION_STATUS_ACCEPTED, u.GetStatus())
+	assert.Equal(t, "conv-1", u.GetConversationId())
+
+	h := &callback.Handler{Insecure: true, Dispatch: l.Callback, Events: app.Events()}
+	body := `{"input_OriginalConversationID":"conv-1","input_ThirdPartyConversationID":"tpc-1","input_TransactionID":"tx-1","input_ResultCode":"INS-0"}`
+	req, _ := http.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "expected the callback to be acknowledged")
+
+	u, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, mpesapb.TransactionStatus_TRANSACTION_STATUS_COMPLETED, u.GetStatus())
+	assert.Equal(t, "tx-1", u.GetTransactionId())
+	assert.Equal(t, "INS-0", u.GetResponseCode())
+
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err, "expected the stream to end once completed")
+}
+
+func TestWatchTransactionReference(t *testing.T) {
+	client, _, _ := newClient(t, mpesatest.NewAPI())
+
+	stream, err := client.WatchTransaction(context.Background(), &mpesapb.WatchRequest{})
+	assert.Nil(t, err)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "expected a reference to be required")
+}
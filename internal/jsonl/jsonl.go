@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package jsonl implements the append only JSON lines files behind the file
+// stores of the bulk, ledger and outbox packages.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File is an append only JSON lines file. It is not safe for concurrent
+// use, the stores serialise their calls.
+type File struct {
+	path string
+	f    *os.File
+}
+
+// Open opens, or creates, the file at path and calls fn with each of its
+// lines. A torn last line, left by a crash in the middle of an append, is
+// cut off the file so that the next append starts a line of its own.
+func Open(path string, fn func(line []byte)) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		size += int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			fn(line)
+		}
+	}
+
+	// the append of a torn line was never acknowledged
+	info, err := f.Stat()
+	if err == nil && info.Size() > size {
+		if err = f.Truncate(size); err == nil {
+			err = f.Sync()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &File{path: path, f: f}, nil
+}
+
+// Append durably appends vs, one per line, before returning.
+func (f *File) Append(vs ...interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	return f.f.Sync()
+}
+
+// Compact durably replaces the content of the file with the lines encoded by
+// fn, through a temporary file renamed over it.
+func (f *File) Compact(fn func(enc *json.Encoder) error) error {
+	tmp, err := os.OpenFile(f.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	if err := fn(json.NewEncoder(w)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+
+	// make the rename durable
+	if dir, err := os.Open(filepath.Dir(f.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	nf, err := os.OpenFile(f.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	f.f.Close()
+	f.f = nf
+	return nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package jsonl_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mobilemoney/mpesa/internal/jsonl"
+	"github.com/stretchr/testify/assert"
+)
+
+// open opens path and returns the file with its lines.
+func open(t *testing.T, path string) (*jsonl.File, []string) {
+	var lines []string
+	f, err := jsonl.Open(path, func(line []byte) {
+		lines = append(lines, string(line))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, lines
+}
+
+func TestOpen(t *testing.T) {
+	cases := []struct {
+		desc    string
+		content string
+		lines   []string
+	}{
+		{
+			desc: "new file",
+		},
+		{
+			desc:    "complete lines",
+			content: "{\"n\":1}\n{\"n\":2}\n",
+			lines:   []string{`{"n":1}`, `{"n":2}`},
+		},
+		{
+			desc:    "torn last line",
+			content: "{\"n\":1}\n{\"n\":",
+			lines:   []string{`{"n":1}`},
+		},
+		{
+			desc:    "last line without its newline",
+			content: "{\"n\":1}\n{\"n\":2}",
+			lines:   []string{`{"n":1}`},
+		},
+	}
+
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "store.jsonl")
+		if tc.content != "" {
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		f, lines := open(t, path)
+		assert.Equal(t, tc.lines, lines, fmt.Sprintf("%s: expected lines %v got %v\n", tc.desc, tc.lines, lines))
+
+		if err := f.Append(map[string]int{"n": 3}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		f, lines = open(t, path)
+		f.Close()
+		want := append(tc.lines, `{"n":3}`)
+		assert.Equal(t, want, lines, fmt.Sprintf("%s: expected the append to start a line of its own, got %v\n", tc.desc, lines))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+
+	f, _ := open(t, path)
+	if err := f.Append(map[string]int{"n": 1}, map[string]int{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := f.Compact(func(enc *json.Encoder) error {
+		return enc.Encode(map[string]int{"n": 2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Append(map[string]int{"n": 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, lines := open(t, path)
+	f.Close()
+	assert.Equal(t, []string{`{"n":2}`, `{"n":3}`}, lines, "expected the compacted lines followed by the appended ones")
+
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err), "expected the temporary file to be renamed")
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package mpesatest provides the fixtures shared by the tests of the mpesa
+// packages: an RSA key standing in for the market public key and a fake
+// OpenAPI gateway answering requests with canned replies.
+package mpesatest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+)
+
+// SessionID is the session key handed out by API.
+const SessionID = "session"
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+)
+
+// Key returns the RSA key of the tests, generated once per process.
+func Key() *rsa.PrivateKey {
+	keyOnce.Do(func() {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 1024); err != nil {
+			panic(err)
+		}
+	})
+	return key
+}
+
+// Reply is a canned API response.
+type Reply struct {
+	Status int
+	Body   string
+	Header http.Header
+}
+
+// API is an http.RoundTripper answering M-Pesa requests by operation, the
+// last path segment before the market-relative path, e.g. "getSession" or
+// "c2bPayment". Sessions are granted unless replies are set for them.
+type API struct {
+	mu       sync.Mutex
+	replies  map[string][]Reply
+	handlers map[string]func(*http.Request) Reply
+	requests map[string]int
+}
+
+// NewAPI returns an API granting sessions and failing other requests.
+func NewAPI() *API {
+	return &API{
+		replies:  make(map[string][]Reply),
+		handlers: make(map[string]func(*http.Request) Reply),
+		requests: make(map[string]int),
+	}
+}
+
+// On answers the requests of op with replies in order, repeating the last.
+func (a *API) On(op string, replies ...Reply) *API {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replies[op] = replies
+	return a
+}
+
+// Handle answers the requests of op with fn.
+func (a *API) Handle(op string, fn func(*http.Request) Reply) *API {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.handlers[op] = fn
+	return a
+}
+
+// Requests returns the number of requests of op received.
+func (a *API) Requests(op string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.requests[op]
+}
+
+// RoundTrip implements http.RoundTripper.
+func (a *API) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	op := operation(req)
+
+	a.mu.Lock()
+	a.requests[op]++
+	fn := a.handlers[op]
+	replies, ok := a.replies[op]
+	var r Reply
+	if ok && len(replies) > 0 {
+		r = replies[0]
+		if len(replies) > 1 {
+			a.replies[op] = replies[1:]
+		}
+	}
+	a.mu.Unlock()
+
+	switch {
+	case fn != nil:
+		r = fn(req)
+	case ok:
+	case op == mpesa.OpGetSession:
+		r = Reply{Status: http.StatusOK, Body: fmt.Sprintf(`{"output_ResponseCode":"INS-0","output_ResponseDesc":"Request processed successfully","output_SessionID":%q}`, SessionID)}
+	default:
+		return nil, fmt.Errorf("mpesatest: unexpected request %s %s", req.Method, req.URL)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	for k, v := range r.Header {
+		header[k] = v
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+// operation returns the operation of an OpenAPI request path, e.g.
+// ".../ipg/v2/vodacomTZN/c2bPayment/singleStage/" is c2bPayment.
+func operation(req *http.Request) string {
+	path := req.URL.Path
+	if i := strings.Index(path, "/ipg/v2/"); i >= 0 {
+		path = path[i+len("/ipg/v2/"):]
+		if j := strings.Index(path, "/"); j >= 0 {
+			path = path[j+1:]
+		}
+	}
+	return strings.SplitN(strings.Trim(path, "/"), "/", 2)[0]
+}
+
+// NewApplication returns a Vodacom Tanzania sandbox application sending its
+// requests through rt, with Key as public key.
+func NewApplication(t testing.TB, rt http.RoundTripper, opts ...mpesa.Option) *mpesa.Application {
+	t.Helper()
+
+	opts = append([]mpesa.Option{
+		mpesa.WithHTTPClient(&http.Client{Transport: rt}),
+		mpesa.WithPublicKey(&Key().PublicKey, nil),
+	}, opts...)
+
+	app, err := mpesa.NewApplication("key", mpesa.VodacomTanzania, mpesa.Sandbox, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
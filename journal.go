@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+	"time"
+)
+
+// JournalEntry describes one transaction operation.
+type JournalEntry struct {
+	Operation string
+
+	// Request is the operation input, e.g. a C2BPayment or a StatusQuery,
+	// after the application filled in its defaults.
+	Request interface{}
+
+	// Response is the decoded response, e.g. a *TransactionResp. It may be
+	// partially filled in when Err is not nil.
+	Response interface{}
+
+	Err error
+
+	Start time.Time
+	End   time.Time
+}
+
+// Journal receives every transaction operation once it has completed, see
+// the ledger package. Session key generation is not journaled.
+// Implementations must be safe for concurrent use.
+type Journal interface {
+	Record(ctx context.Context, e JournalEntry)
+}
+
+// JournalInitiator is implemented by journals which also record transaction
+// operations before they are sent, e.g. so that a payment interrupted by a
+// crash is found initiated. Initiate is called with an entry whose Response,
+// Err and End are not set yet, Record is called with the same Request and
+// Start once the operation has completed. An error returned by Initiate
+// fails the operation with that error before it is sent, and the operation
+// is not recorded.
+type JournalInitiator interface {
+	Journal
+	Initiate(ctx context.Context, e JournalEntry) error
+}
+
+// WithJournal records every transaction operation to j.
+func WithJournal(j Journal) Option {
+	return func(app *Application) {
+		app.journal = j
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mobilemoney/mpesa/internal/jsonl"
+)
+
+var _ Store = (*File)(nil)
+
+// File is a Store backed by an append only JSON lines file, holding every
+// transaction in memory for queries. Each Put appends the full transaction
+// and syncs the file before returning; the last line written for an ID wins.
+// Compact drops superseded lines.
+type File struct {
+	*Memory
+
+	f *jsonl.File
+}
+
+// OpenFile opens, or creates, the ledger file at path and reads the
+// transactions recorded in it.
+func OpenFile(path string) (*File, error) {
+	s := &File{Memory: NewMemory()}
+
+	f, err := jsonl.Open(path, func(line []byte) {
+		var tx Transaction
+		if err := json.Unmarshal(line, &tx); err == nil && tx.ID != "" {
+			s.Memory.put(&tx)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+
+	return s, nil
+}
+
+// Put implements Store.
+func (s *File) Put(ctx context.Context, tx *Transaction) error {
+	if tx.ID == "" {
+		return ErrNoID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Append(tx); err != nil {
+		return err
+	}
+
+	s.Memory.put(tx.clone())
+	return nil
+}
+
+// Compact rewrites the file with a single line per transaction.
+func (s *File) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Compact(func(enc *json.Encoder) error {
+		for _, tx := range s.txs {
+			if err := enc.Encode(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the ledger file.
+func (s *File) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,450 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package ledger keeps a local record of every M-Pesa transaction made by an
+// application: its request, its response and every later callback or status
+// query update, all under a single Transaction.
+//
+//	l := ledger.New(ledger.NewMemory())
+//	app, err := mpesa.NewApplication(key, market, env, mpesa.WithJournal(l))
+//	allow, err := callback.NewAllowlist(trustedSources, nil)
+//	http.Handle("/mpesa/callback", &callback.Handler{Verifiers: []callback.Verifier{allow}, Dispatch: l.Callback})
+//
+// Updates move transactions through the lifecycle described by Status and
+// illegal transitions are rejected, see TransitionError.
+//
+// Transactions are keyed by their third party conversation ID and can also be
+// looked up by transaction ID, conversation ID or transaction reference.
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	pkgerrors "github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// Source of an update.
+type Source string
+
+const (
+	SourceResponse    Source = "response"
+	SourceCallback    Source = "callback"
+	SourceStatusQuery Source = "status_query"
+	SourceReversal    Source = "reversal"
+	SourceSweeper     Source = "sweeper"
+)
+
+const (
+	codeSuccess = "INS-0"
+
+	// codeTimeout means the API gave up waiting, the transaction may still
+	// have been made
+	codeTimeout = "INS-9"
+)
+
+// Update is a change to a transaction reported by a response, callback or
+// status query.
+type Update struct {
+	Source Source `json:"source"`
+
+	// Status is the status the update moves the transaction to, an empty
+	// Status leaves it unchanged.
+	Status Status `json:"status,omitempty"`
+
+	Code string `json:"response_code,omitempty"`
+
+	// TransactionID and ConversationID, when set, fill in the IDs of the
+	// transaction if it does not have them yet.
+	TransactionID  string `json:"transaction_id,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Time    time.Time       `json:"time"`
+
+	// Rejected updates attempted an illegal transition and did not change
+	// the status.
+	Rejected bool `json:"rejected,omitempty"`
+}
+
+// Transaction is the record of a single C2B, B2C or B2B transaction.
+type Transaction struct {
+
+	// ID is the third party conversation ID of the transaction.
+	ID string `json:"id"`
+
+	Operation string `json:"operation"`
+	Status    Status `json:"status"`
+
+	Amount    string `json:"amount,omitempty"`
+	Currency  string `json:"currency,omitempty"`
+	Party     string `json:"party,omitempty"`
+	Reference string `json:"reference,omitempty"`
+
+	TransactionID  string `json:"transaction_id,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Code           string `json:"response_code,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Updates  []Update        `json:"updates,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// clone returns a copy of tx that shares no slices with it.
+func (tx *Transaction) clone() *Transaction {
+	c := *tx
+	c.Updates = append([]Update(nil), tx.Updates...)
+	return &c
+}
+
+// apply appends u to the transaction and applies its status and IDs. An
+// update with an illegal transition is kept for the record as Rejected,
+// without changing the status, and its *TransitionError is returned.
+func (tx *Transaction) apply(u Update) error {
+	if u.Time.IsZero() {
+		u.Time = time.Now().UTC()
+	}
+
+	var err error
+	if u.Status != "" {
+		if err = transition(tx, u); err != nil {
+			u.Rejected = true
+		} else {
+			tx.Status = u.Status
+		}
+	}
+
+	if tx.TransactionID == "" {
+		tx.TransactionID = u.TransactionID
+	}
+
+	if tx.ConversationID == "" {
+		tx.ConversationID = u.ConversationID
+	}
+
+	tx.Updates = append(tx.Updates, u)
+	tx.UpdatedAt = u.Time
+	return err
+}
+
+var _ mpesa.Journal = (*Ledger)(nil)
+
+// Ledger records the transactions of an application into a Store, it
+// implements mpesa.Journal. Store methods are promoted for querying.
+type Ledger struct {
+	Store
+
+	// OnError, if set, is called with every error storing a journaled
+	// transaction, which would otherwise go unreported.
+	OnError func(error)
+
+	// Async records successful payment responses as Accepted rather than
+	// Completed, for applications whose payments are settled by callback.
+	Async bool
+
+	// mu serializes read-modify-write cycles on the store
+	mu sync.Mutex
+}
+
+// New returns a ledger recording into s.
+func New(s Store) *Ledger {
+	return &Ledger{Store: s}
+}
+
+var _ mpesa.JournalInitiator = (*Ledger)(nil)
+
+// Initiate records a payment as Initiated before it is sent, so that a
+// payment whose outcome is lost, e.g. in a crash, is found in the ledger.
+// A payment reusing the third party conversation ID of a transaction in the
+// ledger is rejected with ErrDuplicate and not sent. Other errors are passed
+// to OnError without stopping the payment. Other operations are ignored.
+func (l *Ledger) Initiate(ctx context.Context, e mpesa.JournalEntry) error {
+	tx := newPayment(e)
+	if tx == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	err := l.initiate(ctx, tx)
+	l.mu.Unlock()
+
+	if pkgerrors.Contains(err, ErrDuplicate) {
+		return err
+	}
+
+	if err != nil && l.OnError != nil {
+		l.OnError(err)
+	}
+	return nil
+}
+
+func (l *Ledger) initiate(ctx context.Context, tx *Transaction) error {
+	if _, err := l.Get(ctx, tx.ID); !isNotFound(err) {
+		if err == nil {
+			err = pkgerrors.Wrap(ErrDuplicate, pkgerrors.New(tx.ID))
+		}
+		return err
+	}
+
+	return l.Put(ctx, tx)
+}
+
+// Record records a completed operation. Payments update the transaction
+// created by Initiate, or create it, reversals and status queries update the
+// transaction they refer to when it is in the ledger. Other operations are
+// ignored.
+func (l *Ledger) Record(ctx context.Context, e mpesa.JournalEntry) {
+	var err error
+
+	switch req := e.Request.(type) {
+	case mpesa.C2BPayment, mpesa.B2CPayment, mpesa.B2BPayment:
+		err = l.payment(ctx, e)
+
+	case mpesa.Reversal:
+		u := Update{Source: SourceReversal, Payload: marshal(e.Response)}
+		if resp, ok := e.Response.(*mpesa.ReversalResp); ok && e.Err == nil {
+			u.Code = resp.Code
+			if resp.Code == codeSuccess {
+				u.Status = Reversed
+			}
+		}
+		err = l.updateIfFound(ctx, req.TransactionID, u)
+
+	case mpesa.StatusQuery:
+		resp, ok := e.Response.(*mpesa.StatusResp)
+		if !ok || e.Err != nil {
+			return
+		}
+		err = l.updateIfFound(ctx, req.QueryReference, Update{
+			Source:         SourceStatusQuery,
+			Status:         StatusFromQuery(resp.ResponseTransactionStatus),
+			Code:           resp.Code,
+			ConversationID: resp.ConversationID,
+			Payload:        marshal(resp),
+		})
+	}
+
+	if err != nil && l.OnError != nil {
+		l.OnError(err)
+	}
+}
+
+// newPayment returns the Initiated transaction of a payment entry, or nil
+// for other operations.
+func newPayment(e mpesa.JournalEntry) *Transaction {
+	var id, amount, currency, party, reference string
+
+	switch req := e.Request.(type) {
+	case mpesa.C2BPayment:
+		id, amount, currency, party, reference = req.ThirdPartyConversationID, req.Amount, req.Currency, req.CustomerMSISDN, req.TransactionReference
+	case mpesa.B2CPayment:
+		id, amount, currency, party, reference = req.ThirdPartyConversationID, req.Amount, req.Currency, req.CustomerMSISDN, req.TransactionReference
+	case mpesa.B2BPayment:
+		id, amount, currency, party, reference = req.ThirdPartyConversationID, req.Amount, req.Currency, req.ReceiverPartyCode, req.TransactionReference
+	default:
+		return nil
+	}
+
+	return &Transaction{
+		ID:        id,
+		Operation: e.Operation,
+		Status:    Initiated,
+		Amount:    amount,
+		Currency:  currency,
+		Party:     party,
+		Reference: reference,
+		Request:   marshal(e.Request),
+		CreatedAt: e.Start.UTC(),
+		UpdatedAt: e.Start.UTC(),
+	}
+}
+
+// payment applies the response of a completed payment to the transaction
+// Initiate created for it, or to a new one. A transaction with the same ID
+// created by another payment is left unchanged and ErrDuplicate returned.
+func (l *Ledger) payment(ctx context.Context, e mpesa.JournalEntry) error {
+	tx := newPayment(e)
+
+	u := Update{Source: SourceResponse, Status: statusOf(e.Err), Time: e.End.UTC()}
+	var response json.RawMessage
+	if resp, ok := e.Response.(*mpesa.TransactionResp); ok {
+		response = marshal(resp)
+		u.Code = resp.Code
+		u.TransactionID = resp.TransactionID
+		u.ConversationID = resp.ConversationID
+		if e.Err == nil && resp.Code == codeSuccess {
+			u.Status = Completed
+			if l.Async {
+				u.Status = Accepted
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	initiated, err := l.Get(ctx, tx.ID)
+	switch {
+	case err == nil && (initiated.Operation != tx.Operation || !initiated.CreatedAt.Equal(tx.CreatedAt)):
+		return pkgerrors.Wrap(ErrDuplicate, pkgerrors.New(tx.ID))
+	case err == nil:
+		tx = initiated
+	case !isNotFound(err):
+		return err
+	}
+
+	tx.Response = response
+	tx.Code = u.Code
+	if e.Err != nil {
+		tx.Error = e.Err.Error()
+	}
+	applyErr := tx.apply(u)
+
+	if err := l.Put(ctx, tx); err != nil {
+		return err
+	}
+
+	return applyErr
+}
+
+// Update applies u to the transaction with ID, transaction ID, conversation
+// ID or reference ref and returns the updated transaction. An update making
+// an illegal transition, e.g. a late failed callback for a completed
+// transaction, is stored as Rejected and reported by a *TransitionError
+// along with the unchanged transaction.
+func (l *Ledger) Update(ctx context.Context, ref string, u Update) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tx, err := l.Lookup(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	applyErr := tx.apply(u)
+
+	if err := l.Put(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	return tx, applyErr
+}
+
+// updateIfFound is Update for transactions that may not be in the ledger.
+func (l *Ledger) updateIfFound(ctx context.Context, ref string, u Update) error {
+	_, err := l.Update(ctx, ref, u)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// statusOf returns the status of a payment that did not succeed.
+func statusOf(err error) Status {
+	var respErr *mpesa.ResponseError
+
+	switch {
+	case err == nil:
+		// accepted at the HTTP level with an unexpected response code
+		return Failed
+
+	case errors.Is(err, mpesa.ErrCircuitOpen):
+		return Failed
+
+	case errors.As(err, &respErr):
+		if respErr.StatusCode >= 500 || respErr.Code == codeTimeout {
+			return Pending
+		}
+		return Failed
+	}
+
+	// no response, the payment may have been made
+	return Pending
+}
+
+// StatusFromQuery maps the output_ResponseTransactionStatus of a status query
+// to a Status. Statuses other than Completed, Failed and Reversed are Pending.
+func StatusFromQuery(s string) Status {
+	switch s {
+	case "Completed":
+		return Completed
+	case "Failed", "Cancelled", "Declined":
+		return Failed
+	case "Reversed":
+		return Reversed
+	}
+	return Pending
+}
+
+// marshal encodes v for storage, v is nil or one of the SDK's request and
+// response types which always encode.
+func marshal(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// Callback records cb to the transaction it settles, it is a
+// callback.DispatchFunc. Callbacks for transactions not in the ledger are
+// ignored; illegal transitions are recorded as rejected and reported to
+// OnError, but do not fail the callback, which M-Pesa would only retry.
+func (l *Ledger) Callback(ctx context.Context, cb *callback.Callback) error {
+	u := Update{
+		Source:         SourceCallback,
+		Status:         Failed,
+		Code:           cb.ResultCode,
+		TransactionID:  cb.TransactionID,
+		ConversationID: cb.OriginalConversationID,
+		Payload:        json.RawMessage(cb.Body),
+		Time:           cb.ReceivedAt,
+	}
+	if cb.Succeeded() {
+		u.Status = Completed
+	}
+
+	for _, ref := range []string{cb.ThirdPartyConversationID, cb.OriginalConversationID, cb.ConversationID, cb.TransactionID} {
+		if ref == "" {
+			continue
+		}
+
+		_, err := l.Update(ctx, ref, u)
+
+		var terr *TransitionError
+		switch {
+		case isNotFound(err):
+			continue
+		case errors.As(err, &terr):
+			if l.OnError != nil {
+				l.OnError(err)
+			}
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,478 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/ledger"
+	pkgerrors "github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func payment(id, ref string, at time.Time, resp *mpesa.TransactionResp, err error) mpesa.JournalEntry {
+	return mpesa.JournalEntry{
+		Operation: mpesa.OpB2C,
+		Request: mpesa.B2CPayment{
+			Amount:                   "10.00",
+			Currency:                 "TZS",
+			CustomerMSISDN:           "255744553111",
+			ThirdPartyConversationID: id,
+			TransactionReference:     ref,
+		},
+		Response: resp,
+		Err:      err,
+		Start:    at,
+		End:      at.Add(time.Second),
+	}
+}
+
+func TestLedgerRecord(t *testing.T) {
+	cases := []struct {
+		desc   string
+		entry  mpesa.JournalEntry
+		status ledger.Status
+	}{
+		{
+			desc:   "record successful payment",
+			entry:  payment("a", "ref-a", t0, &mpesa.TransactionResp{Code: "INS-0", TransactionID: "tx-a", ConversationID: "conv-a"}, nil),
+			status: ledger.Completed,
+		},
+		{
+			desc:   "record rejected payment",
+			entry:  payment("b", "ref-b", t0.Add(time.Hour), &mpesa.TransactionResp{}, &mpesa.ResponseError{StatusCode: 400, Code: "INS-13"}),
+			status: ledger.Failed,
+		},
+		{
+			desc:   "record payment timed out by the API",
+			entry:  payment("c", "ref-c", t0.Add(2*time.Hour), &mpesa.TransactionResp{}, &mpesa.ResponseError{StatusCode: 400, Code: "INS-9"}),
+			status: ledger.Pending,
+		},
+		{
+			desc:   "record payment without response",
+			entry:  payment("d", "ref-d", t0.Add(3*time.Hour), &mpesa.TransactionResp{}, errors.New("connection reset")),
+			status: ledger.Pending,
+		},
+		{
+			desc:   "record payment refused by open circuit",
+			entry:  payment("e", "ref-e", t0.Add(4*time.Hour), &mpesa.TransactionResp{}, &mpesa.CircuitOpenError{Circuit: mpesa.TransactionCircuit}),
+			status: ledger.Failed,
+		},
+	}
+
+	ctx := context.Background()
+	l := ledger.New(ledger.NewMemory())
+
+	for _, tc := range cases {
+		l.Record(ctx, tc.entry)
+
+		req := tc.entry.Request.(mpesa.B2CPayment)
+		tx, err := l.Get(ctx, req.ThirdPartyConversationID)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+		assert.Equal(t, req.TransactionReference, tx.Reference, fmt.Sprintf("%s: expected reference %s got %s\n", tc.desc, req.TransactionReference, tx.Reference))
+		assert.Equal(t, 1, len(tx.Updates), fmt.Sprintf("%s: expected 1 update got %d\n", tc.desc, len(tx.Updates)))
+	}
+
+	pending, _ := l.ByStatus(ctx, ledger.Pending)
+	assert.Equal(t, 2, len(pending), fmt.Sprintf("query by status: expected 2 pending transactions got %d\n", len(pending)))
+
+	day, _ := l.ByDate(ctx, t0.Add(time.Hour), t0.Add(3*time.Hour))
+	ids := make([]string, len(day))
+	for i, tx := range day {
+		ids[i] = tx.ID
+	}
+	assert.Equal(t, []string{"b", "c"}, ids, fmt.Sprintf("query by date: expected [b c] got %v\n", ids))
+}
+
+func TestLedgerUpdate(t *testing.T) {
+	ctx := context.Background()
+	l := ledger.New(ledger.NewMemory())
+
+	l.Record(ctx, payment("a", "ref-a", t0, &mpesa.TransactionResp{}, errors.New("timeout")))
+	l.Record(ctx, payment("b", "ref-b", t0, &mpesa.TransactionResp{Code: "INS-0", TransactionID: "tx-b"}, nil))
+
+	cases := []struct {
+		desc   string
+		update func()
+		id     string
+		status ledger.Status
+	}{
+		{
+			desc: "settle pending transaction by status query",
+			update: func() {
+				l.Record(ctx, mpesa.JournalEntry{
+					Operation: mpesa.OpQueryTransactionStatus,
+					Request:   mpesa.StatusQuery{QueryReference: "a"},
+					Response:  &mpesa.StatusResp{Code: "INS-0", ResponseTransactionStatus: "Completed", ConversationID: "conv-a"},
+				})
+			},
+			id:     "a",
+			status: ledger.Completed,
+		},
+		{
+			desc: "reverse transaction by transaction ID",
+			update: func() {
+				l.Record(ctx, mpesa.JournalEntry{
+					Operation: mpesa.OpReversal,
+					Request:   mpesa.Reversal{TransactionID: "tx-b"},
+					Response:  &mpesa.ReversalResp{Code: "INS-0"},
+				})
+			},
+			id:     "b",
+			status: ledger.Reversed,
+		},
+		{
+			desc: "apply callback by conversation ID",
+			update: func() {
+				_, err := l.Update(ctx, "conv-a", ledger.Update{Source: ledger.SourceCallback, Status: ledger.Completed, TransactionID: "tx-a"})
+				assert.Nil(t, err, fmt.Sprintf("apply callback: expected no error got %v\n", err))
+			},
+			id:     "a",
+			status: ledger.Completed,
+		},
+	}
+
+	for _, tc := range cases {
+		tc.update()
+
+		tx, err := l.Get(ctx, tc.id)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+	}
+
+	tx, _ := l.Lookup(ctx, "tx-a")
+	assert.Equal(t, 3, len(tx.Updates), fmt.Sprintf("lookup by transaction ID: expected 3 updates got %d\n", len(tx.Updates)))
+
+	_, err := l.Update(ctx, "unknown", ledger.Update{Source: ledger.SourceCallback})
+	assert.True(t, pkgerrors.Contains(err, ledger.ErrNotFound), fmt.Sprintf("update unknown transaction: expected %v got %v\n", ledger.ErrNotFound, err))
+}
+
+func TestLedgerInitiate(t *testing.T) {
+	ctx := context.Background()
+
+	var reported []error
+	l := ledger.New(ledger.NewMemory())
+	l.OnError = func(err error) { reported = append(reported, err) }
+
+	first := payment("a", "ref-a", t0, &mpesa.TransactionResp{Code: "INS-0", TransactionID: "tx-a"}, nil)
+	reused := payment("a", "ref-b", t0.Add(time.Hour), &mpesa.TransactionResp{Code: "INS-0", TransactionID: "tx-b"}, nil)
+
+	cases := []struct {
+		desc     string
+		record   func() error
+		err      error
+		status   ledger.Status
+		updates  int
+		reported int
+	}{
+		{
+			desc: "payment initiated",
+			record: func() error {
+				return l.Initiate(ctx, mpesa.JournalEntry{Operation: first.Operation, Request: first.Request, Start: first.Start})
+			},
+			status: ledger.Initiated,
+		},
+		{
+			desc:    "response recorded",
+			record:  func() error { l.Record(ctx, first); return nil },
+			status:  ledger.Completed,
+			updates: 1,
+		},
+		{
+			desc: "conversation id reused",
+			record: func() error {
+				return l.Initiate(ctx, mpesa.JournalEntry{Operation: reused.Operation, Request: reused.Request, Start: reused.Start})
+			},
+			err:     ledger.ErrDuplicate,
+			status:  ledger.Completed,
+			updates: 1,
+		},
+		{
+			desc:     "response of the payment reusing the conversation id",
+			record:   func() error { l.Record(ctx, reused); return nil },
+			status:   ledger.Completed,
+			updates:  1,
+			reported: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		err := tc.record()
+		assert.True(t, pkgerrors.Contains(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+
+		tx, err := l.Get(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+		assert.Equal(t, tc.updates, len(tx.Updates), fmt.Sprintf("%s: expected %d updates got %d\n", tc.desc, tc.updates, len(tx.Updates)))
+		assert.Equal(t, "ref-a", tx.Reference, fmt.Sprintf("%s: expected the first payment to be kept\n", tc.desc))
+		assert.True(t, tx.CreatedAt.Equal(t0), fmt.Sprintf("%s: expected the initiation time to be kept\n", tc.desc))
+		if assert.Equal(t, tc.reported, len(reported), fmt.Sprintf("%s: expected %d errors got %v\n", tc.desc, tc.reported, reported)) && tc.reported > 0 {
+			assert.True(t, pkgerrors.Contains(reported[tc.reported-1], ledger.ErrDuplicate), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, ledger.ErrDuplicate, reported[tc.reported-1]))
+		}
+	}
+}
+
+func TestLedgerJournal(t *testing.T) {
+	l := ledger.New(ledger.NewMemory())
+
+	var status ledger.Status
+	api := mpesatest.NewAPI().Handle(mpesa.OpB2C, func(req *http.Request) mpesatest.Reply {
+		var p mpesa.B2CPayment
+		json.NewDecoder(req.Body).Decode(&p)
+		if tx, err := l.Get(req.Context(), p.ThirdPartyConversationID); err == nil {
+			status = tx.Status
+		}
+		return mpesatest.Reply{Status: http.StatusCreated, Body: `{"output_ResponseCode":"INS-0","output_TransactionID":"tx-a"}`}
+	})
+	app := mpesatest.NewApplication(t, api, mpesa.WithJournal(l))
+
+	_, err := app.B2C(context.Background(), mpesa.B2CPayment{Amount: "10", CustomerMSISDN: "255744553111", TransactionReference: "ref-a", ThirdPartyConversationID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ledger.Initiated, status, "expected the payment to be journaled before it is sent\n")
+
+	tx, err := l.Get(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ledger.Completed, tx.Status, "expected the response to complete the payment\n")
+
+	_, err = app.B2C(context.Background(), mpesa.B2CPayment{Amount: "20", CustomerMSISDN: "255744553111", TransactionReference: "ref-b", ThirdPartyConversationID: "a"})
+	assert.True(t, pkgerrors.Contains(err, ledger.ErrDuplicate), fmt.Sprintf("reused conversation id: expected error %v got %v\n", ledger.ErrDuplicate, err))
+	assert.Equal(t, 1, api.Requests(mpesa.OpB2C), "reused conversation id: expected the payment not to be sent\n")
+
+	tx, _ = l.Get(context.Background(), "a")
+	assert.Equal(t, "ref-a", tx.Reference, "reused conversation id: expected the first payment to be kept\n")
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+
+	store, err := ledger.OpenFile(path)
+	assert.Nil(t, err, fmt.Sprintf("open: expected no error got %v\n", err))
+
+	l := ledger.New(store)
+	l.Record(ctx, payment("a", "ref-a", t0, &mpesa.TransactionResp{}, errors.New("timeout")))
+	l.Record(ctx, payment("b", "ref-b", t0, &mpesa.TransactionResp{Code: "INS-0", TransactionID: "tx-b"}, nil))
+	_, err = l.Update(ctx, "ref-a", ledger.Update{Source: ledger.SourceCallback, Status: ledger.Completed})
+	assert.Nil(t, err, fmt.Sprintf("update: expected no error got %v\n", err))
+
+	cases := []struct {
+		desc    string
+		prepare func() error
+	}{
+		{desc: "reopen file", prepare: func() error { return nil }},
+		{desc: "reopen compacted file", prepare: store.Compact},
+	}
+
+	for _, tc := range cases {
+		err := tc.prepare()
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+
+		reopened, err := ledger.OpenFile(path)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+
+		completed, _ := reopened.ByStatus(ctx, ledger.Completed)
+		assert.Equal(t, 2, len(completed), fmt.Sprintf("%s: expected 2 completed transactions got %d\n", tc.desc, len(completed)))
+
+		tx, err := reopened.Lookup(ctx, "tx-b")
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+		assert.Equal(t, "b", tx.ID, fmt.Sprintf("%s: expected transaction b got %s\n", tc.desc, tx.ID))
+
+		reopened.Close()
+	}
+
+	store.Close()
+}
+
+func TestLifecycle(t *testing.T) {
+	cases := []struct {
+		desc   string
+		async  bool
+		entry  mpesa.JournalEntry
+		update ledger.Update
+		status ledger.Status
+		err    bool
+	}{
+		{
+			desc:   "accept async payment then complete by callback",
+			async:  true,
+			entry:  payment("a", "ref-a", t0, &mpesa.TransactionResp{Code: "INS-0"}, nil),
+			update: ledger.Update{Source: ledger.SourceCallback, Status: ledger.Completed},
+			status: ledger.Completed,
+		},
+		{
+			desc:   "reject late failed callback for completed payment",
+			entry:  payment("b", "ref-b", t0, &mpesa.TransactionResp{Code: "INS-0"}, nil),
+			update: ledger.Update{Source: ledger.SourceCallback, Status: ledger.Failed},
+			status: ledger.Completed,
+			err:    true,
+		},
+		{
+			desc:   "reject pending status query for failed payment",
+			entry:  payment("c", "ref-c", t0, &mpesa.TransactionResp{}, &mpesa.ResponseError{StatusCode: 400, Code: "INS-13"}),
+			update: ledger.Update{Source: ledger.SourceStatusQuery, Status: ledger.Pending},
+			status: ledger.Failed,
+			err:    true,
+		},
+		{
+			desc:   "expire pending payment",
+			entry:  payment("d", "ref-d", t0, &mpesa.TransactionResp{}, errors.New("timeout")),
+			update: ledger.Update{Source: ledger.SourceSweeper, Status: ledger.Expired},
+			status: ledger.Expired,
+		},
+		{
+			desc:   "accept retried callback with same status",
+			entry:  payment("e", "ref-e", t0, &mpesa.TransactionResp{Code: "INS-0"}, nil),
+			update: ledger.Update{Source: ledger.SourceCallback, Status: ledger.Completed},
+			status: ledger.Completed,
+		},
+		{
+			desc:   "reject reversal of pending payment",
+			entry:  payment("f", "ref-f", t0, &mpesa.TransactionResp{}, errors.New("timeout")),
+			update: ledger.Update{Source: ledger.SourceReversal, Status: ledger.Reversed},
+			status: ledger.Pending,
+			err:    true,
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, tc := range cases {
+		l := ledger.New(ledger.NewMemory())
+		l.Async = tc.async
+		l.Record(ctx, tc.entry)
+
+		id := tc.entry.Request.(mpesa.B2CPayment).ThirdPartyConversationID
+		tx, err := l.Update(ctx, id, tc.update)
+
+		var terr *ledger.TransitionError
+		assert.Equal(t, tc.err, errors.As(err, &terr), fmt.Sprintf("%s: expected transition error %t got %v\n", tc.desc, tc.err, err))
+		assert.Equal(t, tc.err, errors.Is(err, ledger.ErrIllegalTransition), fmt.Sprintf("%s: expected %v got %v\n", tc.desc, ledger.ErrIllegalTransition, err))
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+
+		last := tx.Updates[len(tx.Updates)-1]
+		assert.Equal(t, tc.err, last.Rejected, fmt.Sprintf("%s: expected rejected update %t got %t\n", tc.desc, tc.err, last.Rejected))
+	}
+
+	l := ledger.New(ledger.NewMemory())
+	l.Record(ctx, payment("g", "ref-g", t0, &mpesa.TransactionResp{}, errors.New("timeout")))
+	l.Update(ctx, "g", ledger.Update{Source: ledger.SourceSweeper, Status: ledger.Expired})
+	tx, err := l.Update(ctx, "g", ledger.Update{Source: ledger.SourceCallback, Status: ledger.Completed})
+	assert.Nil(t, err, fmt.Sprintf("settle expired payment: expected no error got %v\n", err))
+	assert.Equal(t, ledger.Completed, tx.Status, fmt.Sprintf("settle expired payment: expected status completed got %s\n", tx.Status))
+}
+
+func TestLedgerCallback(t *testing.T) {
+	ctx := context.Background()
+
+	var rejected []error
+	l := ledger.New(ledger.NewMemory())
+	l.Async = true
+	l.OnError = func(err error) { rejected = append(rejected, err) }
+
+	l.Record(ctx, payment("a", "ref-a", t0, &mpesa.TransactionResp{Code: "INS-0", ConversationID: "conv-a"}, nil))
+
+	cases := []struct {
+		desc     string
+		cb       *callback.Callback
+		status   ledger.Status
+		rejected int
+	}{
+		{
+			desc:   "ignore callback for unknown transaction",
+			cb:     &callback.Callback{OriginalConversationID: "conv-x", ResultCode: "INS-0"},
+			status: ledger.Accepted,
+		},
+		{
+			desc:   "complete transaction by original conversation ID",
+			cb:     &callback.Callback{OriginalConversationID: "conv-a", TransactionID: "tx-a", ResultCode: "INS-0"},
+			status: ledger.Completed,
+		},
+		{
+			desc:     "reject late failed callback",
+			cb:       &callback.Callback{ThirdPartyConversationID: "a", ResultCode: "INS-2006"},
+			status:   ledger.Completed,
+			rejected: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		err := l.Callback(ctx, tc.cb)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+
+		tx, _ := l.Get(ctx, "a")
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+		assert.Equal(t, tc.rejected, len(rejected), fmt.Sprintf("%s: expected %d rejected got %d\n", tc.desc, tc.rejected, len(rejected)))
+	}
+}
+
+func TestFileStoreTornLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+
+	store, err := ledger.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(ctx, &ledger.Transaction{ID: "a", Status: ledger.Initiated})
+	store.Close()
+
+	// a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"b","sta`)
+	f.Close()
+
+	store, err = ledger.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(ctx, &ledger.Transaction{ID: "c", Status: ledger.Initiated})
+	store.Close()
+
+	reopened, err := ledger.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	for _, id := range []string{"a", "c"} {
+		_, err := reopened.Get(ctx, id)
+		assert.Nil(t, err, fmt.Sprintf("expected transaction %s to be kept got %v\n", id, err))
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// Status of a transaction. Transactions move through
+//
+//	initiated → accepted → pending → completed | failed | expired
+//	completed → reversed
+//	expired   → completed | failed
+//
+// skipping states as needed, e.g. from initiated straight to completed on a
+// synchronous response. Completed, failed and reversed are final, except for
+// the reversal of a completed transaction. Expired transactions can still be
+// settled by a late callback or status query, since money may have moved.
+type Status string
+
+const (
+	// Initiated transactions have been sent without a recorded response.
+	Initiated Status = "initiated"
+
+	// Accepted transactions have been accepted by the API for processing,
+	// their result is expected by callback.
+	Accepted Status = "accepted"
+
+	// Pending transactions may or may not have been made, e.g. after a
+	// timeout, until a callback or status query settles them.
+	Pending Status = "pending"
+
+	// Completed transactions have been made.
+	Completed Status = "completed"
+
+	// Failed transactions have been rejected and were not made.
+	Failed Status = "failed"
+
+	// Reversed transactions have been made and then reversed.
+	Reversed Status = "reversed"
+
+	// Expired transactions stayed pending for longer than they could be
+	// settled, see the sweep package.
+	Expired Status = "expired"
+)
+
+var transitions = map[Status][]Status{
+	Initiated: {Accepted, Pending, Completed, Failed, Expired},
+	Accepted:  {Pending, Completed, Failed, Expired},
+	Pending:   {Completed, Failed, Expired},
+	Completed: {Reversed},
+	Expired:   {Completed, Failed},
+}
+
+// ErrIllegalTransition is wrapped by every *TransitionError.
+var ErrIllegalTransition = errors.New("illegal transaction status transition")
+
+// TransitionError reports an update that would have moved a transaction
+// between two statuses that do not follow each other.
+type TransitionError struct {
+	ID     string
+	From   Status
+	To     Status
+	Source Source
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("transaction %s: %s from %s to %s by %s", e.ID, ErrIllegalTransition, e.From, e.To, e.Source)
+}
+
+// Unwrap returns ErrIllegalTransition.
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+// Valid reports whether s is a known status.
+func (s Status) Valid() bool {
+	switch s {
+	case Initiated, Accepted, Pending, Completed, Failed, Reversed, Expired:
+		return true
+	}
+	return false
+}
+
+// Final reports whether s is a status that only a reversal can change.
+func (s Status) Final() bool {
+	return s == Completed || s == Failed || s == Reversed
+}
+
+// CanTransition reports whether a transaction in status s may move to
+// status to. Staying in the same status, e.g. on a retried callback, is
+// always allowed.
+func (s Status) CanTransition(to Status) bool {
+	if s == to {
+		return s.Valid()
+	}
+
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition checks that u may move tx to u.Status.
+func transition(tx *Transaction, u Update) error {
+	if tx.Status.CanTransition(u.Status) {
+		return nil
+	}
+	return &TransitionError{ID: tx.ID, From: tx.Status, To: u.Status, Source: u.Source}
+}
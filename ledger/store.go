@@ -0,0 +1,168 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ledger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned when no transaction matches a lookup.
+	ErrNotFound = errors.New("transaction not found")
+
+	// ErrNoID is returned when storing a transaction without an ID.
+	ErrNoID = errors.New("transaction has no id")
+
+	// ErrDuplicate is reported when a payment reuses the third party
+	// conversation ID of another transaction, which is left unchanged.
+	ErrDuplicate = errors.New("duplicate transaction id")
+)
+
+func isNotFound(err error) bool {
+	return err != nil && errors.Contains(err, ErrNotFound)
+}
+
+// Store persists transactions. Implementations return copies, so callers may
+// modify the transactions they get and put them back.
+type Store interface {
+
+	// Put creates or replaces the transaction with the ID of tx.
+	Put(ctx context.Context, tx *Transaction) error
+
+	// Get returns the transaction with ID id or ErrNotFound.
+	Get(ctx context.Context, id string) (*Transaction, error)
+
+	// Lookup returns the transaction whose ID, transaction ID, conversation
+	// ID or reference is ref, or ErrNotFound.
+	Lookup(ctx context.Context, ref string) (*Transaction, error)
+
+	// ByStatus returns the transactions in any of statuses, oldest first.
+	ByStatus(ctx context.Context, statuses ...Status) ([]*Transaction, error)
+
+	// ByDate returns the transactions created in [from, to), oldest first.
+	ByDate(ctx context.Context, from, to time.Time) ([]*Transaction, error)
+}
+
+var _ Store = (*Memory)(nil)
+
+// Memory is a Store that keeps transactions in memory.
+type Memory struct {
+	mu  sync.RWMutex
+	txs map[string]*Transaction
+
+	// refs maps transaction IDs, conversation IDs and references to IDs
+	refs map[string]string
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		txs:  make(map[string]*Transaction),
+		refs: make(map[string]string),
+	}
+}
+
+// Put implements Store.
+func (m *Memory) Put(_ context.Context, tx *Transaction) error {
+	if tx.ID == "" {
+		return ErrNoID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(tx.clone())
+	return nil
+}
+
+func (m *Memory) put(tx *Transaction) {
+	m.txs[tx.ID] = tx
+	for _, ref := range []string{tx.TransactionID, tx.ConversationID, tx.Reference} {
+		if ref != "" {
+			m.refs[ref] = tx.ID
+		}
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, id string) (*Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tx, ok := m.txs[id]
+	if !ok {
+		return nil, errors.Wrap(ErrNotFound, errors.New(id))
+	}
+	return tx.clone(), nil
+}
+
+// Lookup implements Store.
+func (m *Memory) Lookup(ctx context.Context, ref string) (*Transaction, error) {
+	m.mu.RLock()
+	id, ok := m.refs[ref]
+	m.mu.RUnlock()
+
+	if !ok {
+		id = ref
+	}
+	return m.Get(ctx, id)
+}
+
+// ByStatus implements Store.
+func (m *Memory) ByStatus(_ context.Context, statuses ...Status) ([]*Transaction, error) {
+	return m.filter(func(tx *Transaction) bool {
+		for _, s := range statuses {
+			if tx.Status == s {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// ByDate implements Store.
+func (m *Memory) ByDate(_ context.Context, from, to time.Time) ([]*Transaction, error) {
+	return m.filter(func(tx *Transaction) bool {
+		return !tx.CreatedAt.Before(from) && tx.CreatedAt.Before(to)
+	}), nil
+}
+
+// filter returns copies of the transactions matching fn, oldest first.
+func (m *Memory) filter(fn func(*Transaction) bool) []*Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var txs []*Transaction
+	for _, tx := range m.txs {
+		if fn(tx) {
+			txs = append(txs, tx.clone())
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].CreatedAt.Equal(txs[j].CreatedAt) {
+			return txs[i].ID < txs[j].ID
+		}
+		return txs[i].CreatedAt.Before(txs[j].CreatedAt)
+	})
+	return txs
+}
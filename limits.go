@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+
+	"github.com/mobilemoney/mpesa/pkg/ratelimit"
+)
+
+// AllOperations selects the limit applied to operations without a limit of
+// their own. It is a single limiter shared by all of them, e.g. a MaxInFlight
+// of 4 allows 4 requests in flight in total, not 4 per operation. Session
+// requests are only limited by a limit set for OpGetSession.
+const AllOperations = "*"
+
+// Limit bounds the rate and concurrency of an operation type.
+type Limit struct {
+
+	// Rate is the number of requests per second allowed, zero for no limit.
+	Rate float64
+
+	// Burst is the number of requests allowed to exceed Rate momentarily.
+	Burst int
+
+	// MaxInFlight is the number of concurrent requests allowed, zero for
+	// no limit.
+	MaxInFlight int
+}
+
+// LimiterState is a snapshot of an operation limiter, for monitoring.
+type LimiterState struct {
+	Limit Limit
+
+	// Tokens currently available in the rate limiter bucket.
+	Tokens float64
+
+	// InFlight is the number of requests currently being sent.
+	InFlight int
+
+	// Waiting is the number of requests blocked on the in-flight limit.
+	Waiting int
+}
+
+type limiter struct {
+	limit  Limit
+	bucket *ratelimit.TokenBucket
+	sem    *ratelimit.Semaphore
+}
+
+func newLimiter(l Limit) *limiter {
+	lim := &limiter{limit: l}
+
+	if l.Rate > 0 {
+		lim.bucket = ratelimit.NewTokenBucket(l.Rate, l.Burst)
+	}
+
+	if l.MaxInFlight > 0 {
+		lim.sem = ratelimit.NewSemaphore(l.MaxInFlight)
+	}
+
+	return lim
+}
+
+// acquire blocks until the request may be sent or ctx is done. The returned
+// function must be called once the request has completed.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l.sem != nil {
+		if err := l.sem.Acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.bucket != nil {
+		if err := l.bucket.Wait(ctx); err != nil {
+			if l.sem != nil {
+				l.sem.Release()
+			}
+			return nil, err
+		}
+	}
+
+	return func() {
+		if l.sem != nil {
+			l.sem.Release()
+		}
+	}, nil
+}
+
+func (l *limiter) state() LimiterState {
+	s := LimiterState{Limit: l.limit}
+
+	if l.bucket != nil {
+		s.Tokens = l.bucket.Tokens()
+	}
+
+	if l.sem != nil {
+		s.InFlight = l.sem.InFlight()
+		s.Waiting = l.sem.Waiting()
+	}
+
+	return s
+}
+
+// WithLimit applies l to every request of operation, or to every operation
+// without a limit of its own when operation is AllOperations. Requests
+// block until both a rate limiter token and an in-flight slot are available,
+// or until their context is cancelled. A slot is given back before a request
+// rejected with 401 Unauthorized is resent with a new session key.
+func WithLimit(operation string, l Limit) Option {
+	return func(app *Application) {
+		if app.limiters == nil {
+			app.limiters = make(map[string]*limiter)
+		}
+		app.limiters[operation] = newLimiter(l)
+	}
+}
+
+// limiterFor returns the limiter applied to operation, or nil. Session
+// requests do not fall back to the AllOperations limiter since they are sent
+// on behalf of requests which may hold its slots.
+func (app *Application) limiterFor(operation string) *limiter {
+	if l, ok := app.limiters[operation]; ok || operation == OpGetSession {
+		return l
+	}
+	return app.limiters[AllOperations]
+}
+
+// acquire blocks until a request of operation may be sent or ctx is done. The
+// returned function must be called once the request has completed.
+func (app *Application) acquire(ctx context.Context, operation string) (func(), error) {
+	l := app.limiterFor(operation)
+	if l == nil {
+		return func() {}, nil
+	}
+	return l.acquire(ctx)
+}
+
+// LimiterStates returns the current state of every configured limiter, keyed
+// by operation.
+func (app *Application) LimiterStates() map[string]LimiterState {
+	states := make(map[string]LimiterState, len(app.limiters))
+	for op, l := range app.limiters {
+		states[op] = l.state()
+	}
+	return states
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitSessionRetry(t *testing.T) {
+	cases := []struct {
+		desc     string
+		opts     []mpesa.Option
+		limiters []string
+	}{
+		{
+			desc:     "all operations limit",
+			opts:     []mpesa.Option{mpesa.WithLimit(mpesa.AllOperations, mpesa.Limit{MaxInFlight: 1})},
+			limiters: []string{mpesa.AllOperations},
+		},
+		{
+			desc: "payment and session limits",
+			opts: []mpesa.Option{
+				mpesa.WithLimit(mpesa.OpC2B, mpesa.Limit{MaxInFlight: 1}),
+				mpesa.WithLimit(mpesa.OpGetSession, mpesa.Limit{MaxInFlight: 1}),
+			},
+			limiters: []string{mpesa.OpC2B, mpesa.OpGetSession},
+		},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI().On(mpesa.OpC2B,
+			mpesatest.Reply{Status: http.StatusUnauthorized, Body: unauthorized},
+			mpesatest.Reply{Status: http.StatusCreated, Body: accepted},
+		)
+		app := mpesatest.NewApplication(t, api, append(tc.opts, mpesa.WithSessionRetry())...)
+
+		var (
+			resp *mpesa.TransactionResp
+			err  error
+			done = make(chan struct{})
+		)
+		go func() {
+			defer close(done)
+			resp, err = app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"})
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected the payment not to wait for its own limiter slot\n", tc.desc)
+		}
+
+		assert.NoError(t, err, fmt.Sprintf("%s: expected the payment to be resent with a new session key\n", tc.desc))
+		if assert.NotNil(t, resp.Meta, fmt.Sprintf("%s: expected response meta\n", tc.desc)) {
+			assert.Equal(t, 1, resp.Meta.Retries, fmt.Sprintf("%s: expected 1 retry got %d\n", tc.desc, resp.Meta.Retries))
+		}
+
+		states := app.LimiterStates()
+		assert.Len(t, states, len(tc.limiters), fmt.Sprintf("%s: expected %d limiters\n", tc.desc, len(tc.limiters)))
+		for _, op := range tc.limiters {
+			assert.Equal(t, 0, states[op].InFlight, fmt.Sprintf("%s: expected the %s slots to be given back\n", tc.desc, op))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultMetaBodyLimit is the default size of the raw response bodies kept
+// in Meta.
+const DefaultMetaBodyLimit = 64 << 10
+
+// Meta is the HTTP exchange an operation result was decoded from, kept as
+// evidence for support requests. It is left out of JSON, and so of journals.
+type Meta struct {
+	// StatusCode and Header are those of the last response received.
+	StatusCode int
+	Header     http.Header
+
+	// Body is the raw response body, cut at the application's body limit,
+	// in which case Truncated is set.
+	Body      []byte
+	Truncated bool
+
+	// Latency is the time from sending the request to reading the response,
+	// retries included.
+	Latency time.Duration
+
+	// Retries counts the attempts after the first one, e.g. a request
+	// rejected with 401 Unauthorized is retried with a new session key.
+	Retries int
+
+	// SessionKeyAge is the age of the session key the last attempt was
+	// authorised with.
+	SessionKeyAge time.Duration
+}
+
+// metaSetter is implemented by the results carrying a Meta.
+type metaSetter interface {
+	setMeta(m *Meta)
+}
+
+func (e *ResponseError) setMeta(m *Meta) { e.Meta = m }
+
+// WithMetaBodyLimit keeps up to n bytes of the raw response bodies in Meta,
+// DefaultMetaBodyLimit by default. Zero keeps none.
+func WithMetaBodyLimit(n int) Option {
+	return func(app *Application) {
+		if n >= 0 {
+			app.metaBodyLimit = n
+		}
+	}
+}
+
+// newMeta returns the Meta of a response whose body is body.
+func (app *Application) newMeta(resp *http.Response, body []byte) *Meta {
+	m := &Meta{StatusCode: resp.StatusCode, Header: resp.Header}
+
+	if len(body) > app.metaBodyLimit {
+		body = body[:app.metaBodyLimit]
+		m.Truncated = true
+	}
+	if len(body) > 0 {
+		m.Body = append([]byte(nil), body...)
+	}
+	return m
+}
+
+// sessionKeyAge returns the age of the current session key.
+func (app *Application) sessionKeyAge() time.Duration {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	if app.sessionAt.IsZero() {
+		return 0
+	}
+	return time.Since(app.sessionAt)
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+// reply returns a gateway reply identified by the X-Request-Id header.
+func reply(status int, body string) mpesatest.Reply {
+	return mpesatest.Reply{Status: status, Body: body, Header: http.Header{"X-Request-Id": {"req-1"}}}
+}
+
+func TestMeta(t *testing.T) {
+	const rejected = `{"output_ResponseCode":"INS-10","output_ResponseDesc":"Duplicate Transaction"}`
+
+	cases := []struct {
+		desc      string
+		replies   []mpesatest.Reply
+		limit     int
+		status    int
+		body      string
+		truncated bool
+		retries   int
+		err       bool
+	}{
+		{
+			desc:    "accepted",
+			replies: []mpesatest.Reply{reply(http.StatusCreated, accepted)},
+			limit:   mpesa.DefaultMetaBodyLimit,
+			status:  http.StatusCreated,
+			body:    accepted,
+		},
+		{
+			desc:    "accepted with a new session key",
+			replies: []mpesatest.Reply{reply(http.StatusUnauthorized, unauthorized), reply(http.StatusCreated, accepted)},
+			limit:   mpesa.DefaultMetaBodyLimit,
+			status:  http.StatusCreated,
+			body:    accepted,
+			retries: 1,
+		},
+		{
+			desc:    "rejected",
+			replies: []mpesatest.Reply{reply(http.StatusConflict, rejected)},
+			limit:   mpesa.DefaultMetaBodyLimit,
+			status:  http.StatusConflict,
+			body:    rejected,
+			err:     true,
+		},
+		{
+			desc:      "body over the limit",
+			replies:   []mpesatest.Reply{reply(http.StatusCreated, accepted)},
+			limit:     10,
+			status:    http.StatusCreated,
+			body:      accepted[:10],
+			truncated: true,
+		},
+		{
+			desc:      "no body kept",
+			replies:   []mpesatest.Reply{reply(http.StatusCreated, accepted)},
+			limit:     0,
+			status:    http.StatusCreated,
+			body:      "",
+			truncated: true,
+		},
+	}
+
+	for _, tc := range cases {
+		app := mpesatest.NewApplication(t, mpesatest.NewAPI().On(mpesa.OpC2B, tc.replies...),
+			mpesa.WithMetaBodyLimit(tc.limit),
+			mpesa.WithSessionRetry(),
+		)
+
+		resp, err := app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"})
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: expected error %t got %v\n", tc.desc, tc.err, err))
+
+		meta := resp.Meta
+		if !assert.NotNil(t, meta, fmt.Sprintf("%s: expected response meta\n", tc.desc)) {
+			continue
+		}
+		if respErr, ok := err.(*mpesa.ResponseError); ok {
+			assert.Equal(t, meta, respErr.Meta, fmt.Sprintf("%s: expected the error to carry the response meta\n", tc.desc))
+		}
+
+		assert.Equal(t, tc.status, meta.StatusCode, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, meta.StatusCode))
+		assert.Equal(t, "req-1", meta.Header.Get("X-Request-Id"), fmt.Sprintf("%s: expected response headers\n", tc.desc))
+		assert.Equal(t, tc.body, string(meta.Body), fmt.Sprintf("%s: expected body %s got %s\n", tc.desc, tc.body, meta.Body))
+		assert.Equal(t, tc.truncated, meta.Truncated, fmt.Sprintf("%s: expected truncated %t got %t\n", tc.desc, tc.truncated, meta.Truncated))
+		assert.Equal(t, tc.retries, meta.Retries, fmt.Sprintf("%s: expected %d retries got %d\n", tc.desc, tc.retries, meta.Retries))
+		assert.True(t, meta.Latency > 0, fmt.Sprintf("%s: expected the latency to be measured\n", tc.desc))
+		assert.True(t, meta.SessionKeyAge > 0, fmt.Sprintf("%s: expected the session key age to be measured\n", tc.desc))
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import "time"
+
+// Metrics receives instrumentation data from an Application.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+
+	// ObserveRequest records the outcome and latency of a single operation
+	// call. responseCode is the output_ResponseCode returned by the API, or
+	// a synthetic code such as "http_500" or "error" when none was returned.
+	ObserveRequest(operation, responseCode string, latency time.Duration)
+
+	// IncSessionRefresh counts every successfully generated session key.
+	IncSessionRefresh()
+
+	// IncRetry counts every retried attempt of operation.
+	IncRetry(operation string)
+}
+
+var _ Metrics = nopMetrics{}
+
+// nopMetrics is used when no Metrics has been configured.
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(string, string, time.Duration) {}
+
+func (nopMetrics) IncSessionRefresh() {}
+
+func (nopMetrics) IncRetry(string) {}
+
+// WithMetrics reports the Application's operation outcomes, latencies,
+// session refreshes and retries to m.
+func WithMetrics(m Metrics) Option {
+	return func(app *Application) {
+		if m != nil {
+			app.metrics = m
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	pkgerrors "github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// Contract tests of the typed requests and responses against openapi.yaml,
+// they are internal tests so as to cover unexported types.
+
+func loadSpec(t *testing.T) *openapi.Spec {
+	spec, err := openapi.Load("openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := loadSpec(t)
+
+	cases := []struct {
+		desc   string
+		v      interface{}
+		schema string
+		skip   []string
+	}{
+		{desc: "session response", v: getSessionResp{}, schema: "SessionResponse"},
+		{desc: "error response", v: ResponseError{}, schema: "ErrorResponse", skip: []string{"StatusCode"}},
+		{desc: "c2b payment", v: C2BPayment{}, schema: "C2BPaymentRequest"},
+		{desc: "b2c payment", v: B2CPayment{}, schema: "B2CPaymentRequest"},
+		{desc: "b2b payment", v: B2BPayment{}, schema: "B2BPaymentRequest"},
+		{desc: "transaction response", v: TransactionResp{}, schema: "TransactionResponse"},
+		{desc: "reversal", v: Reversal{}, schema: "ReversalRequest"},
+		{desc: "reversal response", v: ReversalResp{}, schema: "ReversalResponse"},
+		{desc: "status query", v: StatusQuery{}, schema: "StatusQuery"},
+		{desc: "status response", v: StatusResp{}, schema: "StatusResponse"},
+		{desc: "beneficiary query", v: BeneficiaryQuery{}, schema: "BeneficiaryQuery"},
+		{desc: "beneficiary response", v: BeneficiaryResp{}, schema: "BeneficiaryResponse"},
+	}
+
+	for _, tc := range cases {
+		errs := spec.Check(tc.schema, tc.v, tc.skip...)
+		assert.Empty(t, errs, fmt.Sprintf("%s: expected %T to match %s got %v\n", tc.desc, tc.v, tc.schema, errs))
+	}
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	spec := loadSpec(t)
+
+	cases := []struct {
+		desc     string
+		op       string
+		method   string
+		path     string
+		request  string
+		response string
+	}{
+		{desc: "session", op: OpGetSession, method: http.MethodGet, path: "getSession/", response: "SessionResponse"},
+		{desc: "c2b payment", op: OpC2B, method: http.MethodPost, path: "c2bPayment/singleStage/", request: "C2BPaymentRequest", response: "TransactionResponse"},
+		{desc: "b2c payment", op: OpB2C, method: http.MethodPost, path: "b2cPayment/", request: "B2CPaymentRequest", response: "TransactionResponse"},
+		{desc: "b2b payment", op: OpB2B, method: http.MethodPost, path: "b2bPayment/", request: "B2BPaymentRequest", response: "TransactionResponse"},
+		{desc: "reversal", op: OpReversal, method: http.MethodPut, path: "reversal/", request: "ReversalRequest", response: "ReversalResponse"},
+		{desc: "status query", op: OpQueryTransactionStatus, method: http.MethodGet, path: "queryTransactionStatus/", request: "StatusQuery", response: "StatusResponse"},
+		{desc: "beneficiary query", op: OpQueryBeneficiaryName, method: http.MethodGet, path: "queryBeneficiaryName/", request: "BeneficiaryQuery", response: "BeneficiaryResponse"},
+	}
+
+	for _, tc := range cases {
+		method, path, op := spec.Operation(tc.op)
+		if !assert.NotNil(t, op, fmt.Sprintf("%s: expected operation %s\n", tc.desc, tc.op)) {
+			continue
+		}
+		assert.Equal(t, tc.method, method, fmt.Sprintf("%s: expected method %s got %s\n", tc.desc, tc.method, method))
+		assert.Equal(t, "/"+tc.path, path, fmt.Sprintf("%s: expected path /%s got %s\n", tc.desc, tc.path, path))
+
+		if tc.request != "" {
+			var request string
+			if method == http.MethodGet {
+				// GET requests send the fields of their schema as query parameters
+				schema, _ := spec.Schema(tc.request)
+				request = fmt.Sprint(properties(schema))
+				assert.Equal(t, request, fmt.Sprint(queryParams(t, spec, op)), fmt.Sprintf("%s: expected query parameters %s\n", tc.desc, request))
+			} else {
+				request = openapi.SchemaName(op.RequestBody.Content["application/json"].Schema)
+				assert.Equal(t, tc.request, request, fmt.Sprintf("%s: expected request %s got %s\n", tc.desc, tc.request, request))
+			}
+		}
+
+		var success, failure string
+		for status, resp := range op.Responses {
+			resp, err := spec.Response(resp)
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected error resolving response %s\n", tc.desc, status))
+			name := openapi.SchemaName(resp.Content["application/json"].Schema)
+			if strings.HasPrefix(status, "2") {
+				success = name
+			} else if failure == "" || failure == name {
+				failure = name
+			} else {
+				t.Errorf("%s: expected every error response to be an ErrorResponse got %s\n", tc.desc, name)
+			}
+		}
+		assert.Equal(t, tc.response, success, fmt.Sprintf("%s: expected response %s got %s\n", tc.desc, tc.response, success))
+		assert.Equal(t, "ErrorResponse", failure, fmt.Sprintf("%s: expected error response ErrorResponse got %s\n", tc.desc, failure))
+	}
+}
+
+func TestOpenAPIResponseCodes(t *testing.T) {
+	spec := loadSpec(t)
+
+	codes, err := spec.Schema("ResponseCode")
+	assert.Nil(t, err)
+	assert.Equal(t, len(codes.Enum), len(codes.EnumDescriptions), "expected a description for every response code")
+	assert.Equal(t, len(codes.Enum), len(codes.HTTPStatuses), "expected an HTTP status for every response code")
+	assert.Contains(t, codes.Enum, CodeSuccess)
+}
+
+func TestGeneratedTypes(t *testing.T) {
+	src, err := loadSpec(t).Generate("mpesa", "openapi.yaml")
+	assert.Nil(t, err, "unexpected error generating types")
+
+	gen, err := ioutil.ReadFile("types_gen.go")
+	assert.Nil(t, err)
+	assert.Equal(t, string(src), string(gen), "types_gen.go is out of date, run go generate")
+}
+
+func TestValidate(t *testing.T) {
+	payment := C2BPayment{
+		Amount:                   "10.00",
+		Country:                  "TZN",
+		Currency:                 "TZS",
+		CustomerMSISDN:           "255744553111",
+		ServiceProviderCode:      "000000",
+		ThirdPartyConversationID: "asv02e5958774f7ba228d83d0d689761",
+		TransactionReference:     "T1234C",
+		PurchasedItemsDesc:       "Shoes",
+	}
+
+	with := func(f func(p *C2BPayment)) C2BPayment {
+		p := payment
+		f(&p)
+		return p
+	}
+
+	cases := []struct {
+		desc  string
+		req   validator
+		field string
+	}{
+		{
+			desc: "valid payment",
+			req:  payment,
+		},
+		{
+			desc:  "missing amount",
+			req:   with(func(p *C2BPayment) { p.Amount = "" }),
+			field: "input_Amount",
+		},
+		{
+			desc:  "amount with three decimals",
+			req:   with(func(p *C2BPayment) { p.Amount = "10.001" }),
+			field: "input_Amount",
+		},
+		{
+			desc:  "unknown currency",
+			req:   with(func(p *C2BPayment) { p.Currency = "USD" }),
+			field: "input_Currency",
+		},
+		{
+			desc:  "short msisdn",
+			req:   with(func(p *C2BPayment) { p.CustomerMSISDN = "0744553111" }),
+			field: "input_CustomerMSISDN",
+		},
+		{
+			desc:  "long reference",
+			req:   with(func(p *C2BPayment) { p.TransactionReference = "T1234C-T1234C-T1234C" + "X" }),
+			field: "input_TransactionReference",
+		},
+		{
+			desc:  "beneficiary query without kyc query type",
+			req:   BeneficiaryQuery{CustomerMSISDN: "255744553111", Country: "TZN", ServiceProviderCode: "000000", ThirdPartyConversationID: "abc"},
+			field: "input_KycQueryType",
+		},
+		{
+			desc: "status query",
+			req:  StatusQuery{QueryReference: "5C1400CVRO", Country: "GHA", ServiceProviderCode: "00000", ThirdPartyConversationID: "abc"},
+		},
+	}
+
+	for _, tc := range cases {
+		err := tc.req.Validate()
+
+		var verr *ValidationError
+		if tc.field == "" {
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %v\n", tc.desc, err))
+			continue
+		}
+		if assert.True(t, errors.As(err, &verr), fmt.Sprintf("%s: expected a *ValidationError got %v\n", tc.desc, err)) {
+			assert.Equal(t, tc.field, verr.Field, fmt.Sprintf("%s: expected %s got %s\n", tc.desc, tc.field, verr.Field))
+			assert.True(t, errors.Is(err, ErrInvalidRequest), fmt.Sprintf("%s: expected %s to wrap ErrInvalidRequest\n", tc.desc, err))
+			assert.True(t, pkgerrors.Contains(err, ErrInvalidRequest), fmt.Sprintf("%s: expected %s to contain ErrInvalidRequest\n", tc.desc, err))
+		}
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	// the request fails before the application needs a session or a client
+	app := &Application{market: VodacomTanzania, ServiceProviderCode: "000000", validate: true}
+
+	_, err := app.C2B(context.Background(), C2BPayment{Amount: "ten", CustomerMSISDN: "255744553111", TransactionReference: "ref", PurchasedItemsDesc: "Shoes"})
+
+	var verr *ValidationError
+	if assert.True(t, errors.As(err, &verr), fmt.Sprintf("expected a *ValidationError got %v", err)) {
+		assert.Equal(t, "input_Amount", verr.Field)
+	}
+}
+
+// properties returns the sorted property names of schema.
+func properties(schema *openapi.Schema) []string {
+	var names []string
+	for _, p := range schema.Properties {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// queryParams returns the sorted query parameter names of op.
+func queryParams(t *testing.T, spec *openapi.Spec, op *openapi.Operation) []string {
+	var names []string
+	for _, p := range op.Parameters {
+		p, err := spec.Parameter(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.In == "query" {
+			names = append(names, p.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,383 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package outbox forwards callbacks received at the edge to internal
+// services. Every accepted callback is persisted to a durable outbox, one
+// message per endpoint, before it is acknowledged, then delivered with
+// retries and exponential backoff. Messages that run out of attempts move to
+// the dead letters, from where they can be listed and replayed.
+//
+//	store, err := outbox.OpenFile("/var/lib/mpesa/outbox.jsonl")
+//	f := &outbox.Forwarder{
+//		Store: store,
+//		Endpoints: []outbox.Endpoint{
+//			{Name: "wallet", URL: "http://wallet.internal/mpesa"},
+//			{Name: "ledger", URL: "http://ledger.internal/mpesa"},
+//		},
+//	}
+//	go f.Run(ctx)
+//	allow, err := callback.NewAllowlist(trustedSources, nil)
+//	http.Handle("/mpesa/callback", &callback.Handler{Verifiers: []callback.Verifier{allow}, Dispatch: f.Dispatch})
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+const (
+	defMaxAttempts  = 8
+	defMinBackoff   = time.Second
+	defMaxBackoff   = 10 * time.Minute
+	defPollInterval = time.Second
+	defTimeout      = 10 * time.Second
+)
+
+// MessageIDHeader carries the message ID, so that endpoints can recognise
+// redelivered messages.
+const MessageIDHeader = "X-Outbox-Message-Id"
+
+// Endpoint is an internal service callbacks are forwarded to.
+type Endpoint struct {
+	Name string
+	URL  string
+
+	// Header is added to every delivery, e.g. for authentication.
+	Header http.Header
+}
+
+// Forwarder delivers the messages of a Store to Endpoints.
+//
+// A delivery succeeds on any 2xx response. 4xx responses other than 408 and
+// 429 cannot succeed on retry, so their messages move to the dead letters
+// at once; other failures are retried up to MaxAttempts times.
+type Forwarder struct {
+	Store     Store
+	Endpoints []Endpoint
+
+	// Client delivers messages, an http.Client with a 10 second timeout
+	// when nil.
+	Client *http.Client
+
+	// MaxAttempts defaults to 8.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the delay between attempts, they
+	// default to a second and 10 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// PollInterval between checks for due messages, defaults to a second.
+	PollInterval time.Duration
+
+	// Logger logs failed deliveries, the log package's standard logger
+	// when nil.
+	Logger *log.Logger
+
+	once   sync.Once
+	client *http.Client
+	wake   chan struct{}
+
+	// mu serializes flushes, so that no message is delivered twice at once
+	mu sync.Mutex
+}
+
+func (f *Forwarder) init() {
+	f.once.Do(func() {
+		f.wake = make(chan struct{}, 1)
+		f.client = f.Client
+		if f.client == nil {
+			f.client = &http.Client{Timeout: defTimeout}
+		}
+	})
+}
+
+// Dispatch persists cb for delivery to every endpoint, it is a
+// callback.DispatchFunc.
+func (f *Forwarder) Dispatch(ctx context.Context, cb *callback.Callback) error {
+	f.init()
+
+	now := time.Now().UTC()
+	msgs := make([]Message, len(f.Endpoints))
+	for i, ep := range f.Endpoints {
+		msgs[i] = Message{
+			ID:          newID(),
+			Endpoint:    ep.Name,
+			Body:        cb.Body,
+			State:       Queued,
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+	}
+
+	if err := f.Store.Put(ctx, msgs...); err != nil {
+		return err
+	}
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers due messages until ctx is cancelled and returns ctx.Err().
+func (f *Forwarder) Run(ctx context.Context) error {
+	f.init()
+
+	interval := f.PollInterval
+	if interval <= 0 {
+		interval = defPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Flush(ctx); err != nil && ctx.Err() == nil {
+			f.logger().Printf("outbox: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-f.wake:
+		}
+	}
+}
+
+// Flush attempts the delivery of every due message once. Endpoints are
+// delivered to concurrently, so that a slow endpoint does not hold up the
+// others, and the messages of each endpoint in turn.
+func (f *Forwarder) Flush(ctx context.Context) error {
+	f.init()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msgs, err := f.Store.List(ctx, Queued)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	due := make(map[string][]Message)
+	for _, msg := range msgs {
+		if !now.Before(msg.NextAttempt) {
+			due[msg.Endpoint] = append(due[msg.Endpoint], msg)
+		}
+	}
+
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+
+	for _, msgs := range due {
+		wg.Add(1)
+		go func(msgs []Message) {
+			defer wg.Done()
+
+			if err := f.flush(ctx, msgs); err != nil {
+				errMu.Lock()
+				if first == nil {
+					first = err
+				}
+				errMu.Unlock()
+			}
+		}(msgs)
+	}
+	wg.Wait()
+
+	return first
+}
+
+// flush attempts the delivery of msgs, all to the same endpoint, in turn.
+func (f *Forwarder) flush(ctx context.Context, msgs []Message) error {
+	for _, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := f.Store.Put(ctx, f.deliver(ctx, msg)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver attempts the delivery of msg and returns its new state. A delivery
+// cut short by the cancellation of ctx does not count as an attempt.
+func (f *Forwarder) deliver(ctx context.Context, msg Message) Message {
+	retry, err := f.post(ctx, msg)
+	if err == nil {
+		msg.Attempts++
+		msg.State = Delivered
+		msg.LastError = ""
+		return msg
+	}
+
+	if ctx.Err() != nil {
+		return msg
+	}
+	msg.Attempts++
+
+	f.logger().Printf("outbox: delivery %d of %s to %s failed: %v", msg.Attempts, msg.ID, msg.Endpoint, err)
+	msg.LastError = err.Error()
+
+	maxAttempts := f.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defMaxAttempts
+	}
+
+	if !retry || msg.Attempts >= maxAttempts {
+		msg.State = Dead
+		return msg
+	}
+
+	msg.NextAttempt = time.Now().UTC().Add(f.backoff(msg.Attempts))
+	return msg
+}
+
+// post sends msg to its endpoint and reports whether a failure may be retried.
+func (f *Forwarder) post(ctx context.Context, msg Message) (bool, error) {
+	var ep *Endpoint
+	for i := range f.Endpoints {
+		if f.Endpoints[i].Name == msg.Endpoint {
+			ep = &f.Endpoints[i]
+			break
+		}
+	}
+
+	if ep == nil {
+		return false, fmt.Errorf("unknown endpoint %q", msg.Endpoint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return false, err
+	}
+
+	for k, vs := range ep.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(MessageIDHeader, msg.ID)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return false, fmt.Errorf("%s", resp.Status)
+	}
+	return true, fmt.Errorf("%s", resp.Status)
+}
+
+// backoff returns the delay after attempt.
+func (f *Forwarder) backoff(attempt int) time.Duration {
+	min, max := f.MinBackoff, f.MaxBackoff
+	if min <= 0 {
+		min = defMinBackoff
+	}
+	if max <= 0 {
+		max = defMaxBackoff
+	}
+
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// DeadLetters returns the messages that ran out of delivery attempts.
+func (f *Forwarder) DeadLetters(ctx context.Context) ([]Message, error) {
+	return f.Store.List(ctx, Dead)
+}
+
+// Replay queues the dead letters ids for immediate delivery with a fresh
+// set of attempts.
+func (f *Forwarder) Replay(ctx context.Context, ids ...string) error {
+	f.init()
+
+	msgs := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		msg, err := f.Store.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if msg.State != Dead {
+			return errors.Wrap(ErrNotDead, errors.New(id))
+		}
+
+		msg.State = Queued
+		msg.Attempts = 0
+		msg.NextAttempt = time.Now().UTC()
+		msgs = append(msgs, msg)
+	}
+
+	if err := f.Store.Put(ctx, msgs...); err != nil {
+		return err
+	}
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (f *Forwarder) logger() *log.Logger {
+	if f.Logger != nil {
+		return f.Logger
+	}
+	return log.New(log.Writer(), log.Prefix(), log.Flags())
+}
+
+// newID returns a random message ID.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa/callback"
+	"github.com/mobilemoney/mpesa/outbox"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// endpoint responds with the scripted statuses in turn, then with 200.
+type endpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, _ := ioutil.ReadAll(r.Body)
+	e.bodies = append(e.bodies, string(b))
+
+	status := http.StatusOK
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (e *endpoint) deliveries() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.bodies)
+}
+
+// flush flushes f until no message is queued.
+func flush(t *testing.T, f *outbox.Forwarder) {
+	for i := 0; i < 10; i++ {
+		if err := f.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if queued, _ := f.Store.List(context.Background(), outbox.Queued); len(queued) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	cases := []struct {
+		desc       string
+		statuses   []int
+		deliveries int
+		dead       int
+	}{
+		{desc: "deliver at first attempt", deliveries: 1},
+		{desc: "retry server errors", statuses: []int{500, 503}, deliveries: 3},
+		{desc: "retry rate limiting", statuses: []int{429}, deliveries: 2},
+		{desc: "dead letter after max attempts", statuses: []int{500, 500, 500}, deliveries: 3, dead: 1},
+		{desc: "dead letter client errors at once", statuses: []int{400}, deliveries: 1, dead: 1},
+	}
+
+	for _, tc := range cases {
+		ep := &endpoint{statuses: tc.statuses}
+		srv := httptest.NewServer(ep)
+
+		f := &outbox.Forwarder{
+			Store:       outbox.NewMemory(),
+			Endpoints:   []outbox.Endpoint{{Name: "wallet", URL: srv.URL}},
+			MaxAttempts: 3,
+			MinBackoff:  time.Millisecond,
+			MaxBackoff:  time.Millisecond,
+			Logger:      log.New(ioutil.Discard, "", 0),
+		}
+
+		err := f.Dispatch(context.Background(), &callback.Callback{Body: []byte(`{"input_TransactionID":"tx-1"}`)})
+		assert.Nil(t, err, fmt.Sprintf("%s: expected no error got %v\n", tc.desc, err))
+
+		flush(t, f)
+
+		assert.Equal(t, tc.deliveries, ep.deliveries(), fmt.Sprintf("%s: expected %d deliveries got %d\n", tc.desc, tc.deliveries, ep.deliveries()))
+
+		dead, _ := f.DeadLetters(context.Background())
+		assert.Equal(t, tc.dead, len(dead), fmt.Sprintf("%s: expected %d dead letters got %d\n", tc.desc, tc.dead, len(dead)))
+
+		srv.Close()
+	}
+}
+
+func TestFlushSlowEndpoint(t *testing.T) {
+	unblock := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer slow.Close()
+	defer close(unblock)
+
+	ep := &endpoint{}
+	fast := httptest.NewServer(ep)
+	defer fast.Close()
+
+	f := &outbox.Forwarder{
+		Store: outbox.NewMemory(),
+		Endpoints: []outbox.Endpoint{
+			{Name: "slow", URL: slow.URL},
+			{Name: "wallet", URL: fast.URL},
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f.Dispatch(ctx, &callback.Callback{Body: []byte(`{}`)})
+	go f.Flush(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for ep.deliveries() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, ep.deliveries(), "slow endpoint: expected the other endpoint to be delivered to\n")
+}
+
+func TestFlushCancelled(t *testing.T) {
+	arrived, unblock := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	f := &outbox.Forwarder{
+		Store:       outbox.NewMemory(),
+		Endpoints:   []outbox.Endpoint{{Name: "wallet", URL: srv.URL}},
+		MaxAttempts: 1,
+		Logger:      log.New(ioutil.Discard, "", 0),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.Dispatch(ctx, &callback.Callback{Body: []byte(`{}`)})
+
+	done := make(chan struct{})
+	go func() {
+		f.Flush(ctx)
+		close(done)
+	}()
+	<-arrived
+	cancel()
+	<-done
+
+	queued, _ := f.Store.List(context.Background(), outbox.Queued)
+	if assert.Equal(t, 1, len(queued), "cancelled delivery: expected the message to stay queued\n") {
+		assert.Equal(t, 0, queued[0].Attempts, "cancelled delivery: expected no attempt to be counted\n")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ep := &endpoint{statuses: []int{500}}
+	srv := httptest.NewServer(ep)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	store, err := outbox.OpenFile(path)
+	assert.Nil(t, err, fmt.Sprintf("open: expected no error got %v\n", err))
+	defer store.Close()
+
+	f := &outbox.Forwarder{
+		Store:       store,
+		Endpoints:   []outbox.Endpoint{{Name: "wallet", URL: srv.URL}},
+		MaxAttempts: 1,
+		Logger:      log.New(ioutil.Discard, "", 0),
+	}
+
+	f.Dispatch(context.Background(), &callback.Callback{Body: []byte(`{}`)})
+	flush(t, f)
+
+	dead, _ := f.DeadLetters(context.Background())
+	assert.Equal(t, 1, len(dead), fmt.Sprintf("dead letter: expected 1 dead letter got %d\n", len(dead)))
+
+	cases := []struct {
+		desc string
+		id   string
+		err  error
+	}{
+		{desc: "replay unknown message", id: "unknown", err: outbox.ErrNotFound},
+		{desc: "replay dead letter", id: dead[0].ID},
+		{desc: "replay delivered message", id: dead[0].ID, err: outbox.ErrNotFound},
+	}
+
+	for _, tc := range cases {
+		err := f.Replay(context.Background(), tc.id)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+		flush(t, f)
+	}
+
+	assert.Equal(t, 2, ep.deliveries(), fmt.Sprintf("replay: expected 2 deliveries got %d\n", ep.deliveries()))
+
+	reopened, err := outbox.OpenFile(path)
+	assert.Nil(t, err, fmt.Sprintf("reopen: expected no error got %v\n", err))
+	defer reopened.Close()
+
+	dead, _ = reopened.List(context.Background(), outbox.Dead)
+	assert.Equal(t, 0, len(dead), fmt.Sprintf("reopen: expected no dead letters got %d\n", len(dead)))
+}
+
+func TestFileTornLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	store, err := outbox.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(ctx, outbox.Message{ID: "a", State: outbox.Queued})
+	store.Close()
+
+	// a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"b","endp`)
+	f.Close()
+
+	store, err = outbox.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(ctx, outbox.Message{ID: "c", State: outbox.Queued})
+	store.Close()
+
+	reopened, err := outbox.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	for _, id := range []string{"a", "c"} {
+		_, err := reopened.Get(ctx, id)
+		assert.Nil(t, err, fmt.Sprintf("expected message %s to be kept got %v\n", id, err))
+	}
+}
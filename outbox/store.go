@@ -0,0 +1,212 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/internal/jsonl"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned for unknown messages.
+	ErrNotFound = errors.New("message not found")
+
+	// ErrNotDead is returned when replaying a message that is not a dead letter.
+	ErrNotDead = errors.New("message is not a dead letter")
+)
+
+// State of a message.
+type State string
+
+const (
+	// Queued messages are waiting for delivery.
+	Queued State = "queued"
+
+	// Dead messages ran out of delivery attempts.
+	Dead State = "dead"
+
+	// Delivered messages have been delivered and are forgotten.
+	Delivered State = "delivered"
+)
+
+// Message is a callback to deliver to an endpoint.
+type Message struct {
+	ID       string          `json:"id"`
+	Endpoint string          `json:"endpoint"`
+	Body     json.RawMessage `json:"body"`
+	State    State           `json:"state"`
+
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store persists messages. Put must be durable before it returns, since a
+// callback is acknowledged once its messages are put.
+type Store interface {
+
+	// Put creates or replaces messages, by ID. Delivered messages are removed.
+	Put(ctx context.Context, msgs ...Message) error
+
+	// Get returns the message with ID id or ErrNotFound.
+	Get(ctx context.Context, id string) (Message, error)
+
+	// List returns the messages in state, oldest first.
+	List(ctx context.Context, state State) ([]Message, error)
+}
+
+var _ Store = (*Memory)(nil)
+
+// Memory is a Store that keeps messages in memory, for tests and for
+// processes that can afford to lose undelivered callbacks.
+type Memory struct {
+	mu   sync.Mutex
+	msgs map[string]Message
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{msgs: make(map[string]Message)}
+}
+
+// Put implements Store.
+func (m *Memory) Put(_ context.Context, msgs ...Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(msgs)
+	return nil
+}
+
+func (m *Memory) put(msgs []Message) {
+	for _, msg := range msgs {
+		if msg.State == Delivered {
+			delete(m.msgs, msg.ID)
+			continue
+		}
+		m.msgs[msg.ID] = msg
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, id string) (Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.msgs[id]
+	if !ok {
+		return Message{}, errors.Wrap(ErrNotFound, errors.New(id))
+	}
+	return msg, nil
+}
+
+// List implements Store.
+func (m *Memory) List(_ context.Context, state State) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var msgs []Message
+	for _, msg := range m.msgs {
+		if msg.State == state {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
+			return msgs[i].ID < msgs[j].ID
+		}
+		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+	})
+	return msgs, nil
+}
+
+var _ Store = (*File)(nil)
+
+// File is a Store backed by an append only JSON lines file, holding the
+// messages in memory. Each Put appends the messages and syncs the file
+// before returning; the last line written for an ID wins.
+type File struct {
+	*Memory
+
+	f *jsonl.File
+}
+
+// OpenFile opens, or creates, the outbox file at path and reads the
+// messages recorded in it.
+func OpenFile(path string) (*File, error) {
+	s := &File{Memory: NewMemory()}
+
+	f, err := jsonl.Open(path, func(line []byte) {
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err == nil && msg.ID != "" {
+			s.Memory.put([]Message{msg})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+
+	return s, nil
+}
+
+// Put implements Store.
+func (s *File) Put(_ context.Context, msgs ...Message) error {
+	vs := make([]interface{}, len(msgs))
+	for i := range msgs {
+		vs[i] = msgs[i]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Append(vs...); err != nil {
+		return err
+	}
+
+	s.Memory.put(msgs)
+	return nil
+}
+
+// Compact rewrites the file with the queued and dead messages only.
+func (s *File) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Compact(func(enc *json.Encoder) error {
+		for _, msg := range s.msgs {
+			if err := enc.Encode(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the outbox file.
+func (s *File) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package breaker implements the circuit breaker guarding Application
+// requests against a degraded OpenAPI gateway.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	ErrOpen = errors.New("circuit breaker is open")
+)
+
+// State of a Breaker.
+type State int
+
+const (
+	// Closed lets every request through.
+	Closed State = iota
+
+	// Open rejects every request until the open timeout elapses.
+	Open
+
+	// HalfOpen lets a limited number of probe requests through to find out
+	// whether the gateway has recovered.
+	HalfOpen
+)
+
+var stateName = map[State]string{
+	Closed:   "closed",
+	Open:     "open",
+	HalfOpen: "half-open",
+}
+
+func (s State) String() string {
+	return stateName[s]
+}
+
+// Result is the outcome of a request reported back to a Breaker.
+type Result int
+
+const (
+	// Success resets the consecutive failure count.
+	Success Result = iota
+
+	// Failure counts towards opening the breaker.
+	Failure
+
+	// Ignored releases the request without affecting the breaker, e.g.
+	// when the caller cancelled it.
+	Ignored
+)
+
+const (
+	defFailureThreshold = 5
+	defOpenTimeout      = 30 * time.Second
+	defHalfOpenProbes   = 1
+)
+
+// Settings configures a Breaker. Zero values are replaced by defaults.
+type Settings struct {
+
+	// FailureThreshold is the number of consecutive failures that opens
+	// the breaker. Defaults to 5.
+	FailureThreshold int
+
+	// OpenTimeout is how long the breaker stays open before probing.
+	// Defaults to 30 seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of consecutive successful probes needed
+	// to close the breaker again, and the number of probes allowed
+	// concurrently while half-open. Defaults to 1.
+	HalfOpenProbes int
+
+	// OnStateChange, if set, is called on every state transition.
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a consecutive failure circuit breaker.
+type Breaker struct {
+	settings Settings
+	now      func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+}
+
+// New returns a closed Breaker.
+func New(s Settings) *Breaker {
+	if s.FailureThreshold <= 0 {
+		s.FailureThreshold = defFailureThreshold
+	}
+
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = defOpenTimeout
+	}
+
+	if s.HalfOpenProbes <= 0 {
+		s.HalfOpenProbes = defHalfOpenProbes
+	}
+
+	return &Breaker{settings: s, now: time.Now}
+}
+
+// Allow reports whether a request may proceed. When it may, done must be
+// called with the request outcome; otherwise ErrOpen is returned.
+func (b *Breaker) Allow() (done func(Result), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.setState(HalfOpen)
+	}
+
+	switch b.state {
+	case Open:
+		return nil, ErrOpen
+
+	case HalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			return nil, ErrOpen
+		}
+		b.probes++
+		return b.doneFunc(true), nil
+	}
+
+	return b.doneFunc(false), nil
+}
+
+func (b *Breaker) doneFunc(probe bool) func(Result) {
+	var once sync.Once
+	return func(r Result) {
+		once.Do(func() { b.record(probe, r) })
+	}
+}
+
+func (b *Breaker) record(probe bool, r Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probes--
+	}
+
+	if r == Ignored {
+		return
+	}
+	success := r == Success
+
+	switch b.state {
+	case Closed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.setState(Open)
+		}
+
+	case HalfOpen:
+		if !probe {
+			// started before the breaker opened, says nothing about recovery
+			return
+		}
+		if !success {
+			b.setState(Open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenProbes {
+			b.setState(Closed)
+		}
+	}
+}
+
+func (b *Breaker) setState(to State) {
+	from := b.state
+	if from == to {
+		return
+	}
+
+	b.state = to
+	b.failures = 0
+	b.successes = 0
+
+	if to == Open {
+		b.openedAt = b.now()
+	}
+
+	if b.settings.OnStateChange != nil {
+		go b.settings.OnStateChange(from, to)
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package breaker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/breaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func fail(t *testing.T, b *breaker.Breaker, n int) {
+	for i := 0; i < n; i++ {
+		done, err := b.Allow()
+		assert.Nil(t, err, "expected request to be allowed")
+		done(breaker.Failure)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := breaker.New(breaker.Settings{FailureThreshold: 3, OpenTimeout: time.Hour})
+
+	fail(t, b, 2)
+	assert.Equal(t, breaker.Closed, b.State())
+
+	done, err := b.Allow()
+	assert.Nil(t, err)
+	done(breaker.Success)
+	fail(t, b, 2)
+	assert.Equal(t, breaker.Closed, b.State(), "expected success to reset consecutive failures")
+
+	fail(t, b, 1)
+	assert.Equal(t, breaker.Open, b.State())
+
+	_, err = b.Allow()
+	assert.Equal(t, breaker.ErrOpen, err, "expected open breaker to reject requests")
+}
+
+func TestBreakerHalfOpenProbing(t *testing.T) {
+	b := breaker.New(breaker.Settings{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, HalfOpenProbes: 1})
+
+	fail(t, b, 1)
+	assert.Equal(t, breaker.Open, b.State())
+
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(t, breaker.HalfOpen, b.State())
+
+	probe, err := b.Allow()
+	assert.Nil(t, err, "expected probe to be allowed")
+
+	_, err = b.Allow()
+	assert.Equal(t, breaker.ErrOpen, err, "expected concurrent probe to be rejected")
+
+	probe(breaker.Failure)
+	assert.Equal(t, breaker.Open, b.State(), "expected failed probe to reopen breaker")
+
+	time.Sleep(15 * time.Millisecond)
+	probe, err = b.Allow()
+	assert.Nil(t, err)
+	probe(breaker.Success)
+	assert.Equal(t, breaker.Closed, b.State(), "expected successful probe to close breaker")
+}
@@ -0,0 +1,339 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package faults implements an http.RoundTripper injecting the failures of a
+// degraded OpenAPI gateway into Application requests, to test how payment
+// flows survive them:
+//
+//	t := &faults.Transport{Plan: faults.Script(
+//		faults.Fault{Kind: faults.Reset, Path: "c2bPayment"},
+//		faults.Fault{Kind: faults.ResponseCode, Code: mpesa.CodeInsufficientBalance},
+//	)}
+//	app, err := mpesa.NewApplication(key, market, mpesa.Sandbox,
+//		mpesa.WithHTTPClient(&http.Client{Transport: t}))
+package faults
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	// ErrReset is returned for requests failed by a Reset or
+	// ResetAfterForward fault.
+	ErrReset = errors.New("connection reset by peer")
+)
+
+// Kind of a Fault.
+type Kind int
+
+const (
+	// None passes the request through.
+	None Kind = iota
+
+	// Latency passes the request through after the fault Delay.
+	Latency
+
+	// Reset fails the request as if the connection was reset.
+	Reset
+
+	// ServerError answers the fault Status, 503 by default, with a plain
+	// text body, as the gateway does when the backend is unavailable.
+	ServerError
+
+	// MalformedJSON answers the fault Status, 200 by default, with a body
+	// that is not valid JSON.
+	MalformedJSON
+
+	// Truncated passes the request through and cuts the response body in
+	// half, reading it past that point fails with io.ErrUnexpectedEOF.
+	Truncated
+
+	// ResponseCode answers the M-Pesa response Code of the fault with its
+	// Status, 400 by default.
+	ResponseCode
+
+	// ResetAfterForward passes the request through, then fails it as if the
+	// connection was reset before the response arrived: the gateway
+	// processed a request its sender sees as failed.
+	ResetAfterForward
+)
+
+var kindName = map[Kind]string{
+	None:          "none",
+	Latency:       "latency",
+	Reset:         "reset",
+	ServerError:   "server_error",
+	MalformedJSON: "malformed_json",
+	Truncated:     "truncated",
+	ResponseCode:  "response_code",
+
+	ResetAfterForward: "reset_after_forward",
+}
+
+func (k Kind) String() string {
+	return kindName[k]
+}
+
+// Fault is a failure injected into a request.
+type Fault struct {
+	Kind Kind
+
+	// Path restricts the fault to requests whose URL path contains it, e.g.
+	// "c2bPayment". The empty path matches every request.
+	Path string
+
+	// Delay is waited before any kind of fault is injected.
+	Delay time.Duration
+
+	// Status is the HTTP status of ServerError, MalformedJSON and
+	// ResponseCode faults.
+	Status int
+
+	// Code and Description are the output_ResponseCode and
+	// output_ResponseDesc of ResponseCode faults.
+	Code        string
+	Description string
+}
+
+func (f Fault) matches(req *http.Request) bool {
+	return f.Path == "" || strings.Contains(req.URL.Path, f.Path)
+}
+
+// Plan decides the fault injected into each request.
+type Plan interface {
+	Next(req *http.Request) Fault
+}
+
+// Script returns a plan injecting faults in order, then None. A fault waits
+// for the first request matching its Path, the requests before it are
+// passed through.
+func Script(faults ...Fault) Plan {
+	return &script{faults: faults}
+}
+
+type script struct {
+	mu     sync.Mutex
+	faults []Fault
+}
+
+func (s *script) Next(req *http.Request) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.faults) == 0 || !s.faults[0].matches(req) {
+		return Fault{}
+	}
+
+	f := s.faults[0]
+	s.faults = s.faults[1:]
+	return f
+}
+
+// Weighted is a fault injected with probability Rate, between 0 and 1.
+type Weighted struct {
+	Fault
+	Rate float64
+}
+
+// Random returns a plan injecting each fault with its rate, the same seed
+// giving the same sequence of faults. Faults not matching a request are
+// left out for it.
+func Random(seed int64, faults ...Weighted) Plan {
+	return &random{rand: rand.New(rand.NewSource(seed)), faults: faults}
+}
+
+type random struct {
+	mu     sync.Mutex
+	rand   *rand.Rand
+	faults []Weighted
+}
+
+func (r *random) Next(req *http.Request) Fault {
+	r.mu.Lock()
+	p := r.rand.Float64()
+	r.mu.Unlock()
+
+	for _, f := range r.faults {
+		if p < f.Rate {
+			if f.matches(req) {
+				return f.Fault
+			}
+			return Fault{}
+		}
+		p -= f.Rate
+	}
+	return Fault{}
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// Transport injects the faults of its Plan into requests.
+type Transport struct {
+	// Plan decides the faults, requests are passed through when nil.
+	Plan Plan
+
+	// Transport sends the requests passed through, http.DefaultTransport
+	// when nil.
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	injected []Fault
+}
+
+// Injected returns the faults injected so far, None excepted.
+func (t *Transport) Injected() []Fault {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Fault(nil), t.injected...)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var f Fault
+	if t.Plan != nil {
+		f = t.Plan.Next(req)
+	}
+
+	if f.Kind != None {
+		t.mu.Lock()
+		t.injected = append(t.injected, f)
+		t.mu.Unlock()
+	}
+
+	if f.Delay > 0 {
+		timer := time.NewTimer(f.Delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch f.Kind {
+	case Reset, ServerError, MalformedJSON, ResponseCode:
+		// answered without forwarding, the body is closed as a transport would
+		if req.Body != nil {
+			req.Body.Close()
+		}
+	}
+
+	switch f.Kind {
+	case Reset:
+		return nil, errors.Wrap(ErrReset, errors.New(req.Method+" "+req.URL.Path))
+
+	case ServerError:
+		status := statusOr(f.Status, http.StatusServiceUnavailable)
+		return response(req, status, "text/plain; charset=utf-8", []byte(http.StatusText(status))), nil
+
+	case MalformedJSON:
+		return response(req, statusOr(f.Status, http.StatusOK), "application/json", []byte(`{"output_ResponseCode": INS-0,`)), nil
+
+	case ResponseCode:
+		body, err := json.Marshal(map[string]string{
+			"output_ResponseCode": f.Code,
+			"output_ResponseDesc": f.Description,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return response(req, statusOr(f.Status, http.StatusBadRequest), "application/json", body), nil
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.Kind {
+	case ResetAfterForward:
+		resp.Body.Close()
+		return nil, errors.Wrap(ErrReset, errors.New(req.Method+" "+req.URL.Path+" after forwarding"))
+
+	case Truncated:
+		return truncate(resp)
+	}
+	return resp, nil
+}
+
+// truncate cuts the body of resp in half.
+func truncate(resp *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &truncated{r: bytes.NewReader(body[:len(body)/2])}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+func statusOr(status, def int) int {
+	if status == 0 {
+		return def
+	}
+	return status
+}
+
+func response(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// truncated is a body failing with io.ErrUnexpectedEOF past its content, as
+// one whose connection dropped mid-response.
+type truncated struct {
+	r io.Reader
+}
+
+func (t *truncated) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (t *truncated) Close() error {
+	return nil
+}
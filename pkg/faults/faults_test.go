@@ -0,0 +1,348 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package faults_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pkg/faults"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAPI returns a healthy gateway accepting payments.
+func newAPI() *mpesatest.API {
+	accepted := mpesatest.Reply{Status: http.StatusCreated, Body: `{"output_ResponseCode":"INS-0","output_ResponseDesc":"Request processed successfully","output_TransactionID":"tx-1"}`}
+	return mpesatest.NewAPI().On(mpesa.OpC2B, accepted).On(mpesa.OpB2C, accepted)
+}
+
+func get(ctx context.Context, tr http.RoundTripper, path string) (*http.Response, error) {
+	req := httptest.NewRequest(http.MethodGet, "https://openapi.m-pesa.com/sandbox/ipg/v2/vodacomTZN/"+path+"/", nil)
+	return tr.RoundTrip(req.WithContext(ctx))
+}
+
+func TestTransport(t *testing.T) {
+	cases := []struct {
+		desc    string
+		fault   faults.Fault
+		timeout time.Duration
+		status  int
+		code    string
+		readErr error
+		err     error
+	}{
+		{
+			desc:   "no fault",
+			fault:  faults.Fault{},
+			status: http.StatusCreated,
+			code:   mpesa.CodeSuccess,
+		},
+		{
+			desc:   "latency",
+			fault:  faults.Fault{Kind: faults.Latency, Delay: time.Millisecond},
+			status: http.StatusCreated,
+			code:   mpesa.CodeSuccess,
+		},
+		{
+			desc:    "latency past the deadline",
+			fault:   faults.Fault{Kind: faults.Latency, Delay: time.Minute},
+			timeout: time.Millisecond,
+			err:     context.DeadlineExceeded,
+		},
+		{
+			desc:  "connection reset",
+			fault: faults.Fault{Kind: faults.Reset},
+			err:   faults.ErrReset,
+		},
+		{
+			desc:   "server error",
+			fault:  faults.Fault{Kind: faults.ServerError},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			desc:   "bad gateway",
+			fault:  faults.Fault{Kind: faults.ServerError, Status: http.StatusBadGateway},
+			status: http.StatusBadGateway,
+		},
+		{
+			desc:   "malformed json",
+			fault:  faults.Fault{Kind: faults.MalformedJSON},
+			status: http.StatusOK,
+		},
+		{
+			desc:    "truncated body",
+			fault:   faults.Fault{Kind: faults.Truncated},
+			status:  http.StatusCreated,
+			readErr: io.ErrUnexpectedEOF,
+		},
+		{
+			desc:   "response code",
+			fault:  faults.Fault{Kind: faults.ResponseCode, Status: http.StatusUnprocessableEntity, Code: mpesa.CodeInsufficientBalance},
+			status: http.StatusUnprocessableEntity,
+			code:   mpesa.CodeInsufficientBalance,
+		},
+	}
+
+	for _, tc := range cases {
+		tr := &faults.Transport{Plan: faults.Script(tc.fault), Transport: newAPI()}
+
+		ctx := context.Background()
+		if tc.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+			defer cancel()
+		}
+
+		resp, err := get(ctx, tr, "c2bPayment/singleStage")
+		if tc.err != nil {
+			assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.status, resp.StatusCode, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, resp.StatusCode))
+
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, tc.readErr, err, fmt.Sprintf("%s: expected read error %v got %v\n", tc.desc, tc.readErr, err))
+
+		if tc.code != "" {
+			var r mpesa.ResponseError
+			err := json.Unmarshal(body, &r)
+			assert.Nil(t, err, fmt.Sprintf("%s: expected a JSON body got %v\n", tc.desc, err))
+			assert.Equal(t, tc.code, r.Code, fmt.Sprintf("%s: expected response code %s got %s\n", tc.desc, tc.code, r.Code))
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	tr := &faults.Transport{
+		Plan: faults.Script(
+			faults.Fault{Kind: faults.ServerError, Path: "c2bPayment"},
+			faults.Fault{Kind: faults.Reset},
+		),
+		Transport: newAPI(),
+	}
+
+	cases := []struct {
+		desc   string
+		path   string
+		status int
+		err    error
+	}{
+		{
+			desc:   "request before the fault path",
+			path:   "getSession",
+			status: http.StatusOK,
+		},
+		{
+			desc:   "first fault",
+			path:   "c2bPayment/singleStage",
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			desc: "second fault",
+			path: "getSession",
+			err:  faults.ErrReset,
+		},
+		{
+			desc:   "script done",
+			path:   "c2bPayment/singleStage",
+			status: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range cases {
+		resp, err := get(context.Background(), tr, tc.path)
+		if tc.err != nil {
+			assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.status, resp.StatusCode, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, resp.StatusCode))
+	}
+
+	assert.Len(t, tr.Injected(), 2, "expected every scripted fault to be injected\n")
+}
+
+func TestRandom(t *testing.T) {
+	const requests = 2000
+
+	run := func(seed int64) []faults.Kind {
+		tr := &faults.Transport{
+			Plan: faults.Random(seed,
+				faults.Weighted{Fault: faults.Fault{Kind: faults.ServerError}, Rate: 0.2},
+				faults.Weighted{Fault: faults.Fault{Kind: faults.Reset, Path: "c2bPayment"}, Rate: 0.1},
+			),
+			Transport: newAPI(),
+		}
+
+		for i := 0; i < requests; i++ {
+			get(context.Background(), tr, "b2cPayment")
+		}
+
+		var kinds []faults.Kind
+		for _, f := range tr.Injected() {
+			kinds = append(kinds, f.Kind)
+		}
+		return kinds
+	}
+
+	kinds := run(1)
+	assert.Equal(t, kinds, run(1), "expected the same seed to inject the same faults\n")
+	assert.InDelta(t, 0.2*requests, len(kinds), 0.05*requests, "expected faults to be injected at their rate\n")
+	assert.NotContains(t, kinds, faults.Reset, "expected faults not matching the path to be left out\n")
+}
+
+func TestApplication(t *testing.T) {
+	payment := mpesa.C2BPayment{
+		Amount:                   "100",
+		Country:                  "TZN",
+		Currency:                 "TZS",
+		CustomerMSISDN:           "255744553111",
+		ServiceProviderCode:      "000000",
+		ThirdPartyConversationID: "asv02e5958774f7ba228d83d0d689761",
+		TransactionReference:     "T1234C",
+		PurchasedItemsDesc:       "Test",
+	}
+
+	cases := []struct {
+		desc   string
+		fault  faults.Fault
+		status int
+		code   string
+		err    string
+	}{
+		{
+			desc:  "connection reset",
+			fault: faults.Fault{Kind: faults.Reset},
+			err:   faults.ErrReset.Error(),
+		},
+		{
+			desc:  "reply lost after the payment was sent",
+			fault: faults.Fault{Kind: faults.ResetAfterForward},
+			err:   faults.ErrReset.Error(),
+		},
+		{
+			desc:   "server error",
+			fault:  faults.Fault{Kind: faults.ServerError},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			desc:  "malformed json",
+			fault: faults.Fault{Kind: faults.MalformedJSON, Status: http.StatusCreated},
+			err:   "invalid character",
+		},
+		{
+			desc:  "truncated body",
+			fault: faults.Fault{Kind: faults.Truncated},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			desc:   "insufficient balance",
+			fault:  faults.Fault{Kind: faults.ResponseCode, Status: http.StatusUnprocessableEntity, Code: mpesa.CodeInsufficientBalance},
+			status: http.StatusUnprocessableEntity,
+			code:   mpesa.CodeInsufficientBalance,
+		},
+	}
+
+	for _, tc := range cases {
+		tc.fault.Path = "c2bPayment"
+		tr := &faults.Transport{Plan: faults.Script(tc.fault), Transport: newAPI()}
+
+		app := mpesatest.NewApplication(t, tr)
+
+		_, err := app.C2B(context.Background(), payment)
+		assert.Error(t, err, fmt.Sprintf("%s: expected the payment to fail\n", tc.desc))
+
+		if tc.status != 0 {
+			respErr, ok := err.(*mpesa.ResponseError)
+			assert.True(t, ok, fmt.Sprintf("%s: expected a response error got %v\n", tc.desc, err))
+			if ok {
+				assert.Equal(t, tc.status, respErr.StatusCode, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, respErr.StatusCode))
+				assert.Equal(t, tc.code, respErr.Code, fmt.Sprintf("%s: expected response code %s got %s\n", tc.desc, tc.code, respErr.Code))
+			}
+			continue
+		}
+		assert.Contains(t, fmt.Sprint(err), tc.err, fmt.Sprintf("%s: expected error %s got %v\n", tc.desc, tc.err, err))
+	}
+}
+
+func TestResetAfterForward(t *testing.T) {
+	cases := []struct {
+		desc      string
+		kind      faults.Kind
+		forwarded int
+	}{
+		{
+			desc:      "reset before forwarding",
+			kind:      faults.Reset,
+			forwarded: 0,
+		},
+		{
+			desc:      "reset after forwarding",
+			kind:      faults.ResetAfterForward,
+			forwarded: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		api := newAPI()
+		tr := &faults.Transport{Plan: faults.Script(faults.Fault{Kind: tc.kind, Path: mpesa.OpC2B}), Transport: api}
+
+		_, err := get(context.Background(), tr, "c2bPayment/singleStage")
+		assert.True(t, errors.Contains(err, faults.ErrReset), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, faults.ErrReset, err))
+		assert.Equal(t, tc.forwarded, api.Requests(mpesa.OpC2B), fmt.Sprintf("%s: expected %d forwarded requests got %d\n", tc.desc, tc.forwarded, api.Requests(mpesa.OpC2B)))
+	}
+}
+
+// body records whether it was closed.
+type body struct {
+	io.Reader
+	closed bool
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRequestBodyClosed(t *testing.T) {
+	for _, kind := range []faults.Kind{faults.None, faults.Reset, faults.ServerError, faults.MalformedJSON, faults.ResponseCode, faults.ResetAfterForward} {
+		tr := &faults.Transport{Plan: faults.Script(faults.Fault{Kind: kind}), Transport: newAPI()}
+
+		b := &body{Reader: strings.NewReader(`{}`)}
+		req := httptest.NewRequest(http.MethodPost, "https://openapi.m-pesa.com/sandbox/ipg/v2/vodacomTZN/c2bPayment/singleStage/", b)
+		req.Body = b
+
+		tr.RoundTrip(req)
+		assert.True(t, b.closed, fmt.Sprintf("%s: expected the request body to be closed\n", kind))
+	}
+}
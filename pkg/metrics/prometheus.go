@@ -0,0 +1,204 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package metrics provides mpesa.Metrics implementations.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram upper bounds, in seconds, used
+// when none are given to NewPrometheus.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type requestKey struct {
+	operation string
+	code      string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Prometheus collects Application metrics in memory and renders them in the
+// Prometheus text exposition format. It needs no Prometheus client library
+// and can be mounted directly as a /metrics http.Handler.
+type Prometheus struct {
+	namespace string
+	buckets   []float64
+
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram
+	retries   map[string]uint64
+	refreshes uint64
+}
+
+// NewPrometheus returns a Prometheus collector whose metric names are prefixed
+// with namespace. If buckets is empty DefaultBuckets is used.
+func NewPrometheus(namespace string, buckets ...float64) *Prometheus {
+	if namespace == "" {
+		namespace = "mpesa"
+	}
+
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	return &Prometheus{
+		namespace: namespace,
+		buckets:   b,
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[string]*histogram),
+		retries:   make(map[string]uint64),
+	}
+}
+
+// ObserveRequest implements mpesa.Metrics.
+func (p *Prometheus) ObserveRequest(operation, responseCode string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests[requestKey{operation, responseCode}]++
+
+	h, ok := p.latencies[operation]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.latencies[operation] = h
+	}
+
+	sec := latency.Seconds()
+	for i, le := range p.buckets {
+		if sec <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+// IncSessionRefresh implements mpesa.Metrics.
+func (p *Prometheus) IncSessionRefresh() {
+	p.mu.Lock()
+	p.refreshes++
+	p.mu.Unlock()
+}
+
+// IncRetry implements mpesa.Metrics.
+func (p *Prometheus) IncRetry(operation string) {
+	p.mu.Lock()
+	p.retries[operation]++
+	p.mu.Unlock()
+}
+
+// WriteTo writes every collected metric to w in the Prometheus text format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	p.mu.Lock()
+
+	name := p.namespace + "_requests_total"
+	fmt.Fprintf(&buf, "# HELP %s Operations performed, by operation and response code.\n", name)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+	reqKeys := make([]requestKey, 0, len(p.requests))
+	for k := range p.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		if reqKeys[i].operation != reqKeys[j].operation {
+			return reqKeys[i].operation < reqKeys[j].operation
+		}
+		return reqKeys[i].code < reqKeys[j].code
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(&buf, "%s{operation=\"%s\",code=\"%s\"} %d\n", name, escape(k.operation), escape(k.code), p.requests[k])
+	}
+
+	name = p.namespace + "_request_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Operation latency in seconds.\n", name)
+	fmt.Fprintf(&buf, "# TYPE %s histogram\n", name)
+	for _, op := range sortedKeys(p.latencies) {
+		h := p.latencies[op]
+		for i, le := range p.buckets {
+			fmt.Fprintf(&buf, "%s_bucket{operation=\"%s\",le=\"%s\"} %d\n", name, escape(op), formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(&buf, "%s_bucket{operation=\"%s\",le=\"+Inf\"} %d\n", name, escape(op), h.count)
+		fmt.Fprintf(&buf, "%s_sum{operation=\"%s\"} %s\n", name, escape(op), formatFloat(h.sum))
+		fmt.Fprintf(&buf, "%s_count{operation=\"%s\"} %d\n", name, escape(op), h.count)
+	}
+
+	name = p.namespace + "_session_refreshes_total"
+	fmt.Fprintf(&buf, "# HELP %s Session keys generated.\n", name)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+	fmt.Fprintf(&buf, "%s %d\n", name, p.refreshes)
+
+	name = p.namespace + "_retries_total"
+	fmt.Fprintf(&buf, "# HELP %s Retried operation attempts.\n", name)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+	retryOps := make([]string, 0, len(p.retries))
+	for op := range p.retries {
+		retryOps = append(retryOps, op)
+	}
+	sort.Strings(retryOps)
+	for _, op := range retryOps {
+		fmt.Fprintf(&buf, "%s{operation=\"%s\"} %d\n", name, escape(op), p.retries[op])
+	}
+
+	p.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP exposes the collected metrics for scraping.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+func sortedKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelEscaper escapes backslashes, quotes and newlines in label values as
+// the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package metrics_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ mpesa.Metrics = (*metrics.Prometheus)(nil)
+
+func TestPrometheusExposition(t *testing.T) {
+	p := metrics.NewPrometheus("mpesa", 0.1, 1)
+
+	p.ObserveRequest("c2bPayment", "INS-0", 50*time.Millisecond)
+	p.ObserveRequest("c2bPayment", "INS-0", 500*time.Millisecond)
+	p.ObserveRequest("c2bPayment", "INS-6", 2*time.Second)
+	p.IncSessionRefresh()
+	p.IncRetry("c2bPayment")
+
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	assert.Nil(t, err, "unexpected error writing metrics")
+
+	cases := []struct {
+		desc string
+		line string
+	}{
+		{
+			desc: "request counter per response code",
+			line: `mpesa_requests_total{operation="c2bPayment",code="INS-0"} 2`,
+		},
+		{
+			desc: "request counter for failed code",
+			line: `mpesa_requests_total{operation="c2bPayment",code="INS-6"} 1`,
+		},
+		{
+			desc: "lowest latency bucket",
+			line: `mpesa_request_duration_seconds_bucket{operation="c2bPayment",le="0.1"} 1`,
+		},
+		{
+			desc: "cumulative latency bucket",
+			line: `mpesa_request_duration_seconds_bucket{operation="c2bPayment",le="1"} 2`,
+		},
+		{
+			desc: "infinite latency bucket",
+			line: `mpesa_request_duration_seconds_bucket{operation="c2bPayment",le="+Inf"} 3`,
+		},
+		{
+			desc: "latency sample count",
+			line: `mpesa_request_duration_seconds_count{operation="c2bPayment"} 3`,
+		},
+		{
+			desc: "session refresh counter",
+			line: `mpesa_session_refreshes_total 1`,
+		},
+		{
+			desc: "retry counter",
+			line: `mpesa_retries_total{operation="c2bPayment"} 1`,
+		},
+	}
+
+	out := buf.String()
+	for _, tc := range cases {
+		assert.True(t, strings.Contains(out, tc.line+"\n"), fmt.Sprintf("%s: expected output to contain %s\n", tc.desc, tc.line))
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	p := metrics.NewPrometheus("")
+	p.ObserveRequest(`a"b`, "ok", time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, rec.Body.String(), `mpesa_requests_total{operation="a\"b",code="ok"} 1`)
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	// ErrNoJSONTag is reported for exported fields without a json tag.
+	ErrNoJSONTag = errors.New("field has no json tag")
+
+	// ErrUnknownField is reported for fields whose json name is not a
+	// property of the schema.
+	ErrUnknownField = errors.New("field is not in the schema")
+
+	// ErrMissingField is reported for schema properties without a field.
+	ErrMissingField = errors.New("schema property has no field")
+
+	// ErrGoName is reported for types and fields whose name differs from
+	// the x-go-name of their schema or property.
+	ErrGoName = errors.New("go name does not match x-go-name")
+
+	// ErrOmitEmpty is reported for required properties tagged omitempty.
+	ErrOmitEmpty = errors.New("required property is omitempty")
+)
+
+// Check checks the json tags of the struct v against schema name: every
+// exported field must be tagged, fields tagged "-" and the fields named in
+// skip, e.g. fields not decoded from the payload, are skipped, and fields
+// and properties must match one to one, by json name and by x-go-name.
+// It returns every mismatch found.
+func (s *Spec) Check(name string, v interface{}, skip ...string) []error {
+	schema, err := s.Schema(name)
+	if err != nil {
+		return []error{err}
+	}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var errs []error
+	report := func(e error, detail string, args ...interface{}) {
+		errs = append(errs, errors.Wrap(e, errors.New(fmt.Sprintf(detail, args...))))
+	}
+
+	if schema.GoName != "" && schema.GoName != t.Name() {
+		report(ErrGoName, "%s: type %s, x-go-name %s", name, t.Name(), schema.GoName)
+	}
+
+	skipped := make(map[string]bool)
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	matched := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || skipped[f.Name] {
+			continue
+		}
+
+		tag, ok := f.Tag.Lookup("json")
+		if !ok {
+			report(ErrNoJSONTag, "%s.%s", t.Name(), f.Name)
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		prop := schema.Property(opts[0])
+		if prop == nil {
+			report(ErrUnknownField, "%s.%s: %s", t.Name(), f.Name, opts[0])
+			continue
+		}
+		matched[opts[0]] = true
+
+		if prop.GoName != "" && prop.GoName != f.Name {
+			report(ErrGoName, "%s.%s: %s has x-go-name %s", t.Name(), f.Name, opts[0], prop.GoName)
+		}
+
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" && schema.IsRequired(opts[0]) {
+				report(ErrOmitEmpty, "%s.%s: %s", t.Name(), f.Name, opts[0])
+			}
+		}
+	}
+
+	for _, p := range schema.Properties {
+		if !matched[p.Name] {
+			report(ErrMissingField, "%s: %s", t.Name(), p.Name)
+		}
+	}
+
+	return errs
+}
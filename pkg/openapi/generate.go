@@ -0,0 +1,360 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// DefaultPackage is the Go package of schemas without x-go-package.
+const DefaultPackage = "mpesa"
+
+var (
+	// ErrGenerate is returned when a spec cannot be turned into Go.
+	ErrGenerate = errors.New("failed to generate go types")
+
+	// ErrUnsupportedType is returned for schemas without a Go equivalent.
+	ErrUnsupportedType = errors.New("unsupported schema type")
+)
+
+// Generate returns the Go source of the types of package pkg described by
+// the spec, read from file source:
+//
+//   - a constant for every value of the enum schemas with x-go-name and
+//     x-enum-varnames,
+//   - a struct for every object schema with x-go-name, unless x-go-generate
+//     is false, with a field per property named by its x-go-name,
+//   - a Validate method for every request type, the schemas of request
+//     bodies and those named by x-go-request, checking required, minLength,
+//     maxLength, pattern and enum,
+//   - a Meta field left out of JSON, and a setMeta method, for every
+//     object schema with x-go-meta.
+//
+// The Validate methods rely on a fieldRule type and a validate function that
+// pkg must provide, see validate.go in the mpesa package, and the Meta fields
+// on its Meta type, see meta.go.
+func (s *Spec) Generate(pkg, source string) ([]byte, error) {
+	g := &generator{spec: s, patterns: make(map[string]string)}
+
+	var names []string
+	for name := range s.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	requests, err := s.requests()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		schema := s.Components.Schemas[name]
+		if schema.GoName == "" || packageOf(schema) != pkg {
+			continue
+		}
+
+		switch {
+		case len(schema.Enum) > 0 && len(schema.EnumVarNames) > 0:
+			if err := g.enum(name, schema); err != nil {
+				return nil, err
+			}
+
+		case schema.Type == "object" && (schema.GoGenerate == nil || *schema.GoGenerate):
+			if err := g.object(name, schema, requests[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by mpesa-gen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\n", pkg)
+	if len(g.patterns) > 0 {
+		fmt.Fprintf(&out, "import \"regexp\"\n\n")
+	}
+	out.Write(g.consts.Bytes())
+	out.Write(g.types.Bytes())
+
+	if len(g.patterns) > 0 {
+		var vars []string
+		for v := range g.patterns {
+			vars = append(vars, v)
+		}
+		sort.Strings(vars)
+
+		fmt.Fprintf(&out, "var (\n")
+		for _, v := range vars {
+			fmt.Fprintf(&out, "%s = regexp.MustCompile(`%s`)\n", v, g.patterns[v])
+		}
+		fmt.Fprintf(&out, ")\n")
+	}
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(ErrGenerate, err)
+	}
+	return src, nil
+}
+
+// requests returns the names of the request schemas.
+func (s *Spec) requests() (map[string]bool, error) {
+	requests := make(map[string]bool)
+
+	for _, item := range s.Paths {
+		for method, op := range item.Operations() {
+			if op.GoRequest != "" {
+				if _, err := s.Schema(op.GoRequest); err != nil {
+					return nil, err
+				}
+				requests[op.GoRequest] = true
+			}
+
+			if op.RequestBody == nil || method == http.MethodGet {
+				continue
+			}
+			for _, media := range op.RequestBody.Content {
+				if name := SchemaName(media.Schema); name != "" {
+					requests[name] = true
+				}
+			}
+		}
+	}
+	return requests, nil
+}
+
+func packageOf(schema *Schema) string {
+	if schema.GoPackage != "" {
+		return schema.GoPackage
+	}
+	return DefaultPackage
+}
+
+type generator struct {
+	spec   *Spec
+	consts bytes.Buffer
+	types  bytes.Buffer
+
+	// patterns maps the names of the pattern variables to their pattern
+	patterns map[string]string
+}
+
+// enum writes the constants of an enum schema.
+func (g *generator) enum(name string, schema *Schema) error {
+	if len(schema.EnumVarNames) != len(schema.Enum) {
+		return errors.Wrap(ErrGenerate, errors.New(name+": x-enum-varnames and enum differ in length"))
+	}
+
+	comment(&g.consts, "", schema.GoDoc)
+	fmt.Fprintf(&g.consts, "const (\n")
+	for i, v := range schema.Enum {
+		var doc []string
+		if i < len(schema.EnumDescriptions) {
+			doc = append(doc, strings.TrimSuffix(schema.EnumDescriptions[i], "."))
+		}
+		if i < len(schema.HTTPStatuses) {
+			doc = append(doc, fmt.Sprintf("HTTP %d", schema.HTTPStatuses[i]))
+		}
+		if len(doc) > 0 {
+			comment(&g.consts, "\t", strings.Join(doc, ", ")+".")
+		}
+		fmt.Fprintf(&g.consts, "\t%s = %q\n", schema.EnumVarNames[i], v)
+	}
+	fmt.Fprintf(&g.consts, ")\n\n")
+	return nil
+}
+
+// rule is the validation rule of a string field.
+type rule struct {
+	name      string
+	required  bool
+	minLength int
+	maxLength int
+	pattern   string
+	enum      []string
+}
+
+// object writes the struct of an object schema and, for requests, its
+// Validate method.
+func (g *generator) object(name string, schema *Schema, request bool) error {
+	if schema.GoDoc != "" {
+		comment(&g.types, "", schema.GoDoc)
+	} else {
+		comment(&g.types, "", fmt.Sprintf("%s is the %s schema.", schema.GoName, name))
+	}
+	fmt.Fprintf(&g.types, "type %s struct {\n", schema.GoName)
+
+	var (
+		rules  []rule
+		fields []string
+	)
+
+	for i, p := range schema.Properties {
+		if p.Schema.GoName == "" {
+			return errors.Wrap(ErrGenerate, errors.New(name+"."+p.Name+": property has no x-go-name"))
+		}
+
+		typ, err := g.goType(p.Schema)
+		if err != nil {
+			return errors.Wrap(ErrGenerate, errors.Wrap(errors.New(name+"."+p.Name), err))
+		}
+
+		if p.Schema.GoDoc != "" {
+			if i > 0 {
+				fmt.Fprintf(&g.types, "\n")
+			}
+			comment(&g.types, "\t", p.Schema.GoDoc)
+		}
+		fmt.Fprintf(&g.types, "\t%s %s `json:%q`\n", p.Schema.GoName, typ, p.Name)
+
+		if request && typ == "string" {
+			r, err := g.rule(schema, name, p)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, r)
+			fields = append(fields, "r."+p.Schema.GoName)
+		}
+	}
+	if schema.GoMeta {
+		fmt.Fprintf(&g.types, "\n")
+		comment(&g.types, "\t", "Meta is the HTTP exchange the response was decoded from.")
+		fmt.Fprintf(&g.types, "\tMeta *Meta `json:\"-\"`\n")
+	}
+	fmt.Fprintf(&g.types, "}\n\n")
+
+	if schema.GoMeta {
+		fmt.Fprintf(&g.types, "func (r *%s) setMeta(m *Meta) { r.Meta = m }\n\n", schema.GoName)
+	}
+
+	if !request {
+		return nil
+	}
+
+	rulesVar := "rules" + schema.GoName
+	fmt.Fprintf(&g.types, "var %s = []fieldRule{\n", rulesVar)
+	for _, r := range rules {
+		var attrs []string
+		attrs = append(attrs, fmt.Sprintf("name: %q", r.name))
+		if r.required {
+			attrs = append(attrs, "required: true")
+		}
+		if r.minLength > 0 {
+			attrs = append(attrs, fmt.Sprintf("minLength: %d", r.minLength))
+		}
+		if r.maxLength > 0 {
+			attrs = append(attrs, fmt.Sprintf("maxLength: %d", r.maxLength))
+		}
+		if r.pattern != "" {
+			attrs = append(attrs, "pattern: "+r.pattern)
+		}
+		if len(r.enum) > 0 {
+			attrs = append(attrs, fmt.Sprintf("enum: %#v", r.enum))
+		}
+		fmt.Fprintf(&g.types, "\t{%s},\n", strings.Join(attrs, ", "))
+	}
+	fmt.Fprintf(&g.types, "}\n\n")
+
+	comment(&g.types, "", fmt.Sprintf("Validate checks r against the rules of the %s schema, it returns a\n*ValidationError for the first field breaking one.", name))
+	fmt.Fprintf(&g.types, "func (r %s) Validate() error {\n", schema.GoName)
+	fmt.Fprintf(&g.types, "\treturn validate(%s, %s)\n", rulesVar, strings.Join(fields, ", "))
+	fmt.Fprintf(&g.types, "}\n\n")
+	return nil
+}
+
+// rule returns the validation rule of property p of schema, whose own
+// constraints override those of the schema it refers to.
+func (g *generator) rule(schema *Schema, name string, p Property) (rule, error) {
+	r := rule{name: p.Name, required: schema.IsRequired(p.Name)}
+
+	resolved, err := g.spec.Resolve(p.Schema)
+	if err != nil {
+		return r, err
+	}
+
+	patternVar := "pattern" + schema.GoName + p.Schema.GoName
+	if ref := SchemaName(p.Schema); ref != "" {
+		patternVar = "pattern" + ref
+	}
+
+	for _, s := range []*Schema{resolved, p.Schema} {
+		if s.MinLength != nil {
+			r.minLength = *s.MinLength
+		}
+		if s.MaxLength != nil {
+			r.maxLength = *s.MaxLength
+		}
+		if len(s.Enum) > 0 {
+			r.enum = s.Enum
+		}
+		if s.Pattern != "" {
+			if s == p.Schema {
+				// the property's own pattern, not shared with the schema it refers to
+				patternVar = "pattern" + schema.GoName + p.Schema.GoName
+			}
+			if strings.Contains(s.Pattern, "`") {
+				return r, errors.Wrap(ErrGenerate, errors.New(name+"."+p.Name+": pattern contains a backquote"))
+			}
+			g.patterns[patternVar] = s.Pattern
+			r.pattern = patternVar
+		}
+	}
+	return r, nil
+}
+
+// goType returns the Go type of a property schema.
+func (g *generator) goType(schema *Schema) (string, error) {
+	resolved, err := g.spec.Resolve(schema)
+	if err != nil {
+		return "", err
+	}
+
+	switch resolved.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int64", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		item, err := g.goType(resolved.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	case "object":
+		if resolved.GoName != "" {
+			return resolved.GoName, nil
+		}
+	}
+	return "", errors.Wrap(ErrUnsupportedType, errors.New(resolved.Type))
+}
+
+// comment writes text as a comment indented by indent.
+func comment(buf *bytes.Buffer, indent, text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintf(buf, "%s// %s\n", indent, line)
+	}
+}
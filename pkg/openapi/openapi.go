@@ -0,0 +1,301 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package openapi reads the subset of OpenAPI 3 used by the repository's
+// openapi.yaml, along with its x-go-* extensions naming the Go types and
+// fields of each schema. It checks Go types against a spec, see Check, and
+// generates them from it, see Generate.
+package openapi
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrParse is returned when a spec cannot be read.
+	ErrParse = errors.New("failed to parse openapi spec")
+
+	// ErrUnresolvedRef is returned for a $ref to a missing component.
+	ErrUnresolvedRef = errors.New("unresolved $ref")
+)
+
+const (
+	schemasRef    = "#/components/schemas/"
+	parametersRef = "#/components/parameters/"
+	responsesRef  = "#/components/responses/"
+)
+
+// Spec is an OpenAPI document.
+type Spec struct {
+	OpenAPI    string               `yaml:"openapi"`
+	Info       Info                 `yaml:"info"`
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Webhooks   map[string]*PathItem `yaml:"webhooks"`
+	Components Components           `yaml:"components"`
+}
+
+// Info is the metadata of a spec.
+type Info struct {
+	Title       string `yaml:"title"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+}
+
+// PathItem holds the operations of a path.
+type PathItem struct {
+	Get    *Operation `yaml:"get"`
+	Put    *Operation `yaml:"put"`
+	Post   *Operation `yaml:"post"`
+	Delete *Operation `yaml:"delete"`
+	Patch  *Operation `yaml:"patch"`
+}
+
+// Operations returns the operations of the path by HTTP method.
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := make(map[string]*Operation)
+	for method, op := range map[string]*Operation{"GET": p.Get, "PUT": p.Put, "POST": p.Post, "DELETE": p.Delete, "PATCH": p.Patch} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// Operation is an API operation.
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Description string               `yaml:"description"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+
+	// GoRequest names the schema of the query parameters of the operation.
+	GoRequest string `yaml:"x-go-request"`
+}
+
+// Parameter is an operation parameter.
+type Parameter struct {
+	Ref         string  `yaml:"$ref"`
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Required    bool    `yaml:"required"`
+	Description string  `yaml:"description"`
+	Schema      *Schema `yaml:"schema"`
+	GoName      string  `yaml:"x-go-name"`
+}
+
+// RequestBody is the body of an operation.
+type RequestBody struct {
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// Response is an operation response.
+type Response struct {
+	Ref         string                `yaml:"$ref"`
+	Description string                `yaml:"description"`
+	Content     map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType is the content of a request or response body.
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Components holds the reusable objects of a spec.
+type Components struct {
+	Schemas    map[string]*Schema    `yaml:"schemas"`
+	Parameters map[string]*Parameter `yaml:"parameters"`
+	Responses  map[string]*Response  `yaml:"responses"`
+}
+
+// Schema is a JSON schema. Properties keep the order of the document.
+type Schema struct {
+	Ref         string      `yaml:"$ref"`
+	Type        string      `yaml:"type"`
+	Description string      `yaml:"description"`
+	Format      string      `yaml:"format"`
+	Pattern     string      `yaml:"pattern"`
+	MinLength   *int        `yaml:"minLength"`
+	MaxLength   *int        `yaml:"maxLength"`
+	Enum        []string    `yaml:"enum"`
+	Required    []string    `yaml:"required"`
+	Properties  Properties  `yaml:"properties"`
+	Items       *Schema     `yaml:"items"`
+	Example     interface{} `yaml:"example"`
+
+	// EnumVarNames, EnumDescriptions and HTTPStatuses name and describe
+	// each value of Enum.
+	EnumVarNames     []string `yaml:"x-enum-varnames"`
+	EnumDescriptions []string `yaml:"x-enum-descriptions"`
+	HTTPStatuses     []int    `yaml:"x-http-statuses"`
+
+	// GoName names the Go type of an object schema or the Go field of a
+	// property, GoPackage the package of the type when not mpesa. GoDoc is
+	// the doc comment of the type or field.
+	GoName    string `yaml:"x-go-name"`
+	GoPackage string `yaml:"x-go-package"`
+	GoDoc     string `yaml:"x-go-doc"`
+
+	// GoGenerate set to false leaves the type to be written by hand.
+	GoGenerate *bool `yaml:"x-go-generate"`
+
+	// GoMeta adds a Meta field, the HTTP metadata of the exchange, to the
+	// type of an operation result.
+	GoMeta bool `yaml:"x-go-meta"`
+}
+
+// IsRequired reports whether property name is required.
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Property returns the property name or nil.
+func (s *Schema) Property(name string) *Schema {
+	for _, p := range s.Properties {
+		if p.Name == name {
+			return p.Schema
+		}
+	}
+	return nil
+}
+
+// Property is a named property of an object schema.
+type Property struct {
+	Name   string
+	Schema *Schema
+}
+
+// Properties are the properties of an object schema, in document order.
+type Properties []Property
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *Properties) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New("properties must be a mapping")
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var s Schema
+		if err := node.Content[i+1].Decode(&s); err != nil {
+			return err
+		}
+		*p = append(*p, Property{Name: node.Content[i].Value, Schema: &s})
+	}
+	return nil
+}
+
+// Load reads the spec at path.
+func Load(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(ErrParse, err)
+	}
+	return Parse(data)
+}
+
+// Parse parses a YAML or JSON spec.
+func Parse(data []byte) (*Spec, error) {
+	var s Spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, errors.Wrap(ErrParse, err)
+	}
+	return &s, nil
+}
+
+// Operation returns the operation with operationId id along with its method
+// and path, or nil when there is none. Webhooks are returned with the
+// webhook name as path.
+func (s *Spec) Operation(id string) (method, path string, op *Operation) {
+	for _, items := range []map[string]*PathItem{s.Paths, s.Webhooks} {
+		for p, item := range items {
+			for m, o := range item.Operations() {
+				if o.OperationID == id {
+					return m, p, o
+				}
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// Schema resolves ref, a schema name or a $ref to one, following chained
+// references.
+func (s *Spec) Schema(ref string) (*Schema, error) {
+	name := strings.TrimPrefix(ref, schemasRef)
+
+	schema, ok := s.Components.Schemas[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnresolvedRef, errors.New(ref))
+	}
+	if schema.Ref != "" {
+		return s.Schema(schema.Ref)
+	}
+	return schema, nil
+}
+
+// Resolve returns the schema schema refers to, or schema itself when it
+// is not a reference.
+func (s *Spec) Resolve(schema *Schema) (*Schema, error) {
+	if schema == nil || schema.Ref == "" {
+		return schema, nil
+	}
+	return s.Schema(schema.Ref)
+}
+
+// Parameter resolves p when it is a reference.
+func (s *Spec) Parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+
+	param, ok := s.Components.Parameters[strings.TrimPrefix(p.Ref, parametersRef)]
+	if !ok {
+		return nil, errors.Wrap(ErrUnresolvedRef, errors.New(p.Ref))
+	}
+	return param, nil
+}
+
+// Response resolves r when it is a reference.
+func (s *Spec) Response(r *Response) (*Response, error) {
+	if r.Ref == "" {
+		return r, nil
+	}
+
+	resp, ok := s.Components.Responses[strings.TrimPrefix(r.Ref, responsesRef)]
+	if !ok {
+		return nil, errors.Wrap(ErrUnresolvedRef, errors.New(r.Ref))
+	}
+	return resp, nil
+}
+
+// SchemaName returns the component name of a $ref schema, or "".
+func SchemaName(schema *Schema) string {
+	if schema == nil || !strings.HasPrefix(schema.Ref, schemasRef) {
+		return ""
+	}
+	return strings.TrimPrefix(schema.Ref, schemasRef)
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package openapi_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+const spec = `
+openapi: 3.1.0
+paths:
+  /payment/:
+    post:
+      operationId: payment
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/Payment"
+components:
+  schemas:
+    Amount:
+      type: string
+      pattern: '^[0-9]+$'
+    Payment:
+      type: object
+      x-go-name: Payment
+      required: [input_Amount]
+      properties:
+        input_Amount:
+          $ref: "#/components/schemas/Amount"
+          x-go-name: Amount
+        input_Reference:
+          type: string
+          x-go-name: Reference
+        input_Desc:
+          type: string
+          x-go-name: Desc
+    Receipt:
+      type: object
+      x-go-name: Receipt
+      x-go-meta: true
+      properties:
+        output_ResponseCode:
+          type: string
+          x-go-name: Code
+`
+
+type Payment struct {
+	Amount    string `json:"input_Amount"`
+	Reference string `json:"input_Reference"`
+	Desc      string `json:"input_Desc,omitempty"`
+	internal  string
+	Raw       []byte `json:"-"`
+}
+
+type Renamed struct {
+	Amount    string `json:"input_Amount"`
+	Reference string `json:"input_TransactionReference"`
+	Desc      string `json:"input_Desc"`
+}
+
+type Untagged struct {
+	Amount    string `json:"input_Amount"`
+	Reference string
+	Desc      string `json:"input_Desc"`
+}
+
+type Missing struct {
+	Amount string `json:"input_Amount"`
+	Desc   string `json:"input_Desc"`
+}
+
+type Skipped struct {
+	Amount     string `json:"input_Amount"`
+	Reference  string `json:"input_Reference"`
+	Desc       string `json:"input_Desc,omitempty"`
+	StatusCode int
+}
+
+type Misnamed struct {
+	Sum       string `json:"input_Amount,omitempty"`
+	Reference string `json:"input_Reference"`
+	Desc      string `json:"input_Desc"`
+}
+
+func TestParse(t *testing.T) {
+	s, err := openapi.Parse([]byte(spec))
+	assert.Nil(t, err, "unexpected error parsing spec")
+
+	method, path, op := s.Operation("payment")
+	assert.Equal(t, "POST", method)
+	assert.Equal(t, "/payment/", path)
+
+	schema := op.RequestBody.Content["application/json"].Schema
+	assert.Equal(t, "Payment", openapi.SchemaName(schema))
+
+	payment, err := s.Resolve(schema)
+	assert.Nil(t, err)
+
+	var names []string
+	for _, p := range payment.Properties {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"input_Amount", "input_Reference", "input_Desc"}, names, "expected properties in document order")
+
+	amount, err := s.Resolve(payment.Property("input_Amount"))
+	assert.Nil(t, err)
+	assert.Equal(t, "^[0-9]+$", amount.Pattern)
+
+	_, err = s.Schema("Refund")
+	assert.True(t, errors.Contains(err, openapi.ErrUnresolvedRef), fmt.Sprintf("expected %s got %v", openapi.ErrUnresolvedRef, err))
+}
+
+func TestCheck(t *testing.T) {
+	s, err := openapi.Parse([]byte(spec))
+	assert.Nil(t, err, "unexpected error parsing spec")
+
+	cases := []struct {
+		desc string
+		v    interface{}
+		skip []string
+		errs []error
+	}{
+		{
+			desc: "matching struct",
+			v:    Payment{},
+			errs: nil,
+		},
+		{
+			desc: "renamed json tag",
+			v:    &Renamed{},
+			errs: []error{openapi.ErrGoName, openapi.ErrUnknownField, openapi.ErrMissingField},
+		},
+		{
+			desc: "missing json tag",
+			v:    Untagged{},
+			errs: []error{openapi.ErrGoName, openapi.ErrNoJSONTag, openapi.ErrMissingField},
+		},
+		{
+			desc: "missing field",
+			v:    Missing{},
+			errs: []error{openapi.ErrGoName, openapi.ErrMissingField},
+		},
+		{
+			desc: "skipped field",
+			v:    Skipped{},
+			skip: []string{"StatusCode"},
+			errs: []error{openapi.ErrGoName},
+		},
+		{
+			desc: "misnamed field",
+			v:    Misnamed{},
+			errs: []error{openapi.ErrGoName, openapi.ErrGoName, openapi.ErrOmitEmpty},
+		},
+	}
+
+	for _, tc := range cases {
+		errs := s.Check("Payment", tc.v, tc.skip...)
+		assert.Equal(t, len(tc.errs), len(errs), fmt.Sprintf("%s: expected %d errors got %v\n", tc.desc, len(tc.errs), errs))
+		for i := 0; i < len(tc.errs) && i < len(errs); i++ {
+			assert.True(t, errors.Contains(errs[i], tc.errs[i]), fmt.Sprintf("%s: expected %s got %s\n", tc.desc, tc.errs[i], errs[i]))
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := openapi.Parse([]byte(spec))
+	assert.Nil(t, err, "unexpected error parsing spec")
+
+	src, err := s.Generate("mpesa", "spec.yaml")
+	assert.Nil(t, err, "unexpected error generating types")
+
+	cases := []struct {
+		desc string
+		code string
+	}{
+		{desc: "header", code: "// Code generated by mpesa-gen from spec.yaml. DO NOT EDIT."},
+		{desc: "struct", code: "type Payment struct {"},
+		{desc: "field", code: "Amount    string `json:\"input_Amount\"`"},
+		{desc: "rules", code: `{name: "input_Amount", required: true, pattern: patternAmount},`},
+		{desc: "validate", code: "func (r Payment) Validate() error {"},
+		{desc: "pattern", code: "patternAmount = regexp.MustCompile(`^[0-9]+$`)"},
+		{desc: "meta field", code: "Meta *Meta `json:\"-\"`"},
+		{desc: "meta setter", code: "func (r *Receipt) setMeta(m *Meta) { r.Meta = m }"},
+	}
+
+	for _, tc := range cases {
+		assert.Contains(t, string(src), tc.code, fmt.Sprintf("%s: expected %s\n", tc.desc, tc.code))
+	}
+
+	src, err = s.Generate("payments", "spec.yaml")
+	assert.Nil(t, err)
+	assert.NotContains(t, string(src), "type Payment struct", "expected no types for another package")
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := ratelimit.NewTokenBucket(1, 3)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, b.Wait(ctx), "expected burst token to be available")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, b.Wait(ctx), "expected empty bucket to block until deadline")
+	assert.True(t, b.Tokens() < 1, "expected no token to be consumed by cancelled wait")
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := ratelimit.NewTokenBucket(100, 1)
+	ctx := context.Background()
+
+	start := time.Now()
+	assert.Nil(t, b.Wait(ctx))
+	assert.Nil(t, b.Wait(ctx))
+	assert.True(t, time.Since(start) >= 5*time.Millisecond, "expected second token to wait for refill")
+}
+
+func TestSemaphore(t *testing.T) {
+	s := ratelimit.NewSemaphore(1)
+	ctx := context.Background()
+
+	assert.Nil(t, s.Acquire(ctx))
+	assert.Equal(t, 1, s.InFlight())
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Acquire(cctx), "expected full semaphore to block until deadline")
+
+	done := make(chan error)
+	go func() { done <- s.Acquire(ctx) }()
+
+	for s.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Release()
+
+	assert.Nil(t, <-done, "expected waiter to acquire released slot")
+	assert.Equal(t, 1, s.InFlight())
+	assert.Equal(t, 0, s.Waiting())
+}
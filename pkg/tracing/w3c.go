@@ -0,0 +1,232 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package tracing provides a dependency free mpesa.Tracer that propagates
+// W3C trace context and hands finished spans to an exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// TraceParentHeader is the W3C trace context propagation header.
+const TraceParentHeader = "traceparent"
+
+var (
+	ErrInvalidTraceParent = errors.New("invalid traceparent header")
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has non-zero trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses a traceparent header value.
+func ParseTraceParent(v string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceParent
+	}
+
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, ErrInvalidTraceParent
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, errors.Wrap(ErrInvalidTraceParent, err)
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, errors.Wrap(ErrInvalidTraceParent, err)
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, errors.Wrap(ErrInvalidTraceParent, err)
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc as the parent of
+// spans started from it.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract returns the request context carrying the span context of an
+// incoming traceparent header, so that M-Pesa calls made while serving r
+// join the caller's trace. The request context is returned unchanged when
+// the header is missing or malformed.
+func Extract(r *http.Request) context.Context {
+	sc, err := ParseTraceParent(r.Header.Get(TraceParentHeader))
+	if err != nil {
+		return r.Context()
+	}
+	return ContextWithSpanContext(r.Context(), sc)
+}
+
+// SpanData describes a finished span.
+type SpanData struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID [8]byte
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Err          error
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	Export(SpanData)
+}
+
+// ExporterFunc adapts a function to the Exporter interface.
+type ExporterFunc func(SpanData)
+
+// Export calls f(sd).
+func (f ExporterFunc) Export(sd SpanData) {
+	f(sd)
+}
+
+var _ mpesa.Tracer = (*Tracer)(nil)
+
+// Tracer is an mpesa.Tracer generating W3C compatible span contexts.
+type Tracer struct {
+	exporter Exporter
+}
+
+// New returns a Tracer handing every finished span to exp. exp may be nil
+// when only traceparent propagation is wanted.
+func New(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// Start implements mpesa.Tracer.
+func (t *Tracer) Start(ctx context.Context, operation string) (context.Context, mpesa.Span) {
+	s := &span{
+		tracer:     t,
+		name:       operation,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok && parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	rand.Read(s.sc.SpanID[:])
+
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+type span struct {
+	tracer *Tracer
+	name   string
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu         sync.Mutex
+	attributes map[string]string
+	ended      bool
+}
+
+func (s *span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *span) TraceParent() string {
+	return s.sc.TraceParent()
+}
+
+func (s *span) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+
+	attrs := make(map[string]string, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	s.mu.Unlock()
+
+	if s.tracer.exporter == nil {
+		return
+	}
+
+	s.tracer.exporter.Export(SpanData{
+		Name:         s.name,
+		SpanContext:  s.sc,
+		ParentSpanID: s.parent,
+		Start:        s.start,
+		End:          time.Now(),
+		Attributes:   attrs,
+		Err:          err,
+	})
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	cases := []struct {
+		desc  string
+		value string
+		valid bool
+	}{
+		{
+			desc:  "valid sampled header",
+			value: parent,
+			valid: true,
+		},
+		{
+			desc:  "valid unsampled header",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			valid: true,
+		},
+		{
+			desc:  "empty header",
+			value: "",
+			valid: false,
+		},
+		{
+			desc:  "zero trace id",
+			value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			valid: false,
+		},
+		{
+			desc:  "forbidden version",
+			value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			valid: false,
+		},
+		{
+			desc:  "non hex span id",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+			valid: false,
+		},
+	}
+
+	for _, tc := range cases {
+		sc, err := tracing.ParseTraceParent(tc.value)
+		assert.Equal(t, tc.valid, err == nil, fmt.Sprintf("%s: unexpected error %v\n", tc.desc, err))
+		if tc.valid {
+			assert.Equal(t, tc.value, sc.TraceParent(), fmt.Sprintf("%s: expected round trip\n", tc.desc))
+		}
+	}
+}
+
+func TestTracerContinuesIncomingTrace(t *testing.T) {
+	var exported []tracing.SpanData
+	tracer := tracing.New(tracing.ExporterFunc(func(sd tracing.SpanData) {
+		exported = append(exported, sd)
+	}))
+
+	r := httptest.NewRequest("POST", "/pay", nil)
+	r.Header.Set(tracing.TraceParentHeader, parent)
+
+	_, span := tracer.Start(tracing.Extract(r), "c2bPayment")
+	span.SetAttribute("mpesa.market", "vodacomTZN")
+	tp := span.TraceParent()
+	span.End(nil)
+	span.End(nil)
+
+	assert.True(t, strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-"), "expected span to join incoming trace")
+	assert.NotEqual(t, parent, tp, "expected a new span id")
+	assert.Len(t, exported, 1, "expected span to be exported once")
+	assert.Equal(t, "c2bPayment", exported[0].Name)
+	assert.Equal(t, "vodacomTZN", exported[0].Attributes["mpesa.market"])
+}
+
+func TestTracerStartsNewTrace(t *testing.T) {
+	tracer := tracing.New(nil)
+
+	ctx, span := tracer.Start(context.Background(), "getSession")
+	sc, ok := tracing.SpanContextFromContext(ctx)
+
+	assert.True(t, ok, "expected span context in returned context")
+	assert.True(t, sc.IsValid(), "expected valid span context")
+	assert.Equal(t, sc.TraceParent(), span.TraceParent())
+	span.End(nil)
+}
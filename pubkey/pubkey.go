@@ -0,0 +1,150 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package pubkey loads the OpenAPI RSA public keys used to encrypt
+// application keys, and reports their fingerprints.
+package pubkey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+// Public keys published on the OpenAPI portal, base64 encoded DER.
+const (
+	Sandbox    = `MIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEArv9yxA69XQKBo24BaF/D+fvlqmGdYjqLQ5WtNBb5tquqGvAvG3WMFETVUSow/LizQalxj2ElMVrUmzu5mGGkxK08bWEXF7a1DEvtVJs6nppIlFJc2SnrU14AOrIrB28ogm58JjAl5BOQawOXD5dfSk7MaAA82pVHoIqEu0FxA8BOKU+RGTihRU+ptw1j4bsAJYiPbSX6i71gfPvwHPYamM0bfI4CmlsUUR3KvCG24rB6FNPcRBhM3jDuv8ae2kC33w9hEq8qNB55uw51vK7hyXoAa+U7IqP1y6nBdlN25gkxEA8yrsl1678cspeXr+3ciRyqoRgj9RD/ONbJhhxFvt1cLBh+qwK2eqISfBb06eRnNeC71oBokDm3zyCnkOtMDGl7IvnMfZfEPFCfg5QgJVk1msPpRvQxmEsrX9MQRyFVzgy2CWNIb7c+jPapyrNwoUbANlN8adU1m6yOuoX7F49x+OjiG2se0EJ6nafeKUXw/+hiJZvELUYgzKUtMAZVTNZfT8jjb58j8GVtuS+6TM2AutbejaCV84ZK58E2CRJqhmjQibEUO6KPdD7oTlEkFy52Y1uOOBXgYpqMzufNPmfdqqqSM4dU70PO8ogyKGiLAIxCetMjjm6FCMEA3Kc8K0Ig7/XtFm9By6VxTJK1Mg36TlHaZKP6VzVLXMtesJECAwEAAQ==`
+	Production = `MIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEAietPTdEyyoV/wvxRjS5pSn3ZBQH9hnVtQC9SFLgM9IkomEX9Vu9fBg2MzWSSqkQlaYIGFGH3d69Q5NOWkRo+Y8p5a61sc9hZ+ItAiEL9KIbZzhnMwi12jUYCTff0bVTsTGSNUePQ2V42sToOIKCeBpUtwWKhhW3CSpK7S1iJhS9H22/BT/pk21Jd8btwMLUHfVD95iXbHNM8u6vFaYuHczx966T7gpa9RGGXRtiOr3ScJq1515tzOSOsHTPHLTun59nxxJiEjKoI4Lb9h6IlauvcGAQHp5q6/2XmxuqZdGzh39uLac8tMSmY3vC3fiHYC3iMyTb7eXqATIhDUOf9mOSbgZMS19iiVZvz8igDl950IMcelJwcj0qCLoufLE5y8ud5WIw47OCVkD7tcAEPmVWlCQ744SIM5afw+Jg50T1SEtu3q3GiL0UQ6KTLDyDEt5BL9HWXAIXsjFdPDpX1jtxZavVQV+Jd7FXhuPQuDbh12liTROREdzatYWRnrhzeOJ5Se9xeXLvYSj8DmAI4iFf2cVtWCzj/02uK4+iIGXlX7lHP1W+tycLS7Pe2RdtC2+oz5RSSqb5jI4+3iEY/vZjSMBVk69pCDzZy4ZE8LBgyEvSabJ/cddwWmShcRS+21XvGQ1uXYLv0FCTEHHobCfmn2y8bJBb/Hct53BaojWUCAwEAAQ==`
+)
+
+var (
+	ErrDecode = errors.New("failed to decode public key")
+
+	ErrParse = errors.New("failed to parse public key")
+
+	ErrNotRSA = errors.New("not rsa public key")
+
+	ErrEncrypt = errors.New("failed to encrypt api key")
+)
+
+// Parse reads an RSA public key encoded as PEM, DER or base64 encoded DER.
+// Both PKIX ("PUBLIC KEY") and PKCS #1 ("RSA PUBLIC KEY") encodings are
+// accepted.
+func Parse(data []byte) (*rsa.PublicKey, error) {
+	data = bytes.TrimSpace(data)
+
+	if block, _ := pem.Decode(data); block != nil {
+		return parseDER(block.Bytes)
+	}
+
+	if pk, err := parseDER(data); err == nil {
+		return pk, nil
+	}
+
+	return ParseBase64(string(data))
+}
+
+// ParseBase64 reads an RSA public key encoded as base64 DER, the format
+// the OpenAPI portal publishes keys in. Whitespace is ignored.
+func ParseBase64(s string) (*rsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, errors.Wrap(ErrDecode, err)
+	}
+
+	return parseDER(der)
+}
+
+// LoadFile reads an RSA public key from a PEM, DER or base64 file.
+func LoadFile(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+func parseDER(der []byte) (*rsa.PublicKey, error) {
+	if pk, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return pk, nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.Wrap(ErrParse, err)
+	}
+
+	pk, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrNotRSA
+	}
+
+	return pk, nil
+}
+
+// Fingerprint returns the SHA-256 digest of the key's DER encoded
+// SubjectPublicKeyInfo, as "SHA256:" followed by lower case hex.
+func Fingerprint(pk *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pk)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(der)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+// Encrypt encrypts an application key with pk and returns it base64
+// encoded, ready to be sent as the getSession bearer token.
+func Encrypt(pk *rsa.PublicKey, apiKey string) (string, error) {
+	digest, err := rsa.EncryptPKCS1v15(rand.Reader, pk, []byte(apiKey))
+	if err != nil {
+		return "", errors.Wrap(ErrEncrypt, err)
+	}
+
+	return base64.StdEncoding.EncodeToString(digest), nil
+}
+
+// Set is the key an application encrypts with, plus the key it falls back
+// to while the OpenAPI portal rotates keys.
+type Set struct {
+	Primary  *rsa.PublicKey
+	Fallback *rsa.PublicKey
+}
+
+// Fingerprints returns the fingerprints of the primary and fallback keys,
+// empty for a missing key.
+func (s Set) Fingerprints() (primary, fallback string) {
+	if s.Primary != nil {
+		primary = Fingerprint(s.Primary)
+	}
+
+	if s.Fallback != nil {
+		fallback = Fingerprint(s.Fallback)
+	}
+
+	return primary, fallback
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package pubkey_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pubkey"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	der, _ := base64.StdEncoding.DecodeString(pubkey.Sandbox)
+	want, err := pubkey.ParseBase64(pubkey.Sandbox)
+	assert.Nil(t, err, "expected published sandbox key to parse")
+
+	pkcs1 := x509.MarshalPKCS1PublicKey(want)
+
+	cases := []struct {
+		desc string
+		data []byte
+		err  error
+	}{
+		{
+			desc: "base64 DER",
+			data: []byte(pubkey.Sandbox),
+		},
+		{
+			desc: "base64 DER with line breaks",
+			data: []byte(pubkey.Sandbox[:64] + "\n" + pubkey.Sandbox[64:] + "\n"),
+		},
+		{
+			desc: "raw DER",
+			data: der,
+		},
+		{
+			desc: "PKIX PEM",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		},
+		{
+			desc: "PKCS1 PEM",
+			data: pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pkcs1}),
+		},
+		{
+			desc: "invalid base64",
+			data: []byte("not a key!"),
+			err:  pubkey.ErrDecode,
+		},
+		{
+			desc: "valid base64 of garbage",
+			data: []byte(base64.StdEncoding.EncodeToString([]byte("garbage"))),
+			err:  pubkey.ErrParse,
+		},
+	}
+
+	for _, tc := range cases {
+		pk, err := pubkey.Parse(tc.data)
+		if tc.err != nil {
+			assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %v got %v\n", tc.desc, tc.err, err))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error\n", tc.desc))
+		assert.Equal(t, pubkey.Fingerprint(want), pubkey.Fingerprint(pk), fmt.Sprintf("%s: expected same key\n", tc.desc))
+	}
+}
+
+func TestLoadFileAndFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpesa-pubkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	der, _ := base64.StdEncoding.DecodeString(pubkey.Production)
+	path := filepath.Join(dir, "production.pem")
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	pk, err := pubkey.LoadFile(path)
+	assert.Nil(t, err)
+
+	fp := pubkey.Fingerprint(pk)
+	assert.True(t, strings.HasPrefix(fp, "SHA256:"))
+	assert.Len(t, fp, len("SHA256:")+64)
+
+	sandbox, _ := pubkey.ParseBase64(pubkey.Sandbox)
+	primary, fallback := pubkey.Set{Primary: pk, Fallback: sandbox}.Fingerprints()
+	assert.Equal(t, fp, primary)
+	assert.NotEqual(t, primary, fallback)
+
+	enc, err := pubkey.Encrypt(pk, "api-key")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, enc)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"crypto/rsa"
+	"sync"
+
+	"github.com/mobilemoney/mpesa/pubkey"
+)
+
+type publicKeyID struct {
+	market Market
+	env    APIEnviroment
+}
+
+var (
+	publicKeysMu sync.RWMutex
+
+	// publicKeys holds the keys used to encrypt application keys, an empty
+	// market applies to every market of the environment.
+	publicKeys = map[publicKeyID]pubkey.Set{
+		{env: Sandbox}:    {Primary: mustParse(pubkey.Sandbox)},
+		{env: Production}: {Primary: mustParse(pubkey.Production)},
+	}
+)
+
+func mustParse(b64 string) *rsa.PublicKey {
+	pk, err := pubkey.ParseBase64(b64)
+	if err != nil {
+		panic(err)
+	}
+	return pk
+}
+
+// SetPublicKey overrides the public key used to encrypt application keys of
+// market in env. An empty market applies to every market of env. Keys are
+// looked up every time a session is generated, so the override applies to
+// the next session of every application, existing ones included, except
+// those configured with WithPublicKey or a secret provider supplying a
+// public key. fallback may be nil; when set, a session request whose key
+// is rejected with primary, with 401, 403 or INS-2, is retried with
+// fallback, which keeps applications working while the OpenAPI portal
+// rotates keys.
+func SetPublicKey(market Market, env APIEnviroment, primary, fallback *rsa.PublicKey) {
+	publicKeysMu.Lock()
+	publicKeys[publicKeyID{market, env}] = pubkey.Set{Primary: primary, Fallback: fallback}
+	publicKeysMu.Unlock()
+}
+
+// lookupPublicKey returns the keys configured for market in env.
+func lookupPublicKey(market Market, env APIEnviroment) pubkey.Set {
+	publicKeysMu.RLock()
+	defer publicKeysMu.RUnlock()
+
+	if set, ok := publicKeys[publicKeyID{market, env}]; ok {
+		return set
+	}
+	return publicKeys[publicKeyID{env: env}]
+}
+
+// WithPublicKey sets the public key this application encrypts its key
+// with, and an optional fallback key, see SetPublicKey.
+func WithPublicKey(primary, fallback *rsa.PublicKey) Option {
+	return func(app *Application) {
+		app.publicKey = &pubkey.Set{Primary: primary, Fallback: fallback}
+	}
+}
+
+// PublicKeyFingerprint returns the fingerprint of the public key the
+// current session was generated with, see pubkey.Fingerprint.
+func (app *Application) PublicKeyFingerprint() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.keyFingerprint
+}
+
+// PublicKeyFingerprints returns the fingerprints of the configured primary
+// and fallback public keys.
+func (app *Application) PublicKeyFingerprints() (primary, fallback string) {
+	if app.publicKey != nil {
+		return app.publicKey.Fingerprints()
+	}
+	return lookupPublicKey(app.market, app.Type).Fingerprints()
+}
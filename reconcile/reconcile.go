@@ -0,0 +1,220 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package reconcile matches the entries of an M-Pesa organisation statement
+// against the transactions of a ledger.
+//
+//	entries, err := reconcile.ParseStatement(f, reconcile.DefaultColumns)
+//	txs, err := l.ByDate(ctx, day, day.AddDate(0, 0, 1))
+//	report := reconcile.Reconcile(entries, txs)
+//	err = reconcile.Resolve(ctx, app, report)
+//
+// Entries are matched to transactions by transaction ID, conversation ID or
+// reference, in that order.
+package reconcile
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/ledger"
+)
+
+// Kind classifies a reconciled item.
+type Kind string
+
+const (
+	// Matched items are in both the statement and the ledger, with the same amount.
+	Matched Kind = "matched"
+
+	// AmountMismatch items are in both with different amounts.
+	AmountMismatch Kind = "amount_mismatch"
+
+	// Missing items are completed in the ledger but not in the statement.
+	Missing Kind = "missing"
+
+	// Unknown items are in the statement but not in the ledger.
+	Unknown Kind = "unknown"
+
+	// Ambiguous items are in the ledger without a final status, or are in
+	// the statement while the ledger holds them as failed. Resolve settles
+	// them with a status query.
+	Ambiguous Kind = "ambiguous"
+
+	// Resolved items were ambiguous until a status query found the
+	// transaction was not made, leaving nothing to reconcile.
+	Resolved Kind = "resolved"
+)
+
+// Item is the outcome of reconciling a statement entry, a transaction or both.
+type Item struct {
+	Kind        Kind
+	Entry       *Entry
+	Transaction *ledger.Transaction
+
+	// QueryStatus is the transaction status returned by Resolve.
+	QueryStatus string
+}
+
+// Report lists every reconciled item, statement entries first in statement
+// order then unmatched transactions in ledger order.
+type Report struct {
+	Items []*Item
+}
+
+// Count returns the number of items of kind.
+func (r *Report) Count(kind Kind) int {
+	return len(r.Filter(kind))
+}
+
+// Filter returns the items of kind.
+func (r *Report) Filter(kind Kind) []*Item {
+	var items []*Item
+	for _, item := range r.Items {
+		if item.Kind == kind {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Reconcile matches entries against txs. Statement entries with a status
+// other than Completed are skipped, as are failed transactions without an
+// entry, which is where they are expected to be.
+func Reconcile(entries []Entry, txs []*ledger.Transaction) *Report {
+	index := make(map[string]*ledger.Transaction, 3*len(txs))
+	for _, tx := range txs {
+		for _, ref := range []string{tx.ID, tx.TransactionID, tx.ConversationID, tx.Reference} {
+			if ref != "" {
+				if _, ok := index[ref]; !ok {
+					index[ref] = tx
+				}
+			}
+		}
+	}
+
+	report := &Report{}
+	seen := make(map[*ledger.Transaction]bool, len(txs))
+
+	for i := range entries {
+		e := &entries[i]
+		if e.Status != "" && !strings.EqualFold(e.Status, "Completed") {
+			continue
+		}
+
+		var tx *ledger.Transaction
+		for _, ref := range []string{e.TransactionID, e.ConversationID, e.Reference} {
+			if tx = index[ref]; ref != "" && tx != nil && !seen[tx] {
+				break
+			}
+			tx = nil
+		}
+
+		if tx == nil {
+			report.Items = append(report.Items, &Item{Kind: Unknown, Entry: e})
+			continue
+		}
+
+		seen[tx] = true
+		kind := Ambiguous
+		if tx.Status == ledger.Completed || tx.Status == ledger.Reversed {
+			kind = compare(e, tx)
+		}
+		report.Items = append(report.Items, &Item{Kind: kind, Entry: e, Transaction: tx})
+	}
+
+	for _, tx := range txs {
+		if seen[tx] {
+			continue
+		}
+
+		switch tx.Status {
+		case ledger.Completed, ledger.Reversed:
+			report.Items = append(report.Items, &Item{Kind: Missing, Transaction: tx})
+		case ledger.Failed:
+		default:
+			report.Items = append(report.Items, &Item{Kind: Ambiguous, Transaction: tx})
+		}
+	}
+
+	return report
+}
+
+// compare classifies an entry matched to a completed transaction.
+func compare(e *Entry, tx *ledger.Transaction) Kind {
+	a, errA := parseAmount(e.Amount)
+	b, errB := parseAmount(tx.Amount)
+	if errA != nil || errB != nil || a != b {
+		return AmountMismatch
+	}
+	return Matched
+}
+
+// Querier queries the status of a transaction, *mpesa.Application implements it.
+type Querier interface {
+	QueryTransactionStatus(ctx context.Context, q mpesa.StatusQuery) (*mpesa.StatusResp, error)
+}
+
+// Resolve queries the status of every ambiguous item and reclassifies it.
+// Completed transactions become Matched, AmountMismatch or Missing, and
+// transactions that were not made become Resolved unless the statement has
+// them. Items still pending stay ambiguous. When the application journals
+// to the ledger, the queries also update the ledger.
+//
+// Resolve stops at the first failed query and returns its error, items
+// resolved until then are kept.
+func Resolve(ctx context.Context, q Querier, r *Report) error {
+	for _, item := range r.Filter(Ambiguous) {
+		resp, err := q.QueryTransactionStatus(ctx, mpesa.StatusQuery{QueryReference: reference(item)})
+		if err != nil {
+			return err
+		}
+
+		item.QueryStatus = resp.ResponseTransactionStatus
+
+		switch ledger.StatusFromQuery(resp.ResponseTransactionStatus) {
+		case ledger.Completed, ledger.Reversed:
+			switch {
+			case item.Entry == nil:
+				item.Kind = Missing
+			case item.Transaction == nil:
+				item.Kind = Unknown
+			default:
+				item.Kind = compare(item.Entry, item.Transaction)
+			}
+
+		case ledger.Failed:
+			if item.Entry == nil {
+				item.Kind = Resolved
+			}
+		}
+	}
+
+	return nil
+}
+
+// reference returns the best reference to query the status of item with.
+func reference(item *Item) string {
+	if item.Entry != nil {
+		return item.Entry.TransactionID
+	}
+
+	if item.Transaction.TransactionID != "" {
+		return item.Transaction.TransactionID
+	}
+	return item.Transaction.ID
+}
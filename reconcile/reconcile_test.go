@@ -0,0 +1,123 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package reconcile_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/ledger"
+	"github.com/mobilemoney/mpesa/reconcile"
+	"github.com/stretchr/testify/assert"
+)
+
+const statement = `Account Name,Acme Ltd
+Time Period,01-06-2020 - 01-06-2020
+
+Receipt No.,Completion Time,Details,Transaction Status,Paid In,Withdrawn,Balance,Other Party Info,A/C No.
+TX1,2020-06-01 09:00:00,Business Payment,Completed,,"-1,000.00",9000.00,255744553111,ref-1
+TX2,2020-06-01 10:00:00,Business Payment,Completed,,-50.00,8950.00,255744553112,ref-2
+TX3,2020-06-01 11:00:00,Customer Payment,Completed,20,,8970.00,255744553113,ref-3
+TX4,2020-06-01 12:00:00,Business Payment,Completed,,-5.00,8965.00,255744553114,ref-4
+TX5,2020-06-01 13:00:00,Business Payment,Failed,,-7.00,8965.00,255744553115,ref-5
+TX6,2020-06-01 14:00:00,Business Payment,Completed,,-8.00,8957.00,255744553116,ref-6
+`
+
+func TestParseStatement(t *testing.T) {
+	cases := []struct {
+		desc    string
+		input   string
+		entries int
+		amount  string
+		err     bool
+	}{
+		{desc: "parse statement with summary", input: statement, entries: 6, amount: "1000.00"},
+		{desc: "parse statement without header", input: "TX1,1.00\n", err: true},
+		{desc: "parse statement with invalid amount", input: "Receipt No.,Paid In\nTX1,abc\n", err: true},
+	}
+
+	for _, tc := range cases {
+		entries, err := reconcile.ParseStatement(strings.NewReader(tc.input), reconcile.DefaultColumns)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: expected error %t got %v\n", tc.desc, tc.err, err))
+		assert.Equal(t, tc.entries, len(entries), fmt.Sprintf("%s: expected %d entries got %d\n", tc.desc, tc.entries, len(entries)))
+		if len(entries) > 0 {
+			assert.Equal(t, tc.amount, entries[0].Amount, fmt.Sprintf("%s: expected amount %s got %s\n", tc.desc, tc.amount, entries[0].Amount))
+		}
+	}
+}
+
+type querier map[string]string
+
+func (q querier) QueryTransactionStatus(_ context.Context, sq mpesa.StatusQuery) (*mpesa.StatusResp, error) {
+	return &mpesa.StatusResp{Code: "INS-0", ResponseTransactionStatus: q[sq.QueryReference]}, nil
+}
+
+func TestReconcile(t *testing.T) {
+	entries, err := reconcile.ParseStatement(strings.NewReader(statement), reconcile.DefaultColumns)
+	assert.Nil(t, err, fmt.Sprintf("parse statement: expected no error got %v\n", err))
+
+	txs := []*ledger.Transaction{
+		{ID: "a", TransactionID: "TX1", Amount: "1000", Status: ledger.Completed},
+		{ID: "b", Reference: "ref-2", Amount: "55.00", Status: ledger.Completed},
+		{ID: "c", TransactionID: "TX4", Amount: "5", Status: ledger.Pending},
+		{ID: "d", TransactionID: "TX7", Amount: "9", Status: ledger.Completed},
+		{ID: "e", Amount: "9", Status: ledger.Pending},
+		{ID: "f", Amount: "9", Status: ledger.Initiated},
+		{ID: "g", Amount: "9", Status: ledger.Failed},
+	}
+
+	report := reconcile.Reconcile(entries, txs)
+
+	cases := []struct {
+		desc  string
+		kind  reconcile.Kind
+		count int
+	}{
+		{desc: "match by transaction ID", kind: reconcile.Matched, count: 1},
+		{desc: "match by reference with other amount", kind: reconcile.AmountMismatch, count: 1},
+		{desc: "report completed transaction not in statement", kind: reconcile.Missing, count: 1},
+		{desc: "report statement entries not in ledger", kind: reconcile.Unknown, count: 2},
+		{desc: "report pending transactions", kind: reconcile.Ambiguous, count: 3},
+	}
+
+	for _, tc := range cases {
+		count := report.Count(tc.kind)
+		assert.Equal(t, tc.count, count, fmt.Sprintf("%s: expected %d %s items got %d\n", tc.desc, tc.count, tc.kind, count))
+	}
+
+	err = reconcile.Resolve(context.Background(), querier{"TX4": "Completed", "e": "Completed", "f": "Failed"}, report)
+	assert.Nil(t, err, fmt.Sprintf("resolve: expected no error got %v\n", err))
+
+	cases = []struct {
+		desc  string
+		kind  reconcile.Kind
+		count int
+	}{
+		{desc: "resolve completed transaction in statement", kind: reconcile.Matched, count: 2},
+		{desc: "resolve completed transaction not in statement", kind: reconcile.Missing, count: 2},
+		{desc: "resolve failed transaction not in statement", kind: reconcile.Resolved, count: 1},
+		{desc: "leave unresolved transactions", kind: reconcile.Ambiguous, count: 0},
+	}
+
+	for _, tc := range cases {
+		count := report.Count(tc.kind)
+		assert.Equal(t, tc.count, count, fmt.Sprintf("%s: expected %d %s items got %d\n", tc.desc, tc.count, tc.kind, count))
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package reconcile
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a line of an M-Pesa organisation statement.
+type Entry struct {
+
+	// Line is the 1-based line of the entry in the statement.
+	Line int
+
+	TransactionID  string
+	ConversationID string
+	Reference      string
+	Party          string
+	Details        string
+	Status         string
+	Time           time.Time
+
+	// Amount is the absolute amount paid in or withdrawn.
+	Amount string
+}
+
+// Columns names the statement columns read into an Entry, matched case
+// insensitively. Columns that are empty or missing from the statement are
+// left empty in entries, except TransactionID which is required.
+type Columns struct {
+	TransactionID  string
+	ConversationID string
+	Reference      string
+	Party          string
+	Details        string
+	Status         string
+	Time           string
+
+	// PaidIn and Withdrawn are read into Amount, whichever is not empty.
+	// Amount is used instead for statements with a single amount column.
+	PaidIn    string
+	Withdrawn string
+	Amount    string
+}
+
+// DefaultColumns are the columns of the M-Pesa organisation statement export.
+var DefaultColumns = Columns{
+	TransactionID:  "Receipt No.",
+	ConversationID: "Conversation ID",
+	Reference:      "A/C No.",
+	Party:          "Other Party Info",
+	Details:        "Details",
+	Status:         "Transaction Status",
+	Time:           "Completion Time",
+	PaidIn:         "Paid In",
+	Withdrawn:      "Withdrawn",
+}
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"02-01-2006 15:04:05",
+	"02/01/2006 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// ParseStatement reads the entries of a statement in CSV. Lines before the
+// header, i.e. the first line with a cols.TransactionID column, are skipped
+// as statements start with a summary of the account.
+func ParseStatement(r io.Reader, cols Columns) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var (
+		entries []Entry
+		index   map[string]int
+	)
+
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if index == nil {
+			index = header(rec, cols.TransactionID)
+			continue
+		}
+
+		field := func(name string) string {
+			i, ok := index[strings.ToLower(name)]
+			if !ok || name == "" || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+
+		e := Entry{
+			Line:           line,
+			TransactionID:  field(cols.TransactionID),
+			ConversationID: field(cols.ConversationID),
+			Reference:      field(cols.Reference),
+			Party:          field(cols.Party),
+			Details:        field(cols.Details),
+			Status:         field(cols.Status),
+		}
+
+		if e.TransactionID == "" {
+			// blank and footer lines
+			continue
+		}
+
+		amount := field(cols.Amount)
+		for _, col := range []string{cols.PaidIn, cols.Withdrawn} {
+			if amount == "" {
+				amount = field(col)
+			}
+		}
+
+		if e.Amount, err = normalizeAmount(amount); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if t := field(cols.Time); t != "" {
+			if e.Time, err = parseTime(t); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	if index == nil {
+		return nil, fmt.Errorf("no %q column in statement", cols.TransactionID)
+	}
+
+	return entries, nil
+}
+
+// header returns the column indexes of rec by lower case name if it has
+// column id, otherwise nil.
+func header(rec []string, id string) map[string]int {
+	index := make(map[string]int, len(rec))
+	for i, name := range rec {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := index[strings.ToLower(id)]; !ok {
+		return nil
+	}
+	return index
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// normalizeAmount strips thousands separators and signs from s and formats it
+// with two decimals.
+func normalizeAmount(s string) (string, error) {
+	cents, err := parseAmount(s)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100), nil
+}
+
+// parseAmount returns the absolute amount s in cents.
+func parseAmount(s string) (int64, error) {
+	clean := strings.TrimLeft(strings.Replace(strings.TrimSpace(s), ",", "", -1), "+-")
+	if clean == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	units, frac := clean, ""
+	if i := strings.IndexByte(clean, '.'); i >= 0 {
+		units, frac = clean[:i], clean[i+1:]
+	}
+
+	if len(frac) > 2 || (units == "" && frac == "") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if units == "" {
+		units = "0"
+	}
+
+	n, err := strconv.ParseUint(units+frac, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return int64(n), nil
+}
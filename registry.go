@@ -0,0 +1,199 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownTenant is returned when resolving a tenant that has not been registered.
+	ErrUnknownTenant = errors.New("mpesa: unknown tenant")
+
+	// ErrTenantExists is returned when registering a tenant ID twice.
+	ErrTenantExists = errors.New("mpesa: tenant already registered")
+)
+
+// TenantConfig describes the application a tenant operates with.
+type TenantConfig struct {
+
+	// Key is the application key, see NewApplication. It may be empty when
+	// a secret provider is among the options, see WithSecretProvider.
+	Key string
+
+	Market Market
+
+	Environment APIEnviroment
+
+	// ServiceProviderCode is the tenant's organisation shortcode.
+	ServiceProviderCode string
+
+	// Options are applied after the registry wide options.
+	Options []Option
+}
+
+type tenant struct {
+	cfg TenantConfig
+
+	// mu serialises creation of app so that concurrent first callers
+	// generate a single session.
+	mu  sync.Mutex
+	app *Application
+}
+
+// Registry holds the applications of many tenants, e.g. one per merchant
+// shortcode and market. Applications share the registry's HTTP client and
+// are created, with their own session, the first time they are resolved.
+type Registry struct {
+	client *http.Client
+	opts   []Option
+
+	mu      sync.RWMutex
+	tenants map[string]*tenant
+}
+
+// NewRegistry returns an empty Registry. client is shared by every
+// application, http.DefaultClient is used when it is nil. opts are applied
+// to every application before the tenant's own options.
+func NewRegistry(client *http.Client, opts ...Option) *Registry {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Registry{
+		client:  client,
+		opts:    opts,
+		tenants: make(map[string]*tenant),
+	}
+}
+
+// Register adds a tenant. No request is made until the tenant is resolved.
+func (r *Registry) Register(tenantID string, cfg TenantConfig) error {
+	if cfg.Key == "" && !r.hasSecretProvider(cfg) {
+		return fmt.Errorf("mpesa: tenant %q: key or secret provider is required", tenantID)
+	}
+
+	if tenantID == "" || cfg.Market == "" || cfg.Environment == "" {
+		return fmt.Errorf("mpesa: tenant %q: market and environment are required", tenantID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tenants[tenantID]; ok {
+		return fmt.Errorf("%w: %s", ErrTenantExists, tenantID)
+	}
+	r.tenants[tenantID] = &tenant{cfg: cfg}
+
+	return nil
+}
+
+// hasSecretProvider reports whether the options of cfg, or the registry
+// wide ones, configure a secret provider.
+func (r *Registry) hasSecretProvider(cfg TenantConfig) bool {
+	app := &Application{}
+	for _, opt := range append(append([]Option{}, r.opts...), cfg.Options...) {
+		opt(app)
+	}
+	return app.secrets != nil
+}
+
+// Remove forgets a tenant and its application.
+func (r *Registry) Remove(tenantID string) {
+	r.mu.Lock()
+	delete(r.tenants, tenantID)
+	r.mu.Unlock()
+}
+
+// Tenants returns the registered tenant IDs in sorted order.
+func (r *Registry) Tenants() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.tenants))
+	for id := range r.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Application resolves the application of tenantID, generating its session
+// on first use.
+func (r *Registry) Application(tenantID string) (*Application, error) {
+	app, _, err := r.application(tenantID)
+	return app, err
+}
+
+// application resolves the application of tenantID and reports whether it
+// was created, and so given a new session, by this call.
+func (r *Registry) application(tenantID string) (*Application, bool, error) {
+	t, err := r.tenant(tenantID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.app != nil {
+		return t.app, false, nil
+	}
+
+	opts := make([]Option, 0, len(r.opts)+len(t.cfg.Options)+2)
+	opts = append(opts, WithHTTPClient(r.client), WithServiceProviderCode(t.cfg.ServiceProviderCode))
+	opts = append(opts, r.opts...)
+	opts = append(opts, t.cfg.Options...)
+
+	app, err := NewApplication(t.cfg.Key, t.cfg.Market, t.cfg.Environment, opts...)
+	if err != nil {
+		return nil, false, fmt.Errorf("mpesa: tenant %s: %w", tenantID, err)
+	}
+	t.app = app
+
+	return app, true, nil
+}
+
+// RefreshSession generates a new session key for tenantID's application. An
+// application created by the call already has a new session key and is left
+// as is.
+func (r *Registry) RefreshSession(tenantID string) error {
+	app, created, err := r.application(tenantID)
+	if err != nil || created {
+		return err
+	}
+
+	_, err = app.getSessionKey()
+	return err
+}
+
+func (r *Registry) tenant(tenantID string) (*tenant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.tenants[tenantID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenantID)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRegistry returns a registry of tenants a and b sending their requests
+// to api.
+func newRegistry(t *testing.T, api *mpesatest.API) *mpesa.Registry {
+	r := mpesa.NewRegistry(&http.Client{Transport: api}, mpesa.WithPublicKey(&mpesatest.Key().PublicKey, nil))
+
+	for _, id := range []string{"a", "b"} {
+		cfg := mpesa.TenantConfig{Key: "key-" + id, Market: mpesa.VodacomTanzania, Environment: mpesa.Sandbox, ServiceProviderCode: id}
+		if err := r.Register(id, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	cases := []struct {
+		desc     string
+		resolve  func(r *mpesa.Registry) error
+		err      error
+		sessions int
+	}{
+		{
+			desc:     "registered tenant",
+			resolve:  func(r *mpesa.Registry) error { _, err := r.Application("a"); return err },
+			sessions: 1,
+		},
+		{
+			desc: "tenants sharing the client",
+			resolve: func(r *mpesa.Registry) error {
+				for _, id := range []string{"a", "b", "a"} {
+					if _, err := r.Application(id); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			sessions: 2,
+		},
+		{
+			desc:    "unknown tenant",
+			resolve: func(r *mpesa.Registry) error { _, err := r.Application("c"); return err },
+			err:     mpesa.ErrUnknownTenant,
+		},
+		{
+			desc:    "unknown tenant session",
+			resolve: func(r *mpesa.Registry) error { return r.RefreshSession("c") },
+			err:     mpesa.ErrUnknownTenant,
+		},
+		{
+			desc: "tenant registered twice",
+			resolve: func(r *mpesa.Registry) error {
+				return r.Register("a", mpesa.TenantConfig{Key: "key", Market: mpesa.VodacomTanzania, Environment: mpesa.Sandbox})
+			},
+			err: mpesa.ErrTenantExists,
+		},
+		{
+			desc:     "session refreshed on first use",
+			resolve:  func(r *mpesa.Registry) error { return r.RefreshSession("a") },
+			sessions: 1,
+		},
+		{
+			desc: "session refreshed after first use",
+			resolve: func(r *mpesa.Registry) error {
+				if err := r.RefreshSession("a"); err != nil {
+					return err
+				}
+				return r.RefreshSession("a")
+			},
+			sessions: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI()
+
+		err := tc.resolve(newRegistry(t, api))
+		if tc.err != nil {
+			assert.True(t, errors.Is(err, tc.err), fmt.Sprintf("%s: expected error %v got %v\n", tc.desc, tc.err, err))
+		} else {
+			assert.NoError(t, err, fmt.Sprintf("%s: expected no error\n", tc.desc))
+		}
+		assert.Equal(t, tc.sessions, api.Requests(mpesa.OpGetSession), fmt.Sprintf("%s: expected %d session requests got %d\n", tc.desc, tc.sessions, api.Requests(mpesa.OpGetSession)))
+	}
+}
+
+func TestRegistrySecretProvider(t *testing.T) {
+	cases := []struct {
+		desc  string
+		opts  []mpesa.Option
+		fails bool
+	}{
+		{desc: "tenant without key", fails: true},
+		{desc: "tenant with a secret provider", opts: []mpesa.Option{mpesa.WithSecretProvider(&rotating{key: "k1"})}},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI()
+		r := newRegistry(t, api)
+
+		err := r.Register("c", mpesa.TenantConfig{Market: mpesa.VodacomTanzania, Environment: mpesa.Sandbox, Options: tc.opts})
+		assert.Equal(t, tc.fails, err != nil, fmt.Sprintf("%s: expected failure %v got %v\n", tc.desc, tc.fails, err))
+		if tc.fails {
+			continue
+		}
+
+		_, err = r.Application("c")
+		assert.NoError(t, err, fmt.Sprintf("%s: expected the application to be created\n", tc.desc))
+		assert.Equal(t, 1, api.Requests(mpesa.OpGetSession), fmt.Sprintf("%s: expected a session request\n", tc.desc))
+	}
+}
+
+func TestRegistryConcurrentFirstUse(t *testing.T) {
+	api := mpesatest.NewAPI()
+	r := newRegistry(t, api)
+
+	apps := make([]*mpesa.Application, 10)
+	var wg sync.WaitGroup
+	for i := range apps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			app, err := r.Application("a")
+			if err != nil {
+				t.Error(err)
+			}
+			apps[i] = app
+		}(i)
+	}
+	wg.Wait()
+
+	for _, app := range apps {
+		assert.Same(t, apps[0], app, "expected every caller to get the same application\n")
+	}
+	assert.Equal(t, 1, api.Requests(mpesa.OpGetSession), "expected a single session to be generated\n")
+}
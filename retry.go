@@ -0,0 +1,60 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WithSessionRetry resends a request rejected with 401 Unauthorized once,
+// with a newly generated session key, e.g. after the session expired. A
+// 401 means the request was not processed, so payments are resent too.
+// Retries are counted by Metrics.IncRetry and Meta.Retries.
+func WithSessionRetry() Option {
+	return func(app *Application) {
+		app.sessionRetry = true
+	}
+}
+
+// canRetry reports whether the request of operation op, answered with meta,
+// may be resent with a new session key.
+func (app *Application) canRetry(op string, req *http.Request, meta *Meta) bool {
+	if !app.sessionRetry || op == OpGetSession || meta == nil || meta.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	return req.Body == nil || req.GetBody != nil
+}
+
+// renewSession generates a new session key and prepares req to be resent
+// with it.
+func (app *Application) renewSession(req *http.Request) error {
+	if _, err := app.getSessionKey(); err != nil {
+		return err
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", app.sessionKey()))
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	accepted     = `{"output_ResponseCode":"INS-0","output_ResponseDesc":"Request processed successfully","output_TransactionID":"tx-1"}`
+	unauthorized = `{"output_ResponseCode":"INS-2","output_ResponseDesc":"Invalid API Key"}`
+)
+
+func TestSessionRetry(t *testing.T) {
+	cases := []struct {
+		desc     string
+		opts     []mpesa.Option
+		replies  []mpesatest.Reply
+		status   int
+		payments int
+		sessions int
+	}{
+		{
+			desc:     "no retry by default",
+			replies:  []mpesatest.Reply{{Status: http.StatusUnauthorized, Body: unauthorized}, {Status: http.StatusCreated, Body: accepted}},
+			status:   http.StatusUnauthorized,
+			payments: 1,
+			sessions: 1,
+		},
+		{
+			desc:     "retried with a new session key",
+			opts:     []mpesa.Option{mpesa.WithSessionRetry()},
+			replies:  []mpesatest.Reply{{Status: http.StatusUnauthorized, Body: unauthorized}, {Status: http.StatusCreated, Body: accepted}},
+			status:   http.StatusCreated,
+			payments: 2,
+			sessions: 2,
+		},
+		{
+			desc:     "retried once",
+			opts:     []mpesa.Option{mpesa.WithSessionRetry()},
+			replies:  []mpesatest.Reply{{Status: http.StatusUnauthorized, Body: unauthorized}},
+			status:   http.StatusUnauthorized,
+			payments: 2,
+			sessions: 2,
+		},
+		{
+			desc:     "other errors not retried",
+			opts:     []mpesa.Option{mpesa.WithSessionRetry()},
+			replies:  []mpesatest.Reply{{Status: http.StatusConflict, Body: `{"output_ResponseCode":"INS-10"}`}},
+			status:   http.StatusConflict,
+			payments: 1,
+			sessions: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI().On(mpesa.OpC2B, tc.replies...)
+		app := mpesatest.NewApplication(t, api, tc.opts...)
+
+		resp, _ := app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"})
+		if assert.NotNil(t, resp.Meta, fmt.Sprintf("%s: expected response meta\n", tc.desc)) {
+			assert.Equal(t, tc.status, resp.Meta.StatusCode, fmt.Sprintf("%s: expected status %d got %d\n", tc.desc, tc.status, resp.Meta.StatusCode))
+		}
+		assert.Equal(t, tc.payments, api.Requests(mpesa.OpC2B), fmt.Sprintf("%s: expected %d payment requests\n", tc.desc, tc.payments))
+		assert.Equal(t, tc.sessions, api.Requests(mpesa.OpGetSession), fmt.Sprintf("%s: expected %d session requests\n", tc.desc, tc.sessions))
+	}
+}
+
+func TestSessionResponseStatus(t *testing.T) {
+	cases := []struct {
+		desc  string
+		reply mpesatest.Reply
+		err   bool
+	}{
+		{
+			desc:  "session key with a non 2xx status",
+			reply: mpesatest.Reply{Status: http.StatusBadRequest, Body: `{"output_ResponseCode":"INS-0","output_SessionID":"session"}`},
+		},
+		{
+			desc:  "error without a session key",
+			reply: mpesatest.Reply{Status: http.StatusUnauthorized, Body: unauthorized},
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		api := mpesatest.NewAPI().On(mpesa.OpGetSession, tc.reply)
+
+		_, err := mpesa.NewApplication("key", mpesa.VodacomTanzania, mpesa.Sandbox,
+			mpesa.WithHTTPClient(&http.Client{Transport: api}),
+			mpesa.WithPublicKey(&mpesatest.Key().PublicKey, nil),
+		)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: expected error %t got %v\n", tc.desc, tc.err, err))
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package secret supplies application keys and public keys from outside the
+// process configuration, so that they need not live in plain environment
+// variables and can be rotated without a restart.
+package secret
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+var (
+	ErrEmptySecret = errors.New("secret is empty")
+
+	ErrNoCommand = errors.New("no command configured")
+)
+
+// Provider supplies the secrets of an application. An empty PublicKey
+// means the public key published for the market and environment is used.
+// Implementations must be safe for concurrent use.
+type Provider interface {
+
+	// APIKey returns the current application key.
+	APIKey(ctx context.Context) (string, error)
+
+	// PublicKey returns the base64 or PEM encoded public key used to
+	// encrypt the application key.
+	PublicKey(ctx context.Context) (string, error)
+}
+
+var (
+	_ Provider = Static{}
+	_ Provider = Env{}
+	_ Provider = File{}
+	_ Provider = Command{}
+	_ Provider = (*Cache)(nil)
+)
+
+// Static supplies fixed secrets.
+type Static struct {
+	Key    string
+	PubKey string
+}
+
+// APIKey implements Provider.
+func (s Static) APIKey(context.Context) (string, error) {
+	if s.Key == "" {
+		return "", ErrEmptySecret
+	}
+	return s.Key, nil
+}
+
+// PublicKey implements Provider.
+func (s Static) PublicKey(context.Context) (string, error) {
+	return s.PubKey, nil
+}
+
+// Env reads secrets from environment variables each time they are requested.
+type Env struct {
+
+	// APIKeyVar names the variable holding the application key,
+	// defaults to MPESA_APPLICATION_KEY.
+	APIKeyVar string
+
+	// PublicKeyVar names the variable holding the public key, no public
+	// key is supplied when empty.
+	PublicKeyVar string
+}
+
+// APIKey implements Provider.
+func (e Env) APIKey(context.Context) (string, error) {
+	name := e.APIKeyVar
+	if name == "" {
+		name = "MPESA_APPLICATION_KEY"
+	}
+
+	v := os.Getenv(name)
+	if v == "" {
+		return "", errors.Wrap(ErrEmptySecret, errors.New(name))
+	}
+	return v, nil
+}
+
+// PublicKey implements Provider.
+func (e Env) PublicKey(context.Context) (string, error) {
+	if e.PublicKeyVar == "" {
+		return "", nil
+	}
+	return os.Getenv(e.PublicKeyVar), nil
+}
+
+// File reads secrets from files, e.g. mounted Kubernetes or Docker secrets,
+// each time they are requested. Surrounding whitespace is trimmed.
+type File struct {
+	APIKeyPath string
+
+	// PublicKeyPath may be empty when no public key is supplied.
+	PublicKeyPath string
+}
+
+// APIKey implements Provider.
+func (f File) APIKey(context.Context) (string, error) {
+	v, err := readFile(f.APIKeyPath)
+	if err != nil {
+		return "", err
+	}
+
+	if v == "" {
+		return "", errors.Wrap(ErrEmptySecret, errors.New(f.APIKeyPath))
+	}
+	return v, nil
+}
+
+// PublicKey implements Provider.
+func (f File) PublicKey(context.Context) (string, error) {
+	if f.PublicKeyPath == "" {
+		return "", nil
+	}
+	return readFile(f.PublicKeyPath)
+}
+
+func readFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Command runs external commands, e.g. a vault or cloud KMS CLI, and uses
+// their trimmed standard output as the secret.
+type Command struct {
+
+	// APIKeyCmd is the program and arguments printing the application key.
+	APIKeyCmd []string
+
+	// PublicKeyCmd is the program and arguments printing the public key,
+	// no public key is supplied when empty.
+	PublicKeyCmd []string
+}
+
+// APIKey implements Provider.
+func (c Command) APIKey(ctx context.Context) (string, error) {
+	v, err := run(ctx, c.APIKeyCmd)
+	if err != nil {
+		return "", err
+	}
+
+	if v == "" {
+		return "", errors.Wrap(ErrEmptySecret, errors.New(strings.Join(c.APIKeyCmd, " ")))
+	}
+	return v, nil
+}
+
+// PublicKey implements Provider.
+func (c Command) PublicKey(ctx context.Context) (string, error) {
+	if len(c.PublicKeyCmd) == 0 {
+		return "", nil
+	}
+	return run(ctx, c.PublicKeyCmd)
+}
+
+func run(ctx context.Context, argv []string) (string, error) {
+	if len(argv) == 0 {
+		return "", ErrNoCommand
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Wrap(err, errors.New(msg))
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Cache remembers the secrets of a slower Provider for a while.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	apiKey    cached
+	publicKey cached
+
+	// generation is incremented by Invalidate so that a fetch started
+	// before it is not cached.
+	generation int
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+// Cached wraps p so that its secrets are fetched at most once per ttl.
+func Cached(p Provider, ttl time.Duration) *Cache {
+	return &Cache{provider: p, ttl: ttl}
+}
+
+// APIKey implements Provider.
+func (c *Cache) APIKey(ctx context.Context) (string, error) {
+	return c.get(ctx, &c.apiKey, c.provider.APIKey)
+}
+
+// PublicKey implements Provider.
+func (c *Cache) PublicKey(ctx context.Context) (string, error) {
+	return c.get(ctx, &c.publicKey, c.provider.PublicKey)
+}
+
+// Invalidate forgets the cached secrets so that the next call reaches the
+// wrapped provider.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.apiKey = cached{}
+	c.publicKey = cached{}
+	c.generation++
+	c.mu.Unlock()
+}
+
+func (c *Cache) get(ctx context.Context, entry *cached, fetch func(context.Context) (string, error)) (string, error) {
+	c.mu.Lock()
+	if time.Now().Before(entry.expires) {
+		v := entry.value
+		c.mu.Unlock()
+		return v, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	// The provider is called without the lock held, a slow command must
+	// not block the callers of the other secret.
+	v, err := fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if generation == c.generation {
+		*entry = cached{value: v, expires: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	return v, nil
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package secret_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+type counting struct {
+	key   string
+	calls int
+}
+
+func (c *counting) APIKey(context.Context) (string, error) {
+	c.calls++
+	return c.key, nil
+}
+
+func (c *counting) PublicKey(context.Context) (string, error) {
+	return "", nil
+}
+
+func TestProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mpesa-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "api_key")
+	ioutil.WriteFile(keyPath, []byte("file-key\n"), 0600)
+
+	os.Setenv("TEST_MPESA_SECRET_KEY", "env-key")
+	defer os.Unsetenv("TEST_MPESA_SECRET_KEY")
+
+	cases := []struct {
+		desc     string
+		provider secret.Provider
+		key      string
+		fails    bool
+	}{
+		{
+			desc:     "static provider",
+			provider: secret.Static{Key: "static-key"},
+			key:      "static-key",
+		},
+		{
+			desc:     "empty static provider",
+			provider: secret.Static{},
+			fails:    true,
+		},
+		{
+			desc:     "env provider",
+			provider: secret.Env{APIKeyVar: "TEST_MPESA_SECRET_KEY"},
+			key:      "env-key",
+		},
+		{
+			desc:     "env provider with unset variable",
+			provider: secret.Env{APIKeyVar: "TEST_MPESA_SECRET_UNSET"},
+			fails:    true,
+		},
+		{
+			desc:     "file provider trims whitespace",
+			provider: secret.File{APIKeyPath: keyPath},
+			key:      "file-key",
+		},
+		{
+			desc:     "file provider with missing file",
+			provider: secret.File{APIKeyPath: filepath.Join(dir, "missing")},
+			fails:    true,
+		},
+		{
+			desc:     "command provider",
+			provider: secret.Command{APIKeyCmd: []string{"echo", "cmd-key"}},
+			key:      "cmd-key",
+		},
+		{
+			desc:     "command provider without command",
+			provider: secret.Command{},
+			fails:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		key, err := tc.provider.APIKey(context.Background())
+		assert.Equal(t, tc.fails, err != nil, fmt.Sprintf("%s: unexpected error %v\n", tc.desc, err))
+		assert.Equal(t, tc.key, key, fmt.Sprintf("%s: expected %s got %s\n", tc.desc, tc.key, key))
+	}
+}
+
+func TestCache(t *testing.T) {
+	p := &counting{key: "k1"}
+	c := secret.Cached(p, time.Hour)
+	ctx := context.Background()
+
+	c.APIKey(ctx)
+	key, _ := c.APIKey(ctx)
+	assert.Equal(t, "k1", key)
+	assert.Equal(t, 1, p.calls, "expected cached key to be reused")
+
+	p.key = "k2"
+	c.Invalidate()
+	key, _ = c.APIKey(ctx)
+	assert.Equal(t, "k2", key, "expected invalidated cache to fetch rotated key")
+	assert.Equal(t, 2, p.calls)
+}
+
+// blocking supplies its application key once released.
+type blocking struct {
+	started, release chan struct{}
+}
+
+func (b *blocking) APIKey(context.Context) (string, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return "k1", nil
+}
+
+func (b *blocking) PublicKey(context.Context) (string, error) {
+	return "pk", nil
+}
+
+func TestCacheSlowProvider(t *testing.T) {
+	p := &blocking{started: make(chan struct{}, 1), release: make(chan struct{})}
+	c := secret.Cached(p, time.Hour)
+	ctx := context.Background()
+
+	fetched := make(chan string)
+	go func() {
+		key, _ := c.APIKey(ctx)
+		fetched <- key
+	}()
+	<-p.started
+
+	done := make(chan string)
+	go func() {
+		pk, _ := c.PublicKey(ctx)
+		done <- pk
+	}()
+
+	select {
+	case pk := <-done:
+		assert.Equal(t, "pk", pk)
+	case <-time.After(time.Second):
+		t.Fatal("expected the public key not to wait for the application key fetch")
+	}
+
+	close(p.release)
+	assert.Equal(t, "k1", <-fetched)
+}
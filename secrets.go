@@ -0,0 +1,108 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+
+	"github.com/mobilemoney/mpesa/pubkey"
+	"github.com/mobilemoney/mpesa/secret"
+)
+
+// WithSecretProvider reads the application key, and optionally the public
+// key, from p instead of NewApplication's applicationKey argument. p is
+// consulted before every operation, wrap slow providers with secret.Cached.
+// When p supplies a new application key a new session is generated before
+// the operation is sent.
+func WithSecretProvider(p secret.Provider) Option {
+	return func(app *Application) {
+		app.secrets = p
+	}
+}
+
+// credentials returns the application key and public keys used to
+// generate a session.
+func (app *Application) credentials(ctx context.Context) (key string, keys pubkey.Set, err error) {
+	if app.publicKey != nil {
+		keys = *app.publicKey
+	} else {
+		keys = lookupPublicKey(app.market, app.Type)
+	}
+
+	if app.secrets == nil {
+		app.mu.RLock()
+		defer app.mu.RUnlock()
+		return app.Key, keys, nil
+	}
+
+	if key, err = app.secrets.APIKey(ctx); err != nil {
+		return "", keys, err
+	}
+
+	pk, err := app.secrets.PublicKey(ctx)
+	if err != nil {
+		return "", keys, err
+	}
+
+	if pk != "" {
+		if keys.Primary, err = pubkey.Parse([]byte(pk)); err != nil {
+			return "", keys, err
+		}
+	}
+
+	return key, keys, nil
+}
+
+// checkRotation generates a new session when the secret provider supplies
+// an application key other than the one the current session was generated
+// with, and reports whether the session changed. Concurrent requests
+// noticing the same rotation generate a single session.
+func (app *Application) checkRotation(ctx context.Context) (bool, error) {
+	if app.secrets == nil {
+		return false, nil
+	}
+
+	key, err := app.secrets.APIKey(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if key == app.currentKey() {
+		return false, nil
+	}
+
+	app.rotation.Lock()
+	defer app.rotation.Unlock()
+
+	if key == app.currentKey() {
+		return true, nil
+	}
+
+	if _, err := app.getSessionKey(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// currentKey returns the application key of the current session.
+func (app *Application) currentKey() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Key
+}
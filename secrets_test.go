@@ -0,0 +1,81 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotating supplies an application key which can be rotated.
+type rotating struct {
+	mu  sync.Mutex
+	key string
+}
+
+func (r *rotating) rotate(key string) {
+	r.mu.Lock()
+	r.key = key
+	r.mu.Unlock()
+}
+
+func (r *rotating) APIKey(context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.key, nil
+}
+
+func (r *rotating) PublicKey(context.Context) (string, error) {
+	return "", nil
+}
+
+func TestConcurrentRotation(t *testing.T) {
+	api := mpesatest.NewAPI().
+		Handle(mpesa.OpGetSession, func(*http.Request) mpesatest.Reply {
+			time.Sleep(10 * time.Millisecond)
+			return mpesatest.Reply{Status: http.StatusOK, Body: `{"output_ResponseCode":"INS-0","output_SessionID":"session"}`}
+		}).
+		On(mpesa.OpC2B, mpesatest.Reply{Status: http.StatusCreated, Body: accepted})
+
+	secrets := &rotating{key: "k1"}
+	app := mpesatest.NewApplication(t, api, mpesa.WithSecretProvider(secrets))
+	secrets.rotate("k2")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := app.C2B(context.Background(), mpesa.C2BPayment{Amount: "100", CustomerMSISDN: "255744553111", TransactionReference: "T1"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, api.Requests(mpesa.OpGetSession), "expected a single session to be generated for the rotated key\n")
+	assert.Equal(t, 10, api.Requests(mpesa.OpC2B))
+}
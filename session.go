@@ -1,92 +1,106 @@
 package mpesa
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-)
+	"time"
 
-var (
-	publicKey = map[APIEnviroment]string{
-		Sandbox:    `MIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEArv9yxA69XQKBo24BaF/D+fvlqmGdYjqLQ5WtNBb5tquqGvAvG3WMFETVUSow/LizQalxj2ElMVrUmzu5mGGkxK08bWEXF7a1DEvtVJs6nppIlFJc2SnrU14AOrIrB28ogm58JjAl5BOQawOXD5dfSk7MaAA82pVHoIqEu0FxA8BOKU+RGTihRU+ptw1j4bsAJYiPbSX6i71gfPvwHPYamM0bfI4CmlsUUR3KvCG24rB6FNPcRBhM3jDuv8ae2kC33w9hEq8qNB55uw51vK7hyXoAa+U7IqP1y6nBdlN25gkxEA8yrsl1678cspeXr+3ciRyqoRgj9RD/ONbJhhxFvt1cLBh+qwK2eqISfBb06eRnNeC71oBokDm3zyCnkOtMDGl7IvnMfZfEPFCfg5QgJVk1msPpRvQxmEsrX9MQRyFVzgy2CWNIb7c+jPapyrNwoUbANlN8adU1m6yOuoX7F49x+OjiG2se0EJ6nafeKUXw/+hiJZvELUYgzKUtMAZVTNZfT8jjb58j8GVtuS+6TM2AutbejaCV84ZK58E2CRJqhmjQibEUO6KPdD7oTlEkFy52Y1uOOBXgYpqMzufNPmfdqqqSM4dU70PO8ogyKGiLAIxCetMjjm6FCMEA3Kc8K0Ig7/XtFm9By6VxTJK1Mg36TlHaZKP6VzVLXMtesJECAwEAAQ==`,
-		Production: `MIICIjANBgkqhkiG9w0BAQEFAAOCAg8AMIICCgKCAgEAietPTdEyyoV/wvxRjS5pSn3ZBQH9hnVtQC9SFLgM9IkomEX9Vu9fBg2MzWSSqkQlaYIGFGH3d69Q5NOWkRo+Y8p5a61sc9hZ+ItAiEL9KIbZzhnMwi12jUYCTff0bVTsTGSNUePQ2V42sToOIKCeBpUtwWKhhW3CSpK7S1iJhS9H22/BT/pk21Jd8btwMLUHfVD95iXbHNM8u6vFaYuHczx966T7gpa9RGGXRtiOr3ScJq1515tzOSOsHTPHLTun59nxxJiEjKoI4Lb9h6IlauvcGAQHp5q6/2XmxuqZdGzh39uLac8tMSmY3vC3fiHYC3iMyTb7eXqATIhDUOf9mOSbgZMS19iiVZvz8igDl950IMcelJwcj0qCLoufLE5y8ud5WIw47OCVkD7tcAEPmVWlCQ744SIM5afw+Jg50T1SEtu3q3GiL0UQ6KTLDyDEt5BL9HWXAIXsjFdPDpX1jtxZavVQV+Jd7FXhuPQuDbh12liTROREdzatYWRnrhzeOJ5Se9xeXLvYSj8DmAI4iFf2cVtWCzj/02uK4+iIGXlX7lHP1W+tycLS7Pe2RdtC2+oz5RSSqb5jI4+3iEY/vZjSMBVk69pCDzZy4ZE8LBgyEvSabJ/cddwWmShcRS+21XvGQ1uXYLv0FCTEHHobCfmn2y8bJBb/Hct53BaojWUCAwEAAQ==`,
-	}
+	"github.com/mobilemoney/mpesa/pubkey"
 )
 
-type getSessionResp struct {
-	// The response code for the transaction.
-	Code string `json:"output_ResponseCode"`
-
-	// The response description for the transaction.
-	Description string `json:"output_ResponseDesc"`
-
-	// The SessionKey that can be used to call other APIs.
-	SessionID string `json:"output_SessionID"`
+func (r *getSessionResp) responseCode() string {
+	return r.Code
 }
 
 // getSession retrieve Session Key which authorises the rest of API calls to the system.
 // Endpoint /[api_enviroment]/ipg/v2/[market]/getSession/
+// When the API rejects the key encrypted with the primary public key, with 401, 403
+// or INS-2, and a fallback public key is configured, the request is retried with the
+// fallback key.
 func (app *Application) getSessionKey() (string, error) {
 
-	var sessionResp getSessionResp
-
-	encryptedKey, err := encryptAPIKey(publicKey[app.Type], app.Key)
+	key, keys, err := app.credentials(context.Background())
 	if err != nil {
 		return "", err
 	}
 
-	sessionEndpoint := fmt.Sprintf("%s/%s/ipg/v2/%s/getSession/", baseURL, app.Type, app.market)
-
-	req, err := http.NewRequest(http.MethodGet, sessionEndpoint, nil)
-	if err != nil {
-		return "", err
+	if keys.Primary == nil {
+		return "", fmt.Errorf("mpesa: no public key configured for %s %s", app.market, app.Type)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", encryptedKey))
-	req.Header.Set("Origin", "*")
 
-	resp, err := app.client.Do(req)
-	if err != nil {
-		return "", err
+	sessionResp, err := app.requestSession(key, keys.Primary)
+
+	if err != nil && keys.Fallback != nil && keyRejected(err) {
+		if sessionResp, err = app.requestSession(key, keys.Fallback); err == nil {
+			keys.Primary = keys.Fallback
+		}
 	}
-	defer resp.Body.Close()
 
-	if err := json.NewDecoder(resp.Body).Decode(&sessionResp); err != nil {
+	if err != nil {
 		return "", err
 	}
 
+	app.mu.Lock()
+	app.Key = key
 	app.SessionKey = sessionResp.SessionID
+	app.keyFingerprint = pubkey.Fingerprint(keys.Primary)
+	app.sessionAt = time.Now()
+	app.mu.Unlock()
+	app.metrics.IncSessionRefresh()
+	app.emit(context.Background(), EventSessionRefreshed, "", SessionRefreshed{
+		Market:               app.market,
+		Environment:          app.Type,
+		PublicKeyFingerprint: pubkey.Fingerprint(keys.Primary),
+	})
 
 	return sessionResp.SessionID, nil
 }
 
-func encryptAPIKey(pbKey string, APIkey string) (string, error) {
+// keyRejected reports whether err is the API refusing the application key,
+// e.g. because it was encrypted with a retired public key, as opposed to a
+// failure unrelated to the key.
+func keyRejected(err error) bool {
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
 
-	base64Str, err := base64.StdEncoding.DecodeString(pbKey)
-	if err != nil {
-		// just for now no better error handling
-		return "", errors.New("Handle this error")
+	switch {
+	case respErr.StatusCode == http.StatusUnauthorized, respErr.StatusCode == http.StatusForbidden:
+		return true
+	default:
+		return respErr.Code == CodeInvalidAPIKey
 	}
+}
+
+// requestSession asks the API for a session key, authenticating with key encrypted by pk.
+func (app *Application) requestSession(key string, pk *rsa.PublicKey) (getSessionResp, error) {
+
+	var sessionResp getSessionResp
 
-	parsedPublicKey, err := x509.ParsePKIXPublicKey(base64Str)
+	encryptedKey, err := pubkey.Encrypt(pk, key)
 	if err != nil {
-		return "", errors.New("Handle this error")
+		return sessionResp, err
 	}
 
-	pk, ok := parsedPublicKey.(*rsa.PublicKey)
-	if !ok {
-		return "", errors.New("Handle this error")
+	req, err := http.NewRequest(http.MethodGet, app.endpoint("getSession/"), nil)
+	if err != nil {
+		return sessionResp, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", encryptedKey))
+	req.Header.Set("Origin", "*")
 
-	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pk, []byte(APIkey))
-	if err != nil {
-		return "", errors.New("Handle this error")
+	err = app.send(OpGetSession, req, &sessionResp)
+
+	// the session key of a response is used whatever its status
+	var respErr *ResponseError
+	if errors.As(err, &respErr) && sessionResp.SessionID != "" {
+		err = nil
 	}
 
-	return base64.StdEncoding.EncodeToString(encryptedKey), nil
+	return sessionResp, err
 }
@@ -20,9 +20,10 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"github.com/mobilemoney/mpesa/pkg/errors"
+	"github.com/mobilemoney/mpesa/pubkey"
+	"github.com/mobilemoney/mpesa/secret"
 )
 
 var (
@@ -64,12 +65,14 @@ type Application struct {
 	SessionLifeTime int `json:"session_life_time"`
 
 	//TrustedSources The originating caller can be limited to specific IP Addresses
-	//as an additional security measure.
+	//as an additional security measure. Entries are IP addresses or CIDR ranges
+	//and also admit inbound callbacks, see callback.NewAllowlist.
 	TrustedSources []string `json:"trusted_sources"`
 	
 	
 	PublicKey string `json:"public_key"`
 
+	secrets secret.Provider
 
 	// todo: Scope
 }
@@ -78,6 +81,11 @@ var _ Session = (*Application)(nil)
 
 type Config struct {
 	Application
+
+	//Secrets - when set, supplies the APIKey and PublicKey in place of
+	//the Application fields every time the APIKey is encrypted, so that
+	//rotated keys are picked up without a restart.
+	Secrets secret.Provider `json:"-"`
 }
 
 type Session interface {
@@ -112,7 +120,9 @@ func New(cfg Config) Session{
 		Desc:            desc,
 		APIKey:          key,
 		SessionLifeTime: 0,
-		TrustedSources:  nil,
+		TrustedSources:  cfg.TrustedSources,
+		PublicKey:       cfg.PublicKey,
+		secrets:         cfg.Secrets,
 	}
 }
 
@@ -123,6 +133,22 @@ func (a Application) GenerateSessionKey(ctx context.Context) {
 
 func (a Application) EncryptAPIKey() (string, error) {
 
+	if a.secrets != nil {
+		var err error
+
+		if a.APIKey, err = a.secrets.APIKey(context.Background()); err != nil {
+			return "", err
+		}
+
+		pub, err := a.secrets.PublicKey(context.Background())
+		if err != nil {
+			return "", err
+		}
+		if pub != "" {
+			a.PublicKey = pub
+		}
+	}
+
 	//pk public key
 	pk,err := deriveRSAPubKey(a.PublicKey)
 
@@ -149,23 +175,18 @@ func (a Application) EncryptAPIKey() (string, error) {
 	return base64Str,nil
 }
 
-func deriveRSAPubKey(base64Str string)(*rsa.PublicKey,error)  {
-	pkb, err := base64.StdEncoding.DecodeString(base64Str)
+//deriveRSAPubKey accepts PEM, DER or base64 encoded DER public keys
+func deriveRSAPubKey(encoded string)(*rsa.PublicKey,error)  {
+	pkey, err := pubkey.Parse([]byte(encoded))
 
-	if err != nil {
+	switch {
+	case err == nil:
+		return pkey, nil
+	case errors.Contains(err, pubkey.ErrNotRSA):
+		return nil, ErrNotRSAPubKey
+	case errors.Contains(err, pubkey.ErrDecode):
 		return nil, errors.Wrap(err, ErrFailToDecodeBase64Str)
-	}
-
-	pk,err := x509.ParsePKIXPublicKey(pkb)
-
-	if err != nil {
+	default:
 		return nil, errors.Wrap(err,ErrFailToParsePKey)
 	}
-
-	pkey, ok := pk.(*rsa.PublicKey)
-
-	if !ok{
-		return nil, ErrNotRSAPubKey
-	}
-	return pkey, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionFallbackKey(t *testing.T) {
+	retired, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc     string
+		rejected mpesatest.Reply
+		err      bool
+		sessions int
+	}{
+		{
+			desc:     "unauthorized",
+			rejected: mpesatest.Reply{Status: http.StatusUnauthorized, Body: `{"output_ResponseCode":"INS-6","output_ResponseDesc":"Transaction Failed"}`},
+			sessions: 2,
+		},
+		{
+			desc:     "forbidden",
+			rejected: mpesatest.Reply{Status: http.StatusForbidden},
+			sessions: 2,
+		},
+		{
+			desc:     "invalid api key",
+			rejected: mpesatest.Reply{Status: http.StatusBadRequest, Body: unauthorized},
+			sessions: 2,
+		},
+		{
+			desc:     "server error",
+			rejected: mpesatest.Reply{Status: http.StatusInternalServerError, Body: `{"output_ResponseCode":"INS-1","output_ResponseDesc":"Internal Error"}`},
+			err:      true,
+			sessions: 1,
+		},
+		{
+			desc:     "bad request",
+			rejected: mpesatest.Reply{Status: http.StatusBadRequest, Body: `{"output_ResponseCode":"INS-13","output_ResponseDesc":"Invalid Shortcode Used"}`},
+			err:      true,
+			sessions: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		rejected := tc.rejected
+		api := mpesatest.NewAPI().Handle(mpesa.OpGetSession, func(req *http.Request) mpesatest.Reply {
+			encrypted, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+			if _, err := rsa.DecryptPKCS1v15(rand.Reader, mpesatest.Key(), encrypted); err != nil {
+				return rejected
+			}
+			return mpesatest.Reply{Status: http.StatusOK, Body: `{"output_ResponseCode":"INS-0","output_SessionID":"session"}`}
+		})
+
+		_, err := mpesa.NewApplication("key", mpesa.VodacomTanzania, mpesa.Sandbox,
+			mpesa.WithHTTPClient(&http.Client{Transport: api}),
+			mpesa.WithPublicKey(&retired.PublicKey, &mpesatest.Key().PublicKey),
+		)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: expected error %t got %v\n", tc.desc, tc.err, err))
+		assert.Equal(t, tc.sessions, api.Requests(mpesa.OpGetSession), fmt.Sprintf("%s: expected %d session requests got %d\n", tc.desc, tc.sessions, api.Requests(mpesa.OpGetSession)))
+	}
+}
@@ -0,0 +1,366 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package sweep settles transactions whose callback never arrived by
+// periodically querying their status.
+//
+//	s := &sweep.Sweeper{
+//		Querier:  app,
+//		Source:   sweep.LedgerSource(l),
+//		OnUpdate: sweep.LedgerUpdate(l),
+//	}
+//	go s.Run(ctx)
+package sweep
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/ledger"
+)
+
+const (
+	defInterval   = time.Minute
+	defMaxAge     = 24 * time.Hour
+	defMinBackoff = 30 * time.Second
+	defMaxBackoff = 30 * time.Minute
+
+	// initiatedGrace is how long LedgerSource leaves initiated transactions
+	// to their request, which may still be in flight
+	initiatedGrace = 5 * time.Minute
+)
+
+// Item is a pending transaction.
+type Item struct {
+
+	// ID identifies the item to its source, e.g. the third party
+	// conversation ID of a ledger transaction.
+	ID string
+
+	// Reference is the transaction ID, conversation ID or third party
+	// conversation ID the status is queried with.
+	Reference string
+
+	CreatedAt time.Time
+}
+
+// Source lists the pending transactions to settle.
+type Source interface {
+	Pending(ctx context.Context) ([]Item, error)
+}
+
+// SourceFunc is a Source function.
+type SourceFunc func(ctx context.Context) ([]Item, error)
+
+// Pending implements Source.
+func (fn SourceFunc) Pending(ctx context.Context) ([]Item, error) {
+	return fn(ctx)
+}
+
+// Result is the final state of an item.
+type Result struct {
+
+	// Status is Completed, Failed or Reversed as queried, or Expired for
+	// items that stayed pending past the max age.
+	Status ledger.Status
+
+	// Response is the status query response, nil for expired items.
+	Response *mpesa.StatusResp
+}
+
+// Querier queries the status of a transaction, *mpesa.Application implements it.
+type Querier interface {
+	QueryTransactionStatus(ctx context.Context, q mpesa.StatusQuery) (*mpesa.StatusResp, error)
+}
+
+// Sweeper queries the status of pending items until they settle or expire.
+// Items are queried with exponential backoff between MinBackoff and
+// MaxBackoff, both when they are still pending and when the query fails.
+type Sweeper struct {
+	Querier Querier
+	Source  Source
+
+	// OnUpdate is called with every settled or expired item. An item whose
+	// update fails is retried on the next sweep.
+	OnUpdate func(ctx context.Context, item Item, r Result) error
+
+	// OnError, if set, is called with every error listing or querying items.
+	OnError func(err error)
+
+	// Interval between sweeps, defaults to a minute.
+	Interval time.Duration
+
+	// MaxAge after which pending items expire, defaults to 24 hours.
+	MaxAge time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between queries of the
+	// same item, they default to 30 seconds and 30 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu    sync.Mutex
+	state map[string]*backoff
+}
+
+type backoff struct {
+	attempts int
+	next     time.Time
+
+	// busy items are being queried
+	busy bool
+}
+
+// Run sweeps every Interval until ctx is cancelled and returns ctx.Err().
+// The first sweep starts immediately.
+func (s *Sweeper) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			s.report(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep queries the status of every pending item due for a query once. It
+// returns an error if the items cannot be listed or ctx is cancelled, other
+// errors are passed to OnError. Items being queried by a concurrent Sweep are
+// skipped.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	items, err := s.Source.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	due := s.due(items)
+	for i, item := range due {
+		if err := s.query(ctx, item); err != nil {
+			s.release(due[i:])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// due forgets the items no longer pending and returns the items due for a
+// query, or expired, marking them busy until they are settled or delayed.
+func (s *Sweeper) due(items []Item) []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		s.state = make(map[string]*backoff)
+	}
+
+	pending := make(map[string]bool, len(items))
+	for _, item := range items {
+		pending[item.ID] = true
+	}
+	for id := range s.state {
+		if !pending[id] {
+			delete(s.state, id)
+		}
+	}
+
+	now := time.Now()
+
+	var due []Item
+	for _, item := range items {
+		b := s.state[item.ID]
+		if b == nil {
+			b = &backoff{}
+			s.state[item.ID] = b
+		}
+
+		if b.busy || (now.Before(b.next) && !s.expired(item, now)) {
+			continue
+		}
+
+		b.busy = true
+		due = append(due, item)
+	}
+
+	return due
+}
+
+// query settles item, or delays its next query. It only fails when ctx is
+// cancelled.
+func (s *Sweeper) query(ctx context.Context, item Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if s.expired(item, now) {
+		s.settle(ctx, item, Result{Status: ledger.Expired})
+		return nil
+	}
+
+	resp, err := s.Querier.QueryTransactionStatus(ctx, mpesa.StatusQuery{QueryReference: item.Reference})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.report(err)
+		s.delay(item, now)
+		return nil
+	}
+
+	status := ledger.StatusFromQuery(resp.ResponseTransactionStatus)
+	if status == ledger.Pending {
+		s.delay(item, now)
+		return nil
+	}
+
+	s.settle(ctx, item, Result{Status: status, Response: resp})
+	return nil
+}
+
+func (s *Sweeper) expired(item Item, now time.Time) bool {
+	maxAge := s.MaxAge
+	if maxAge <= 0 {
+		maxAge = defMaxAge
+	}
+	return now.Sub(item.CreatedAt) > maxAge
+}
+
+// settle reports the result of item and forgets it, unless the update fails.
+func (s *Sweeper) settle(ctx context.Context, item Item, r Result) {
+	if s.OnUpdate != nil {
+		if err := s.OnUpdate(ctx, item, r); err != nil {
+			s.report(err)
+			s.release([]Item{item})
+			return
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.state, item.ID)
+	s.mu.Unlock()
+}
+
+// release makes items available to the next sweep as they are.
+func (s *Sweeper) release(items []Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range items {
+		if b := s.state[item.ID]; b != nil {
+			b.busy = false
+		}
+	}
+}
+
+// delay schedules the next query of item.
+func (s *Sweeper) delay(item Item, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.state[item.ID]
+	if b == nil {
+		return
+	}
+	b.busy = false
+
+	min, max := s.MinBackoff, s.MaxBackoff
+	if min <= 0 {
+		min = defMinBackoff
+	}
+	if max <= 0 {
+		max = defMaxBackoff
+	}
+
+	d := min
+	for i := 0; i < b.attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	b.attempts++
+	b.next = now.Add(d)
+}
+
+func (s *Sweeper) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// LedgerSource lists the accepted and pending transactions of l, and the
+// initiated ones older than 5 minutes, whose request was lost, e.g. to a
+// crash before its response was recorded. Younger initiated transactions
+// are left out as their request may still be in flight.
+func LedgerSource(l *ledger.Ledger) Source {
+	return SourceFunc(func(ctx context.Context) ([]Item, error) {
+		txs, err := l.ByStatus(ctx, ledger.Initiated, ledger.Accepted, ledger.Pending)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		items := make([]Item, 0, len(txs))
+		for _, tx := range txs {
+			if tx.Status == ledger.Initiated && now.Sub(tx.CreatedAt) < initiatedGrace {
+				continue
+			}
+
+			ref := tx.TransactionID
+			if ref == "" {
+				ref = tx.ID
+			}
+			items = append(items, Item{ID: tx.ID, Reference: ref, CreatedAt: tx.CreatedAt})
+		}
+		return items, nil
+	})
+}
+
+// LedgerUpdate records results to the transactions of l, skipping those
+// already recorded, e.g. by l journaling the status queries of the sweeper.
+func LedgerUpdate(l *ledger.Ledger) func(ctx context.Context, item Item, r Result) error {
+	return func(ctx context.Context, item Item, r Result) error {
+		u := ledger.Update{Source: ledger.SourceSweeper, Status: r.Status}
+		if r.Response != nil {
+			u.Code = r.Response.Code
+			u.ConversationID = r.Response.ConversationID
+		}
+
+		// the status query may already have been journaled to l
+		if tx, err := l.Get(ctx, item.ID); err == nil && tx.Status == r.Status {
+			return nil
+		}
+
+		_, err := l.Update(ctx, item.ID, u)
+		return err
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package sweep_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mobilemoney/mpesa"
+	"github.com/mobilemoney/mpesa/internal/mpesatest"
+	"github.com/mobilemoney/mpesa/ledger"
+	"github.com/mobilemoney/mpesa/sweep"
+	"github.com/stretchr/testify/assert"
+)
+
+// querier answers status queries from a map of reference to status, a
+// missing reference fails the query.
+type querier struct {
+	mu       sync.Mutex
+	statuses map[string]string
+	queries  map[string]int
+}
+
+func (q *querier) QueryTransactionStatus(_ context.Context, sq mpesa.StatusQuery) (*mpesa.StatusResp, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queries[sq.QueryReference]++
+	status, ok := q.statuses[sq.QueryReference]
+	if !ok {
+		return &mpesa.StatusResp{}, errors.New("connection reset")
+	}
+	return &mpesa.StatusResp{Code: "INS-0", ResponseTransactionStatus: status}, nil
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	l := ledger.New(ledger.NewMemory())
+	for _, tx := range []*ledger.Transaction{
+		{ID: "a", TransactionID: "tx-a", Status: ledger.Pending, CreatedAt: now},
+		{ID: "b", Status: ledger.Initiated, CreatedAt: now.Add(-10 * time.Minute)},
+		{ID: "c", Status: ledger.Pending, CreatedAt: now},
+		{ID: "d", Status: ledger.Pending, CreatedAt: now},
+		{ID: "e", Status: ledger.Pending, CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: "f", Status: ledger.Completed, CreatedAt: now},
+		{ID: "g", Status: ledger.Initiated, CreatedAt: now},
+	} {
+		l.Put(ctx, tx)
+	}
+
+	q := &querier{
+		statuses: map[string]string{"tx-a": "Completed", "b": "Failed", "c": "Pending", "g": "Completed"},
+		queries:  make(map[string]int),
+	}
+
+	var errs int
+	s := &sweep.Sweeper{
+		Querier:    q,
+		Source:     sweep.LedgerSource(l),
+		OnUpdate:   sweep.LedgerUpdate(l),
+		OnError:    func(error) { errs++ },
+		MinBackoff: time.Hour,
+	}
+
+	for i := 0; i < 2; i++ {
+		err := s.Sweep(ctx)
+		assert.Nil(t, err, fmt.Sprintf("sweep %d: expected no error got %v\n", i, err))
+	}
+
+	cases := []struct {
+		desc    string
+		id      string
+		ref     string
+		status  ledger.Status
+		queries int
+	}{
+		{desc: "settle completed transaction by transaction ID", id: "a", ref: "tx-a", status: ledger.Completed, queries: 1},
+		{desc: "settle failed transaction by third party conversation ID", id: "b", ref: "b", status: ledger.Failed, queries: 1},
+		{desc: "back off pending transaction", id: "c", ref: "c", status: ledger.Pending, queries: 1},
+		{desc: "back off failed query", id: "d", ref: "d", status: ledger.Pending, queries: 1},
+		{desc: "expire old transaction without query", id: "e", ref: "e", status: ledger.Expired, queries: 0},
+		{desc: "skip settled transaction", id: "f", ref: "f", status: ledger.Completed, queries: 0},
+		{desc: "skip transaction in flight", id: "g", ref: "g", status: ledger.Initiated, queries: 0},
+	}
+
+	for _, tc := range cases {
+		tx, _ := l.Get(ctx, tc.id)
+		assert.Equal(t, tc.status, tx.Status, fmt.Sprintf("%s: expected status %s got %s\n", tc.desc, tc.status, tx.Status))
+		assert.Equal(t, tc.queries, q.queries[tc.ref], fmt.Sprintf("%s: expected %d queries got %d\n", tc.desc, tc.queries, q.queries[tc.ref]))
+	}
+
+	assert.Equal(t, 1, errs, fmt.Sprintf("report errors: expected 1 error got %d\n", errs))
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sweeps := make(chan struct{}, 10)
+	s := &sweep.Sweeper{
+		Querier: &querier{},
+		Source: sweep.SourceFunc(func(context.Context) ([]sweep.Item, error) {
+			sweeps <- struct{}{}
+			return nil, nil
+		}),
+		Interval: time.Millisecond,
+	}
+
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	<-sweeps
+	<-sweeps
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err, fmt.Sprintf("run: expected %v got %v\n", context.Canceled, err))
+	case <-time.After(time.Second):
+		t.Fatal("run: did not stop on cancel")
+	}
+}
+
+// blocking answers the first status query once released.
+type blocking struct {
+	started, release chan struct{}
+	queries          int32
+}
+
+func (b *blocking) QueryTransactionStatus(context.Context, mpesa.StatusQuery) (*mpesa.StatusResp, error) {
+	if atomic.AddInt32(&b.queries, 1) == 1 {
+		close(b.started)
+		<-b.release
+	}
+	return &mpesa.StatusResp{Code: "INS-0", ResponseTransactionStatus: "Pending"}, nil
+}
+
+func TestConcurrentSweep(t *testing.T) {
+	ctx := context.Background()
+	q := &blocking{started: make(chan struct{}), release: make(chan struct{})}
+
+	s := &sweep.Sweeper{
+		Querier: q,
+		Source: sweep.SourceFunc(func(context.Context) ([]sweep.Item, error) {
+			return []sweep.Item{{ID: "a", Reference: "a", CreatedAt: time.Now()}}, nil
+		}),
+	}
+
+	first := make(chan error)
+	go func() { first <- s.Sweep(ctx) }()
+	<-q.started
+
+	second := make(chan error)
+	go func() { second <- s.Sweep(ctx) }()
+
+	select {
+	case err := <-second:
+		assert.Nil(t, err, fmt.Sprintf("concurrent sweep: expected no error got %v\n", err))
+	case <-time.After(time.Second):
+		t.Fatal("concurrent sweep: waited for the query of the first sweep")
+	}
+
+	close(q.release)
+	assert.Nil(t, <-first)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&q.queries), "concurrent sweep: expected the busy item to be skipped")
+}
+
+func TestSweepJournaledQuery(t *testing.T) {
+	ctx := context.Background()
+
+	l := ledger.New(ledger.NewMemory())
+	l.Put(ctx, &ledger.Transaction{ID: "a", TransactionID: "tx-a", Status: ledger.Pending, CreatedAt: time.Now()})
+
+	api := mpesatest.NewAPI().On(mpesa.OpQueryTransactionStatus, mpesatest.Reply{
+		Status: http.StatusOK,
+		Body:   `{"output_ResponseCode":"INS-0","output_ResponseTransactionStatus":"Completed"}`,
+	})
+	app := mpesatest.NewApplication(t, api, mpesa.WithJournal(l))
+
+	s := &sweep.Sweeper{Querier: app, Source: sweep.LedgerSource(l), OnUpdate: sweep.LedgerUpdate(l)}
+	if err := s.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ := l.Get(ctx, "a")
+	assert.Equal(t, ledger.Completed, tx.Status)
+	assert.Equal(t, 1, len(tx.Updates), fmt.Sprintf("expected the journaled query to be recorded once, got %d updates\n", len(tx.Updates)))
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import "context"
+
+// Span attribute keys set by an Application on every operation span.
+const (
+	AttrMarket         = "mpesa.market"
+	AttrEnvironment    = "mpesa.environment"
+	AttrResponseCode   = "mpesa.response_code"
+	AttrConversationID = "mpesa.conversation_id"
+	AttrHTTPStatus     = "http.status_code"
+)
+
+// Tracer opens a span for every operation an Application performs.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+
+	// Start opens a span named after operation as a child of any span
+	// carried by ctx, and returns a context carrying the new span.
+	Start(ctx context.Context, operation string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+
+	// SetAttribute annotates the span.
+	SetAttribute(key, value string)
+
+	// TraceParent returns the W3C traceparent header value identifying the
+	// span, or an empty string when the span should not be propagated.
+	TraceParent() string
+
+	// End finishes the span, err is the error the operation returned if any.
+	End(err error)
+}
+
+// conversationIDer is implemented by API responses carrying output_ConversationID.
+type conversationIDer interface {
+	conversationID() string
+}
+
+var _ Tracer = nopTracer{}
+
+// nopTracer is used when no Tracer has been configured.
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, string) {}
+
+func (nopSpan) TraceParent() string { return "" }
+
+func (nopSpan) End(error) {}
+
+// WithTracer opens a span through t around every operation and injects the
+// span's traceparent header into the outbound request.
+func WithTracer(t Tracer) Option {
+	return func(app *Application) {
+		if t != nil {
+			app.tracer = t
+		}
+	}
+}
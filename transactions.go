@@ -0,0 +1,245 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Operation names used in metrics, traces and limits.
+const (
+	OpC2B                    = "c2bPayment"
+	OpB2C                    = "b2cPayment"
+	OpB2B                    = "b2bPayment"
+	OpReversal               = "reversal"
+	OpQueryTransactionStatus = "queryTransactionStatus"
+	OpQueryBeneficiaryName   = "queryBeneficiaryName"
+)
+
+var marketCountry = map[Market][2]string{
+	VodacomTanzania: {"TZN", "TZS"},
+	VodafoneGHANA:   {"GHA", "GHS"},
+}
+
+// Country returns the country code of the market.
+func (m Market) Country() string {
+	return marketCountry[m][0]
+}
+
+// Currency returns the currency code of the market.
+func (m Market) Currency() string {
+	return marketCountry[m][1]
+}
+
+// The request and response types are generated from openapi.yaml into
+// types_gen.go, see cmd/mpesa-gen.
+
+func (r *TransactionResp) responseCode() string { return r.Code }
+
+func (r *TransactionResp) conversationID() string { return r.ConversationID }
+
+func (r *ReversalResp) responseCode() string { return r.Code }
+
+func (r *ReversalResp) conversationID() string { return r.ConversationID }
+
+func (r *StatusResp) responseCode() string { return r.Code }
+
+func (r *StatusResp) conversationID() string { return r.ConversationID }
+
+func (r *BeneficiaryResp) responseCode() string { return r.Code }
+
+func (r *BeneficiaryResp) conversationID() string { return r.ConversationID }
+
+// C2B collects a customer payment.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/c2bPayment/singleStage/
+func (app *Application) C2B(ctx context.Context, p C2BPayment) (*TransactionResp, error) {
+	app.fill(&p.Country, &p.Currency, &p.ServiceProviderCode, &p.ThirdPartyConversationID)
+
+	var resp TransactionResp
+	if err := app.call(ctx, OpC2B, http.MethodPost, "c2bPayment/singleStage/", p, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// B2C pays a customer.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/b2cPayment/
+func (app *Application) B2C(ctx context.Context, p B2CPayment) (*TransactionResp, error) {
+	app.fill(&p.Country, &p.Currency, &p.ServiceProviderCode, &p.ThirdPartyConversationID)
+
+	var resp TransactionResp
+	if err := app.call(ctx, OpB2C, http.MethodPost, "b2cPayment/", p, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// B2B pays another business.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/b2bPayment/
+func (app *Application) B2B(ctx context.Context, p B2BPayment) (*TransactionResp, error) {
+	app.fill(&p.Country, &p.Currency, &p.PrimaryPartyCode, &p.ThirdPartyConversationID)
+
+	var resp TransactionResp
+	if err := app.call(ctx, OpB2B, http.MethodPost, "b2bPayment/", p, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// Reverse reverses a transaction.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/reversal/
+func (app *Application) Reverse(ctx context.Context, r Reversal) (*ReversalResp, error) {
+	app.fill(&r.Country, nil, &r.ServiceProviderCode, &r.ThirdPartyConversationID)
+
+	var resp ReversalResp
+	if err := app.call(ctx, OpReversal, http.MethodPut, "reversal/", r, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// QueryTransactionStatus queries the status of a transaction.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/queryTransactionStatus/
+func (app *Application) QueryTransactionStatus(ctx context.Context, q StatusQuery) (*StatusResp, error) {
+	app.fill(&q.Country, nil, &q.ServiceProviderCode, &q.ThirdPartyConversationID)
+
+	params := url.Values{}
+	params.Set("input_QueryReference", q.QueryReference)
+	params.Set("input_Country", q.Country)
+	params.Set("input_ServiceProviderCode", q.ServiceProviderCode)
+	params.Set("input_ThirdPartyConversationID", q.ThirdPartyConversationID)
+
+	var resp StatusResp
+	if err := app.call(ctx, OpQueryTransactionStatus, http.MethodGet, "queryTransactionStatus/?"+params.Encode(), q, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// QueryBeneficiaryName looks up the registered name of a customer.
+// Endpoint /[api_enviroment]/ipg/v2/[market]/queryBeneficiaryName/
+func (app *Application) QueryBeneficiaryName(ctx context.Context, q BeneficiaryQuery) (*BeneficiaryResp, error) {
+	app.fill(&q.Country, nil, &q.ServiceProviderCode, &q.ThirdPartyConversationID)
+
+	params := url.Values{}
+	params.Set("input_CustomerMSISDN", q.CustomerMSISDN)
+	params.Set("input_Country", q.Country)
+	params.Set("input_ServiceProviderCode", q.ServiceProviderCode)
+	params.Set("input_KycQueryType", q.KycQueryType)
+	params.Set("input_ThirdPartyConversationID", q.ThirdPartyConversationID)
+
+	var resp BeneficiaryResp
+	if err := app.call(ctx, OpQueryBeneficiaryName, http.MethodGet, "queryBeneficiaryName/?"+params.Encode(), q, &resp); err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+// endpoint returns the URL of path for the application's environment and market.
+func (app *Application) endpoint(path string) string {
+	return fmt.Sprintf("%s/%s/ipg/v2/%s/%s", baseURL, app.Type, app.market, path)
+}
+
+// call sends input to path on behalf of operation op and decodes the response into v.
+// input is sent as the request body unless method is GET, in which case path carries
+// it as query parameters. The exchange is recorded to the journal when one is configured,
+// and its events are emitted.
+func (app *Application) call(ctx context.Context, op, method, path string, input, v interface{}) error {
+	if v, ok := input.(validator); ok && app.validate {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+
+	payload := input
+	if method == http.MethodGet {
+		payload = nil
+	}
+
+	start := time.Now()
+	app.emitOperation(ctx, op, input, nil, nil)
+
+	req, err := app.newRequest(ctx, method, app.endpoint(path), payload)
+
+	journal := app.journal
+	if err == nil {
+		if j, ok := journal.(JournalInitiator); ok {
+			if err = j.Initiate(ctx, JournalEntry{Operation: op, Request: input, Start: start}); err != nil {
+				// rejected before it was sent
+				journal = nil
+			}
+		}
+	}
+
+	if err == nil {
+		err = app.send(op, req, v)
+	}
+
+	if journal != nil {
+		journal.Record(ctx, JournalEntry{
+			Operation: op,
+			Request:   input,
+			Response:  v,
+			Err:       err,
+			Start:     start,
+			End:       time.Now(),
+		})
+	}
+
+	// emitted once journaled, so that subscribers find the journal up to date
+	app.emitOperation(ctx, op, input, v, err)
+
+	return err
+}
+
+// fill sets empty request fields to the application's defaults, nil fields are skipped.
+func (app *Application) fill(country, currency, serviceProviderCode, conversationID *string) {
+	if country != nil && *country == "" {
+		*country = app.market.Country()
+	}
+
+	if currency != nil && *currency == "" {
+		*currency = app.market.Currency()
+	}
+
+	if serviceProviderCode != nil && *serviceProviderCode == "" {
+		*serviceProviderCode = app.ServiceProviderCode
+	}
+
+	if conversationID != nil && *conversationID == "" {
+		*conversationID = NewConversationID()
+	}
+}
+
+// NewConversationID returns a random 32 character third party conversation ID.
+func NewConversationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
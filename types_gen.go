@@ -0,0 +1,319 @@
+// Code generated by mpesa-gen from openapi.yaml. DO NOT EDIT.
+
+package mpesa
+
+import "regexp"
+
+// Response codes returned in output_ResponseCode and input_ResultCode.
+const (
+	// Request processed successfully, HTTP 201.
+	CodeSuccess = "INS-0"
+	// Internal Error, HTTP 500.
+	CodeInternalError = "INS-1"
+	// Invalid API Key, HTTP 401.
+	CodeInvalidAPIKey = "INS-2"
+	// User is not active, HTTP 401.
+	CodeUserNotActive = "INS-4"
+	// Transaction cancelled by customer, HTTP 422.
+	CodeCancelledByCustomer = "INS-5"
+	// Transaction Failed, HTTP 422.
+	CodeTransactionFailed = "INS-6"
+	// Request timeout, HTTP 408.
+	CodeRequestTimeout = "INS-9"
+	// Duplicate Transaction, HTTP 409.
+	CodeDuplicateTransaction = "INS-10"
+	// Invalid Shortcode Used, HTTP 400.
+	CodeInvalidShortcode = "INS-13"
+	// Invalid Reference Used, HTTP 400.
+	CodeInvalidReference = "INS-14"
+	// Invalid Amount Used, HTTP 400.
+	CodeInvalidAmount = "INS-15"
+	// Unable to handle the request due to a temporary overloading, HTTP 503.
+	CodeTemporaryOverload = "INS-16"
+	// Invalid Transaction Reference. Length Should Be Between 1 and 20, HTTP 400.
+	CodeInvalidTransactionReference = "INS-17"
+	// Invalid TransactionID Used, HTTP 400.
+	CodeInvalidTransactionID = "INS-18"
+	// Invalid ThirdPartyConversationID Used, HTTP 400.
+	CodeInvalidThirdPartyConversationID = "INS-19"
+	// Not All Parameters Provided. Please try again, HTTP 400.
+	CodeMissingParameters = "INS-20"
+	// Parameter validations failed. Please try again, HTTP 400.
+	CodeInvalidParameters = "INS-21"
+	// Invalid Operation Type, HTTP 400.
+	CodeInvalidOperationType = "INS-22"
+	// Unknown Status. Contact M-Pesa Support, HTTP 400.
+	CodeUnknownStatus = "INS-23"
+	// Invalid InitiatorIdentifier Used, HTTP 400.
+	CodeInvalidInitiatorIdentifier = "INS-24"
+	// Invalid SecurityCredential Used, HTTP 400.
+	CodeInvalidSecurityCredential = "INS-25"
+	// Not authorized, HTTP 400.
+	CodeNotAuthorized = "INS-26"
+	// Direct Debit Missing, HTTP 400.
+	CodeDirectDebitMissing = "INS-993"
+	// Direct Debit Already Exists, HTTP 400.
+	CodeDirectDebitExists = "INS-994"
+	// Customer's Profile Has Problems, HTTP 400.
+	CodeCustomerProfileProblem = "INS-995"
+	// Customer Account Status Not Active, HTTP 400.
+	CodeCustomerAccountNotActive = "INS-996"
+	// Linking Transaction Not Found, HTTP 400.
+	CodeLinkingTransactionNotFound = "INS-997"
+	// Invalid Market, HTTP 400.
+	CodeInvalidMarket = "INS-998"
+	// Initiator authentication error, HTTP 400.
+	CodeInitiatorAuthentication = "INS-2001"
+	// Receiver invalid, HTTP 400.
+	CodeReceiverInvalid = "INS-2002"
+	// Insufficient balance, HTTP 422.
+	CodeInsufficientBalance = "INS-2006"
+	// MSISDN invalid, HTTP 400.
+	CodeInvalidMSISDN = "INS-2051"
+	// Language code invalid, HTTP 400.
+	CodeInvalidLanguageCode = "INS-2057"
+)
+
+// B2BPayment transfers funds between two business shortcodes.
+// Country, Currency, PrimaryPartyCode and ThirdPartyConversationID are
+// filled in from the application when left empty.
+type B2BPayment struct {
+	Amount                   string `json:"input_Amount"`
+	Country                  string `json:"input_Country"`
+	Currency                 string `json:"input_Currency"`
+	PrimaryPartyCode         string `json:"input_PrimaryPartyCode"`
+	ReceiverPartyCode        string `json:"input_ReceiverPartyCode"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+	TransactionReference     string `json:"input_TransactionReference"`
+	PurchasedItemsDesc       string `json:"input_PurchasedItemsDesc"`
+}
+
+var rulesB2BPayment = []fieldRule{
+	{name: "input_Amount", required: true, pattern: patternAmount},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_Currency", required: true, enum: []string{"TZS", "GHS"}},
+	{name: "input_PrimaryPartyCode", required: true, pattern: patternShortCode},
+	{name: "input_ReceiverPartyCode", required: true, pattern: patternShortCode},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+	{name: "input_TransactionReference", required: true, minLength: 1, maxLength: 20},
+	{name: "input_PurchasedItemsDesc", required: true, maxLength: 256},
+}
+
+// Validate checks r against the rules of the B2BPaymentRequest schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r B2BPayment) Validate() error {
+	return validate(rulesB2BPayment, r.Amount, r.Country, r.Currency, r.PrimaryPartyCode, r.ReceiverPartyCode, r.ThirdPartyConversationID, r.TransactionReference, r.PurchasedItemsDesc)
+}
+
+// B2CPayment pays funds into a customer's mobile money wallet.
+// Country, Currency, ServiceProviderCode and ThirdPartyConversationID are
+// filled in from the application when left empty.
+type B2CPayment struct {
+	Amount                   string `json:"input_Amount"`
+	Country                  string `json:"input_Country"`
+	Currency                 string `json:"input_Currency"`
+	CustomerMSISDN           string `json:"input_CustomerMSISDN"`
+	ServiceProviderCode      string `json:"input_ServiceProviderCode"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+	TransactionReference     string `json:"input_TransactionReference"`
+	PaymentItemsDesc         string `json:"input_PaymentItemsDesc"`
+}
+
+var rulesB2CPayment = []fieldRule{
+	{name: "input_Amount", required: true, pattern: patternAmount},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_Currency", required: true, enum: []string{"TZS", "GHS"}},
+	{name: "input_CustomerMSISDN", required: true, pattern: patternMSISDN},
+	{name: "input_ServiceProviderCode", required: true, pattern: patternShortCode},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+	{name: "input_TransactionReference", required: true, minLength: 1, maxLength: 20},
+	{name: "input_PaymentItemsDesc", required: true, maxLength: 256},
+}
+
+// Validate checks r against the rules of the B2CPaymentRequest schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r B2CPayment) Validate() error {
+	return validate(rulesB2CPayment, r.Amount, r.Country, r.Currency, r.CustomerMSISDN, r.ServiceProviderCode, r.ThirdPartyConversationID, r.TransactionReference, r.PaymentItemsDesc)
+}
+
+// BeneficiaryQuery looks up the registered name of a customer.
+// Country, ServiceProviderCode and ThirdPartyConversationID are filled in
+// from the application when left empty.
+type BeneficiaryQuery struct {
+	CustomerMSISDN           string `json:"input_CustomerMSISDN"`
+	Country                  string `json:"input_Country"`
+	ServiceProviderCode      string `json:"input_ServiceProviderCode"`
+	KycQueryType             string `json:"input_KycQueryType"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+}
+
+var rulesBeneficiaryQuery = []fieldRule{
+	{name: "input_CustomerMSISDN", required: true, pattern: patternMSISDN},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_ServiceProviderCode", required: true, pattern: patternShortCode},
+	{name: "input_KycQueryType", required: true, enum: []string{"Name"}},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+}
+
+// Validate checks r against the rules of the BeneficiaryQuery schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r BeneficiaryQuery) Validate() error {
+	return validate(rulesBeneficiaryQuery, r.CustomerMSISDN, r.Country, r.ServiceProviderCode, r.KycQueryType, r.ThirdPartyConversationID)
+}
+
+// BeneficiaryResp is returned by QueryBeneficiaryName.
+type BeneficiaryResp struct {
+	Code                     string `json:"output_ResponseCode"`
+	Description              string `json:"output_ResponseDesc"`
+	CustomerFirstName        string `json:"output_CustomerFirstName"`
+	CustomerLastName         string `json:"output_CustomerLastName"`
+	ConversationID           string `json:"output_ConversationID"`
+	ThirdPartyConversationID string `json:"output_ThirdPartyConversationID"`
+
+	// Meta is the HTTP exchange the response was decoded from.
+	Meta *Meta `json:"-"`
+}
+
+func (r *BeneficiaryResp) setMeta(m *Meta) { r.Meta = m }
+
+// C2BPayment collects funds from a customer's mobile money wallet.
+// Country, Currency, ServiceProviderCode and ThirdPartyConversationID are
+// filled in from the application when left empty.
+type C2BPayment struct {
+	Amount                   string `json:"input_Amount"`
+	Country                  string `json:"input_Country"`
+	Currency                 string `json:"input_Currency"`
+	CustomerMSISDN           string `json:"input_CustomerMSISDN"`
+	ServiceProviderCode      string `json:"input_ServiceProviderCode"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+	TransactionReference     string `json:"input_TransactionReference"`
+	PurchasedItemsDesc       string `json:"input_PurchasedItemsDesc"`
+}
+
+var rulesC2BPayment = []fieldRule{
+	{name: "input_Amount", required: true, pattern: patternAmount},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_Currency", required: true, enum: []string{"TZS", "GHS"}},
+	{name: "input_CustomerMSISDN", required: true, pattern: patternMSISDN},
+	{name: "input_ServiceProviderCode", required: true, pattern: patternShortCode},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+	{name: "input_TransactionReference", required: true, minLength: 1, maxLength: 20},
+	{name: "input_PurchasedItemsDesc", required: true, maxLength: 256},
+}
+
+// Validate checks r against the rules of the C2BPaymentRequest schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r C2BPayment) Validate() error {
+	return validate(rulesC2BPayment, r.Amount, r.Country, r.Currency, r.CustomerMSISDN, r.ServiceProviderCode, r.ThirdPartyConversationID, r.TransactionReference, r.PurchasedItemsDesc)
+}
+
+// Reversal reverses a successful transaction.
+// Country, ServiceProviderCode and ThirdPartyConversationID are filled in
+// from the application when left empty.
+type Reversal struct {
+	ReversalAmount           string `json:"input_ReversalAmount"`
+	Country                  string `json:"input_Country"`
+	ServiceProviderCode      string `json:"input_ServiceProviderCode"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+	TransactionID            string `json:"input_TransactionID"`
+}
+
+var rulesReversal = []fieldRule{
+	{name: "input_ReversalAmount", required: true, pattern: patternAmount},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_ServiceProviderCode", required: true, pattern: patternShortCode},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+	{name: "input_TransactionID", required: true, pattern: patternTransactionID},
+}
+
+// Validate checks r against the rules of the ReversalRequest schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r Reversal) Validate() error {
+	return validate(rulesReversal, r.ReversalAmount, r.Country, r.ServiceProviderCode, r.ThirdPartyConversationID, r.TransactionID)
+}
+
+// ReversalResp is returned by Reverse.
+type ReversalResp struct {
+	Code                     string `json:"output_ResponseCode"`
+	Description              string `json:"output_ResponseDesc"`
+	TransactionID            string `json:"output_TransactionID"`
+	ConversationID           string `json:"output_ConversationID"`
+	ThirdPartyConversationID string `json:"output_ThirdPartyConversationID"`
+
+	// Meta is the HTTP exchange the response was decoded from.
+	Meta *Meta `json:"-"`
+}
+
+func (r *ReversalResp) setMeta(m *Meta) { r.Meta = m }
+
+// getSessionResp is returned by getSession.
+type getSessionResp struct {
+	// The response code for the transaction.
+	Code string `json:"output_ResponseCode"`
+
+	// The response description for the transaction.
+	Description string `json:"output_ResponseDesc"`
+
+	// The SessionKey that can be used to call other APIs.
+	SessionID string `json:"output_SessionID"`
+}
+
+// StatusQuery queries the status of a transaction. QueryReference is a
+// transaction ID, conversation ID or third party conversation ID.
+// Country, ServiceProviderCode and ThirdPartyConversationID are filled in
+// from the application when left empty.
+type StatusQuery struct {
+	QueryReference           string `json:"input_QueryReference"`
+	Country                  string `json:"input_Country"`
+	ServiceProviderCode      string `json:"input_ServiceProviderCode"`
+	ThirdPartyConversationID string `json:"input_ThirdPartyConversationID"`
+}
+
+var rulesStatusQuery = []fieldRule{
+	{name: "input_QueryReference", required: true, minLength: 1},
+	{name: "input_Country", required: true, enum: []string{"TZN", "GHA"}},
+	{name: "input_ServiceProviderCode", required: true, pattern: patternShortCode},
+	{name: "input_ThirdPartyConversationID", required: true, pattern: patternThirdPartyConversationID},
+}
+
+// Validate checks r against the rules of the StatusQuery schema, it returns a
+// *ValidationError for the first field breaking one.
+func (r StatusQuery) Validate() error {
+	return validate(rulesStatusQuery, r.QueryReference, r.Country, r.ServiceProviderCode, r.ThirdPartyConversationID)
+}
+
+// StatusResp is returned by QueryTransactionStatus.
+type StatusResp struct {
+	Code                      string `json:"output_ResponseCode"`
+	Description               string `json:"output_ResponseDesc"`
+	ResponseTransactionStatus string `json:"output_ResponseTransactionStatus"`
+	ConversationID            string `json:"output_ConversationID"`
+	ThirdPartyConversationID  string `json:"output_ThirdPartyConversationID"`
+
+	// Meta is the HTTP exchange the response was decoded from.
+	Meta *Meta `json:"-"`
+}
+
+func (r *StatusResp) setMeta(m *Meta) { r.Meta = m }
+
+// TransactionResp is returned by C2B, B2C and B2B payments.
+type TransactionResp struct {
+	Code                     string `json:"output_ResponseCode"`
+	Description              string `json:"output_ResponseDesc"`
+	TransactionID            string `json:"output_TransactionID"`
+	ConversationID           string `json:"output_ConversationID"`
+	ThirdPartyConversationID string `json:"output_ThirdPartyConversationID"`
+
+	// Meta is the HTTP exchange the response was decoded from.
+	Meta *Meta `json:"-"`
+}
+
+func (r *TransactionResp) setMeta(m *Meta) { r.Meta = m }
+
+var (
+	patternAmount                   = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+	patternMSISDN                   = regexp.MustCompile(`^[0-9]{12}$`)
+	patternShortCode                = regexp.MustCompile(`^[0-9]{5,6}$`)
+	patternThirdPartyConversationID = regexp.MustCompile(`^[0-9A-Za-z]{1,40}$`)
+	patternTransactionID            = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+)
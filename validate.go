@@ -0,0 +1,126 @@
+/*
+ * Copyright 2020 Infolabs Inc & Associates
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package mpesa
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/mobilemoney/mpesa/pkg/errors"
+)
+
+//go:generate go run ./cmd/mpesa-gen -spec openapi.yaml -o types_gen.go
+
+// ErrInvalidRequest is wrapped by every *ValidationError.
+var ErrInvalidRequest = errors.New("mpesa: invalid request")
+
+// ValidationError reports a request field breaking a rule of openapi.yaml,
+// it is returned by the Validate methods of the request types.
+type ValidationError struct {
+
+	// Field is the JSON name of the field, e.g. input_Amount.
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrInvalidRequest, e.Field, e.Reason)
+}
+
+var _ errors.Error = (*ValidationError)(nil)
+
+// Msg returns the message of ErrInvalidRequest, so that errors.Contains
+// finds it in the error.
+func (e *ValidationError) Msg() string {
+	return ErrInvalidRequest.Msg()
+}
+
+// Err returns the field and the rule it breaks.
+func (e *ValidationError) Err() errors.Error {
+	return errors.New(e.Field + " " + e.Reason)
+}
+
+// Unwrap returns ErrInvalidRequest.
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// validator is implemented by the request types.
+type validator interface {
+	Validate() error
+}
+
+// WithRequestValidation validates every request before sending it, so that
+// requests breaking the rules of openapi.yaml fail with a *ValidationError
+// instead of an API response code. Requests are validated once the
+// application filled in its defaults.
+func WithRequestValidation() Option {
+	return func(app *Application) {
+		app.validate = true
+	}
+}
+
+// fieldRule is the validation rule of a string field, generated from its
+// schema.
+type fieldRule struct {
+	name      string
+	required  bool
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+	enum      []string
+}
+
+// validate checks values against rules, in order.
+func validate(rules []fieldRule, values ...string) error {
+	for i, r := range rules {
+		if err := r.check(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r fieldRule) check(v string) error {
+	if v == "" {
+		if r.required {
+			return &ValidationError{Field: r.name, Reason: "is required"}
+		}
+		return nil
+	}
+
+	if n := utf8.RuneCountInString(v); n < r.minLength {
+		return &ValidationError{Field: r.name, Reason: fmt.Sprintf("is shorter than %d characters", r.minLength)}
+	} else if r.maxLength > 0 && n > r.maxLength {
+		return &ValidationError{Field: r.name, Reason: fmt.Sprintf("is longer than %d characters", r.maxLength)}
+	}
+
+	if r.pattern != nil && !r.pattern.MatchString(v) {
+		return &ValidationError{Field: r.name, Reason: fmt.Sprintf("%q does not match %s", v, r.pattern)}
+	}
+
+	if len(r.enum) > 0 {
+		for _, e := range r.enum {
+			if v == e {
+				return nil
+			}
+		}
+		return &ValidationError{Field: r.name, Reason: fmt.Sprintf("%q is not one of %v", v, r.enum)}
+	}
+	return nil
+}